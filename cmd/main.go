@@ -1,19 +1,63 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"subscription-mailing-service/http-server/handlers/admin"
+	"subscription-mailing-service/http-server/handlers/apikey"
+	authHandlers "subscription-mailing-service/http-server/handlers/auth"
+	"subscription-mailing-service/http-server/handlers/campaign"
+	"subscription-mailing-service/http-server/handlers/levelnotification"
 	"subscription-mailing-service/http-server/handlers/mail"
 	"subscription-mailing-service/http-server/handlers/message"
+	"subscription-mailing-service/http-server/handlers/plan"
+	preferencesHandlers "subscription-mailing-service/http-server/handlers/preferences"
+	"subscription-mailing-service/http-server/handlers/schedule"
 	"subscription-mailing-service/http-server/handlers/subscription"
+	"subscription-mailing-service/http-server/handlers/suppression"
+	"subscription-mailing-service/http-server/handlers/topic"
+	unsubscribeHandlers "subscription-mailing-service/http-server/handlers/unsubscribe"
 	"subscription-mailing-service/http-server/handlers/user"
+	"subscription-mailing-service/http-server/middleware"
+	"subscription-mailing-service/internal/auth"
+	"subscription-mailing-service/internal/composer"
 	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/delivery"
+	"subscription-mailing-service/internal/dispatcher"
+	"subscription-mailing-service/internal/optin"
+	"subscription-mailing-service/internal/preferences"
+	"subscription-mailing-service/internal/progression"
+	"subscription-mailing-service/internal/renewal"
+	"subscription-mailing-service/internal/scheduler"
+	"subscription-mailing-service/internal/sender"
+	"subscription-mailing-service/internal/subscriberlevel"
+	"subscription-mailing-service/internal/templating"
+	"subscription-mailing-service/internal/token"
+	"subscription-mailing-service/internal/unsubscribe"
+	apikey2 "subscription-mailing-service/storage/apikey"
+	campaign2 "subscription-mailing-service/storage/campaign"
+	storageErrors "subscription-mailing-service/storage/errors"
+	levelnotification2 "subscription-mailing-service/storage/levelnotification"
 	mail2 "subscription-mailing-service/storage/mail"
 	message2 "subscription-mailing-service/storage/message"
+	"subscription-mailing-service/storage/outbox"
+	"subscription-mailing-service/storage/passwordreset"
+	plan2 "subscription-mailing-service/storage/plan"
+	"subscription-mailing-service/storage/refreshtoken"
+	schedule2 "subscription-mailing-service/storage/schedule"
 	subscriber2 "subscription-mailing-service/storage/subscriber"
+	suppression2 "subscription-mailing-service/storage/suppression"
+	topic2 "subscription-mailing-service/storage/topic"
 	user2 "subscription-mailing-service/storage/user"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -34,17 +78,70 @@ func main() {
 	}
 	defer userStorage.Close()
 
-	userHandler := user.NewHandler(userStorage, logger)
+	if cfg.Auth.Admin.Login != "" && cfg.Auth.Admin.Password != "" {
+		if len(cfg.Auth.Admin.Password) < auth.MinPasswordLength {
+			logger.Error("Admin password is too short", slog.Int("min_length", auth.MinPasswordLength))
+			os.Exit(1)
+		}
+
+		created, err := userStorage.EnsureAdmin(context.Background(), cfg.Auth.Admin.Login, cfg.Auth.Admin.Email, cfg.Auth.Admin.Password)
+		switch {
+		case errors.Is(err, storageErrors.ErrDuplicateLogin):
+			logger.Error("Admin not created, its login belongs to another user", slog.String("login", cfg.Auth.Admin.Login))
+		case err != nil:
+			logger.Error("Failed to create admin", slog.Any("error", err))
+			os.Exit(1)
+		case created:
+			logger.Info("Created admin from config", slog.String("login", cfg.Auth.Admin.Login))
+		}
+	}
+
+	refreshTokenStorage, err := refreshtoken.NewRefreshTokenStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize refresh token storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer refreshTokenStorage.Close()
+
+	apiKeyStorage, err := apikey2.NewAPIKeyStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize API key storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer apiKeyStorage.Close()
+
+	authService, err := auth.NewService(userStorage, refreshTokenStorage, apiKeyStorage, cfg)
+	if err != nil {
+		logger.Error("Failed to initialize authentication", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	authRequired := middleware.Authenticate(authService, logger)
+	userHandler := user.NewHandler(userStorage, authService, logger)
+	apiKeyHandler := apikey.NewHandler(apiKeyStorage, authService, logger)
 
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Error("Invalid trusted proxies", slog.Any("error", err))
+		os.Exit(1)
+	}
 
-	userRoutes := router.Group("/api/users")
+	apiKeyRoutes := router.Group("/api/apikeys", authRequired, middleware.Authorize("apikeys", nil, logger))
+	{
+		apiKeyRoutes.GET("/getall", apiKeyHandler.GetAllAPIKeys())
+		apiKeyRoutes.POST("/create", apiKeyHandler.CreateAPIKey())
+		apiKeyRoutes.POST("/revoke/:id", apiKeyHandler.RevokeAPIKey())
+	}
+
+	userRoutes := router.Group("/api/users", authRequired, middleware.Authorize("users", middleware.UserOwner, logger))
 	{
 		userRoutes.GET("/getall", userHandler.GetAllUsers())
 		userRoutes.GET("/get/:id", userHandler.GetUserID())
 		userRoutes.POST("/create", userHandler.CreateUser())
 		userRoutes.PUT("/update/:id", userHandler.UpdateUser())
 		userRoutes.DELETE("/delete/:id", userHandler.DeleteUser())
+		userRoutes.PUT("/role/:id", userHandler.SetUserRole())
+		userRoutes.PUT("/:id/password", userHandler.ChangePassword())
 	}
 
 	subscriberStorage, err := subscriber2.NewSubscriberStorage(cfg)
@@ -54,17 +151,40 @@ func main() {
 	}
 	defer subscriberStorage.Close()
 
-	subscriberHandler := subscription.NewHandler(subscriberStorage, logger)
+	topicStorage, err := topic2.NewTopicStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize topic storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer topicStorage.Close()
+
+	topicHandler := topic.NewHandler(topicStorage, logger)
 
-	subscriberRoutes := router.Group("/api/subscribers")
+	topicRoutes := router.Group("/api/topics", authRequired, middleware.Authorize("topics", nil, logger))
 	{
-		subscriberRoutes.GET("/getall", subscriberHandler.GetAllSubscribers())
-		subscriberRoutes.GET("/get/:id", subscriberHandler.GetSubscriberID())
-		subscriberRoutes.POST("/create", subscriberHandler.CreateSubscriber())
-		subscriberRoutes.PUT("/update/:id", subscriberHandler.UpdateSubscriber())
-		subscriberRoutes.DELETE("/delete/:id", subscriberHandler.DeleteSubscriber())
-		subscriberRoutes.PUT("/updatelevel/:id", subscriberHandler.UpdateSubscriberLevel())
-		subscriberRoutes.GET("/getall/:lvl", subscriberHandler.GetSubscribersByLevel())
+		topicRoutes.GET("/getall", topicHandler.GetAllTopics())
+		topicRoutes.GET("/get/:key", topicHandler.GetTopic())
+		topicRoutes.POST("/create", topicHandler.CreateTopic())
+		topicRoutes.PUT("/update/:key", topicHandler.UpdateTopic())
+		topicRoutes.DELETE("/delete/:key", topicHandler.DeleteTopic())
+	}
+
+	planStorage, err := plan2.NewPlanStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize plan storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer planStorage.Close()
+
+	planHandler := plan.NewHandler(planStorage, topicStorage, logger)
+
+	planRoutes := router.Group("/api/plans", authRequired, middleware.Authorize("plans", nil, logger))
+	{
+		planRoutes.GET("/getall", planHandler.GetAllPlans())
+		planRoutes.GET("/get/:id", planHandler.GetPlanID())
+		planRoutes.POST("/create", planHandler.CreatePlan())
+		planRoutes.PUT("/update/:id", planHandler.UpdatePlan())
+		planRoutes.DELETE("/delete/:id", planHandler.DeletePlan())
 	}
 
 	messageStorage, err := message2.NewMessageStorage(cfg)
@@ -74,15 +194,48 @@ func main() {
 	}
 	defer messageStorage.Close()
 
-	messageHandler := message.NewHandler(messageStorage, logger)
+	smtpSender, err := sender.NewSMTPSender(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize smtp sender", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	suppressionStorage, err := suppression2.NewSuppressionStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize suppression storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer suppressionStorage.Close()
+
+	suppressionHandler := suppression.NewHandler(suppressionStorage, logger)
+
+	suppressionRoutes := router.Group("/api/suppressions", authRequired, middleware.Authorize("suppressions", nil, logger))
+	{
+		suppressionRoutes.GET("/getall", suppressionHandler.GetAllSuppressions())
+		suppressionRoutes.GET("/get/:id", suppressionHandler.GetSuppressionID())
+		suppressionRoutes.POST("/create", suppressionHandler.CreateSuppression())
+		suppressionRoutes.POST("/import", suppressionHandler.ImportSuppressions())
+		suppressionRoutes.PUT("/update/:id", suppressionHandler.UpdateSuppression())
+		suppressionRoutes.DELETE("/delete/:id", suppressionHandler.DeleteSuppression())
+	}
+
+	mailComposer := composer.NewComposer(cfg)
+	renderer := templating.NewRenderer(messageStorage, userStorage, subscriberStorage)
+	messageHandler := message.NewHandler(messageStorage, renderer, smtpSender, mailComposer, suppressionStorage, logger)
 
-	messageRoutes := router.Group("/api/messages")
+	messageRoutes := router.Group("/api/messages", authRequired, middleware.Authorize("messages", nil, logger))
 	{
 		messageRoutes.GET("/getall", messageHandler.GetAllMessages())
 		messageRoutes.GET("/get/:id", messageHandler.GetMessageID())
 		messageRoutes.POST("/create", messageHandler.CreateMessage())
 		messageRoutes.PUT("/update/:id", messageHandler.UpdateMessage())
 		messageRoutes.DELETE("/delete/:id", messageHandler.DeleteMessage())
+		messageRoutes.POST("/:id/preview", messageHandler.PreviewMessage())
+		messageRoutes.POST("/:id/test-send", messageHandler.TestSendMessage())
+		messageRoutes.GET("/:id/versions", messageHandler.GetMessageVersions())
+		messageRoutes.GET("/:id/versions/:version", messageHandler.GetMessageVersion())
+		messageRoutes.GET("/:id/diff", messageHandler.DiffMessageVersions())
+		messageRoutes.POST("/:id/rollback/:version", messageHandler.RollbackMessage())
 	}
 
 	mailStorage, err := mail2.NewMailStorage(cfg)
@@ -92,22 +245,250 @@ func main() {
 	}
 	defer mailStorage.Close()
 
-	mailHandler := mail.NewHandler(mailStorage, logger)
+	outboxStorage, err := outbox.NewOutboxStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize outbox storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer outboxStorage.Close()
+
+	passwordResetStorage, err := passwordreset.NewPasswordResetStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize password reset storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer passwordResetStorage.Close()
+
+	passwordResetter := auth.NewResetter(userStorage, passwordResetStorage, refreshTokenStorage, outboxStorage, cfg, logger)
+	authHandler := authHandlers.NewHandler(authService, passwordResetter, logger)
 
-	mailRoutes := router.Group("/api/mails")
+	authRoutes := router.Group("/api/auth")
+	{
+		authRoutes.POST("/login", authHandler.Login())
+		authRoutes.POST("/refresh", authHandler.Refresh())
+		authRoutes.POST("/logout", authHandler.Logout())
+		authRoutes.POST("/forgot-password", authHandler.ForgotPassword())
+		authRoutes.POST("/reset-password", authHandler.ResetPassword())
+	}
+
+	signer, err := token.NewSigner(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize token signer", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	subscriptionOptIn := optin.NewOptIn(subscriberStorage, userStorage, outboxStorage, signer, cfg, logger)
+	levelEngine, err := subscriberlevel.NewEngine(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize level rules", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	levelNotificationStorage, err := levelnotification2.NewLevelNotificationStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize level notification storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer levelNotificationStorage.Close()
+
+	levelNotifier := progression.NewNotifier(levelNotificationStorage, renderer, outboxStorage)
+	levelProgression := progression.NewProgression(subscriberStorage, levelEngine, levelNotifier, cfg, logger)
+	subscriptionRenewal := renewal.NewRenewal(subscriberStorage, userStorage, outboxStorage, cfg, logger)
+	subscriberHandler := subscription.NewHandler(
+		subscriberStorage,
+		subscriptionOptIn,
+		levelProgression,
+		subscriptionRenewal,
+		logger,
+	)
+	unsubscriber := unsubscribe.NewUnsubscriber(subscriberStorage, outboxStorage, signer, cfg)
+	unsubscribeHandler := unsubscribeHandlers.NewHandler(unsubscriber, logger)
+	preferenceCenter := preferences.NewCenter(topicStorage, signer, cfg)
+	preferencesHandler := preferencesHandlers.NewHandler(preferenceCenter, logger)
+
+	preferenceRoutes := router.Group("/api/preferences")
+	{
+		preferenceRoutes.GET("", preferencesHandler.GetPreferences())
+		preferenceRoutes.POST("", preferencesHandler.UpdatePreferences())
+	}
+
+	// Links mailed to subscribers carry their own signed token.
+	subscriberLinkRoutes := router.Group("/api/subscribers")
+	{
+		subscriberLinkRoutes.GET("/confirm", subscriberHandler.ConfirmSubscriber())
+		subscriberLinkRoutes.GET("/unsubscribe", unsubscribeHandler.ConfirmUnsubscribe())
+		subscriberLinkRoutes.POST("/unsubscribe", unsubscribeHandler.Unsubscribe())
+	}
+
+	subscriberRoutes := router.Group("/api/subscribers", authRequired, middleware.Authorize("subscribers", middleware.SubscriberOwner(subscriberStorage), logger))
+	{
+		subscriberRoutes.GET("/getall", subscriberHandler.GetAllSubscribers())
+		subscriberRoutes.GET("/get/:id", subscriberHandler.GetSubscriberID())
+		subscriberRoutes.POST("/create", subscriberHandler.CreateSubscriber())
+		subscriberRoutes.PUT("/update/:id", subscriberHandler.UpdateSubscriber())
+		subscriberRoutes.PUT("/status/:id", subscriberHandler.TransitionSubscriber())
+		subscriberRoutes.POST("/renew/:id", subscriberHandler.RenewSubscriber())
+		subscriberRoutes.GET("/history/:id", subscriberHandler.GetSubscriberHistory())
+		subscriberRoutes.DELETE("/delete/:id", subscriberHandler.DeleteSubscriber())
+		subscriberRoutes.PUT("/updatelevel/:id", subscriberHandler.UpdateSubscriberLevel())
+		subscriberRoutes.GET("/levelhistory/:id", subscriberHandler.GetSubscriberLevelHistory())
+		subscriberRoutes.GET("/getall/:lvl", subscriberHandler.GetSubscribersByLevel())
+	}
+
+	adminHandler := admin.NewHandler(subscriberStorage, logger)
+
+	adminRoutes := router.Group("/api/admin", authRequired, middleware.Authorize("admin", nil, logger))
+	{
+		adminRoutes.GET("/orphans", adminHandler.GetOrphans())
+		adminRoutes.POST("/orphans/repair", adminHandler.RepairOrphans())
+	}
+
+	levelNotificationHandler := levelnotification.NewHandler(levelNotificationStorage, messageStorage, logger)
+
+	levelNotificationRoutes := router.Group("/api/levelnotifications", authRequired, middleware.Authorize("levelnotifications", nil, logger))
+	{
+		levelNotificationRoutes.GET("/getall", levelNotificationHandler.GetAllLevelNotifications())
+		levelNotificationRoutes.GET("/get/:level", levelNotificationHandler.GetLevelNotification())
+		levelNotificationRoutes.PUT("/update/:level", levelNotificationHandler.UpdateLevelNotification())
+	}
+
+	mailHandler := mail.NewHandler(mailStorage, outboxStorage, messageStorage, mailComposer, logger)
+
+	mailRoutes := router.Group("/api/mails", authRequired, middleware.Authorize("mails", nil, logger))
 	{
 		mailRoutes.GET("/getall", mailHandler.GetAllMails())
 		mailRoutes.GET("/get/:id", mailHandler.GetMailInfo())
+		mailRoutes.GET("/get/:id/recipients", mailHandler.GetMailRecipients())
 		mailRoutes.POST("/create", mailHandler.CreateMail())
 		mailRoutes.POST("/send", mailHandler.SendMail())
+		mailRoutes.GET("/status/:id", mailHandler.GetMailStatus())
+		mailRoutes.GET("/dead", mailHandler.GetDeadMails())
+		mailRoutes.POST("/requeue/:id", mailHandler.RequeueMail())
 		mailRoutes.PUT("/update/:id", mailHandler.UpdateMail())
 		mailRoutes.DELETE("/delete/:id", mailHandler.DeleteMail())
+		mailRoutes.POST("/queue/:id", mailHandler.QueueMail())
+		mailRoutes.POST("/attach/:id", mailHandler.AttachFile())
+		mailRoutes.GET("/get/:id/attachments", mailHandler.GetMailAttachments())
+		mailRoutes.DELETE("/detach/:id/:attachment_id", mailHandler.DetachFile())
 		//mailRoutes.GET("/search/:id", mailHandler.SearchMails())
 	}
 
-	serverAddr := fmt.Sprintf(":%s", cfg.Server.Port)
-	if err := router.Run(serverAddr); err != nil {
-		logger.Error("Failed to start server", slog.Any("error", err))
+	campaignStorage, err := campaign2.NewCampaignStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize campaign storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer campaignStorage.Close()
+
+	campaignDispatcher := dispatcher.NewDispatcher(campaignStorage, outboxStorage)
+	campaignHandler := campaign.NewHandler(campaignStorage, messageStorage, topicStorage, campaignDispatcher, logger)
+
+	campaignRoutes := router.Group("/api/campaigns", authRequired, middleware.Authorize("campaigns", nil, logger))
+	{
+		campaignRoutes.GET("/getall", campaignHandler.GetAllCampaigns())
+		campaignRoutes.GET("/get/:id", campaignHandler.GetCampaignID())
+		campaignRoutes.POST("/create", campaignHandler.CreateCampaign())
+		campaignRoutes.PUT("/update/:id", campaignHandler.UpdateCampaign())
+		campaignRoutes.DELETE("/delete/:id", campaignHandler.DeleteCampaign())
+		campaignRoutes.POST("/send/:id", campaignHandler.SendCampaign())
+		campaignRoutes.GET("/dryrun/:id", campaignHandler.DryRunCampaign())
+		campaignRoutes.POST("/dryrun", campaignHandler.DryRunSegment())
+	}
+
+	scheduleStorage, err := schedule2.NewScheduleStorage(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize schedule storage", slog.Any("error", err))
 		os.Exit(1)
 	}
+	defer scheduleStorage.Close()
+
+	scheduleHandler := schedule.NewHandler(scheduleStorage, campaignStorage, logger)
+
+	scheduleRoutes := router.Group("/api/schedules", authRequired, middleware.Authorize("schedules", nil, logger))
+	{
+		scheduleRoutes.GET("/getall", scheduleHandler.GetAllSchedules())
+		scheduleRoutes.GET("/get/:id", scheduleHandler.GetScheduleID())
+		scheduleRoutes.GET("/runs/:id", scheduleHandler.GetScheduleRuns())
+		scheduleRoutes.POST("/create", scheduleHandler.CreateSchedule())
+		scheduleRoutes.PUT("/pause/:id", scheduleHandler.PauseSchedule())
+		scheduleRoutes.PUT("/resume/:id", scheduleHandler.ResumeSchedule())
+		scheduleRoutes.PUT("/cancel/:id", scheduleHandler.CancelSchedule())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+
+	deliveryPool := delivery.NewPool(
+		outboxStorage,
+		mailStorage,
+		renderer,
+		smtpSender,
+		mailComposer,
+		unsubscriber,
+		preferenceCenter,
+		cfg,
+		logger,
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		deliveryPool.Run(ctx)
+	}()
+
+	campaignScheduler := scheduler.NewScheduler(scheduleStorage, campaignStorage, campaignDispatcher, cfg, logger)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		campaignScheduler.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		subscriptionOptIn.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		levelProgression.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		subscriptionRenewal.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		passwordResetter.Run(ctx)
+	}()
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", cfg.Server.Port),
+		Handler: router,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to start server", slog.Any("error", err))
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shut down server", slog.Any("error", err))
+	}
+
+	wg.Wait()
 }
@@ -22,8 +22,18 @@ CREATE TABLE IF NOT EXISTS subscribers (
     status_subscription VARCHAR(255),
     number_subscriptions INT,
     subscription_time TIMESTAMP,
-    subscriptions_in_row INT
-);`
+    subscriptions_in_row INT,
+    subscriptions_level VARCHAR(255)
+);
+ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS subscriptions_level VARCHAR(255);`
+
+const alterTableSubscribersConfirmationSQL = `
+ALTER TABLE subscribers
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS unsubscribed_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS unsubscribe_reason TEXT;
+CREATE INDEX IF NOT EXISTS subscribers_status_created_at_idx ON subscribers (status_subscription, created_at);`
 
 const initTableMessagesSQL = `
 CREATE TABLE IF NOT EXISTS messages (
@@ -31,6 +41,25 @@ CREATE TABLE IF NOT EXISTS messages (
 	message VARCHAR(255)
 );`
 
+const alterTableMessagesTemplateSQL = `
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS name VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS subject TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS html_body TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS text_body TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'messages' AND column_name = 'message'
+    ) THEN
+        UPDATE messages SET text_body = COALESCE(message, '') WHERE text_body = '';
+        ALTER TABLE messages DROP COLUMN message;
+    END IF;
+END $$;`
+
 const initTableMailsSQL = `
 CREATE TABLE IF NOT EXISTS mails (
     id SERIAL PRIMARY KEY,         
@@ -41,6 +70,347 @@ CREATE TABLE IF NOT EXISTS mails (
     sent_at TIMESTAMP 
 );`
 
+const alterTableMailsDeliverySQL = `
+ALTER TABLE mails
+    ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'draft',
+    ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_error TEXT,
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS mails_status_idx ON mails (status);`
+
+const initTableMailOutboxSQL = `
+CREATE TABLE IF NOT EXISTS mail_outbox (
+    id SERIAL PRIMARY KEY,
+    mail_id INT NOT NULL REFERENCES mails(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    available_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS mail_outbox_status_available_at_idx ON mail_outbox (status, available_at);
+ALTER TABLE mail_outbox ADD COLUMN IF NOT EXISTS claim_token VARCHAR(64);`
+
+// Recipients used to live in a JSONB column of mail_outbox. Its outcomes are
+// carried over, and addresses it has no outcome for are taken from the mail's
+// to_list, before the column is dropped.
+const initTableMailRecipientsSQL = `
+CREATE TABLE IF NOT EXISTS mail_recipients (
+    id SERIAL PRIMARY KEY,
+    mail_id INT NOT NULL REFERENCES mails(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    address VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    message_id VARCHAR(255),
+    error TEXT,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP,
+    UNIQUE (mail_id, address)
+);
+CREATE INDEX IF NOT EXISTS mail_recipients_mail_id_status_idx ON mail_recipients (mail_id, status);
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'mail_outbox' AND column_name = 'recipients'
+    ) THEN
+        INSERT INTO mail_recipients (mail_id, user_id, address, status, error, sent_at)
+        SELECT
+            o.mail_id,
+            (SELECT id FROM users WHERE LOWER(email) = LOWER(r->>'address') ORDER BY id LIMIT 1),
+            r->>'address',
+            CASE
+                WHEN r->>'status' = 'sent' THEN 'sent'
+                WHEN o.status IN ('queued', 'processing') THEN 'pending'
+                ELSE 'failed'
+            END,
+            NULLIF(r->>'error', ''),
+            CASE WHEN r->>'status' = 'sent' THEN o.updated_at END
+        FROM mail_outbox o
+        CROSS JOIN LATERAL jsonb_array_elements(o.recipients) AS r
+        WHERE COALESCE(r->>'address', '') <> ''
+        ON CONFLICT (mail_id, address) DO NOTHING;
+
+        INSERT INTO mail_recipients (mail_id, user_id, address, status)
+        SELECT
+            o.mail_id,
+            (SELECT id FROM users WHERE LOWER(email) = LOWER(addr) ORDER BY id LIMIT 1),
+            addr,
+            CASE
+                WHEN o.status IN ('queued', 'processing') THEN 'pending'
+                WHEN o.status = 'sent' THEN 'sent'
+                ELSE 'failed'
+            END
+        FROM mail_outbox o
+        JOIN mails m ON m.id = o.mail_id
+        CROSS JOIN LATERAL UNNEST(m.to_list) AS addr
+        ON CONFLICT (mail_id, address) DO NOTHING;
+
+        ALTER TABLE mail_outbox DROP COLUMN recipients;
+    END IF;
+END $$;`
+
+const initTableCampaignsSQL = `
+CREATE TABLE IF NOT EXISTS campaigns (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    content_type VARCHAR(50),
+    segment_levels TEXT[] NOT NULL DEFAULT '{}',
+    segment_statuses TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_sent_at TIMESTAMP
+);
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS campaign_id INT REFERENCES campaigns(id) ON DELETE SET NULL;
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS category VARCHAR(50) NOT NULL DEFAULT 'notification';`
+
+const alterTablesTemplateIDSQL = `
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS template_id INT REFERENCES messages(id) ON DELETE RESTRICT;
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS template_id INT REFERENCES messages(id) ON DELETE RESTRICT;`
+
+const initTableMessageVersionsSQL = `
+CREATE TABLE IF NOT EXISTS message_versions (
+    id SERIAL PRIMARY KEY,
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    html_body TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (message_id, version)
+);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+INSERT INTO message_versions (message_id, version, name, subject, html_body, text_body, created_at)
+SELECT m.id, m.version, m.name, m.subject, m.html_body, m.text_body, m.updated_at
+FROM messages m
+WHERE NOT EXISTS (SELECT 1 FROM message_versions v WHERE v.message_id = m.id AND v.version = m.version);
+
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS template_version INT;
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS template_version INT;
+UPDATE mails SET template_version = (SELECT version FROM messages WHERE id = mails.template_id)
+WHERE template_id IS NOT NULL AND template_version IS NULL;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'mails_template_version_fkey') THEN
+        ALTER TABLE mails ADD CONSTRAINT mails_template_version_fkey
+            FOREIGN KEY (template_id, template_version) REFERENCES message_versions (message_id, version);
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'campaigns_template_version_fkey') THEN
+        ALTER TABLE campaigns ADD CONSTRAINT campaigns_template_version_fkey
+            FOREIGN KEY (template_id, template_version) REFERENCES message_versions (message_id, version);
+    END IF;
+END $$;`
+
+const initTableSchedulesSQL = `
+CREATE TABLE IF NOT EXISTS schedules (
+    id SERIAL PRIMARY KEY,
+    campaign_id INT NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    send_at TIMESTAMP,
+    cron_expression VARCHAR(255),
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ((send_at IS NULL) <> (cron_expression IS NULL))
+);
+CREATE INDEX IF NOT EXISTS schedules_status_next_run_at_idx ON schedules (status, next_run_at);
+
+CREATE TABLE IF NOT EXISTS schedule_runs (
+    id SERIAL PRIMARY KEY,
+    schedule_id INT NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMP NOT NULL,
+    mail_id INT REFERENCES mails(id) ON DELETE SET NULL,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (schedule_id, scheduled_for)
+);`
+
+const initTableMailAttachmentsSQL = `
+CREATE TABLE IF NOT EXISTS mail_attachments (
+    id SERIAL PRIMARY KEY,
+    mail_id INT NOT NULL REFERENCES mails(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    content_id VARCHAR(255),
+    size BIGINT NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (mail_id, content_id)
+);
+CREATE INDEX IF NOT EXISTS mail_attachments_mail_id_idx ON mail_attachments (mail_id);`
+
+const initTableSuppressionsSQL = `
+CREATE TABLE IF NOT EXISTS suppressions (
+    id SERIAL PRIMARY KEY,
+    address VARCHAR(255) NOT NULL UNIQUE,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('hard_bounce', 'complaint', 'manual', 'unsubscribed')),
+    source VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);`
+
+const initTableSubscriberTransitionsSQL = `
+ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP;
+UPDATE subscribers SET status_changed_at = created_at WHERE status_changed_at IS NULL;
+ALTER TABLE subscribers ALTER COLUMN status_changed_at SET DEFAULT NOW();
+
+CREATE TABLE IF NOT EXISTS subscriber_transitions (
+    id SERIAL PRIMARY KEY,
+    subscriber_id INT NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE,
+    from_status VARCHAR(255),
+    to_status VARCHAR(255) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS subscriber_transitions_subscriber_id_idx ON subscriber_transitions (subscriber_id, id);`
+
+const initTableSubscriberLevelChangesSQL = `
+CREATE TABLE IF NOT EXISTS subscriber_level_changes (
+    id SERIAL PRIMARY KEY,
+    subscriber_id INT NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE,
+    from_level VARCHAR(255),
+    to_level VARCHAR(255) NOT NULL,
+    rule TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS subscriber_level_changes_subscriber_id_idx ON subscriber_level_changes (subscriber_id, id);`
+
+const alterTableSubscribersRenewalSQL = `
+ALTER TABLE subscribers
+    ADD COLUMN IF NOT EXISTS period_days INT CHECK (period_days > 0),
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS reminded_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS subscribers_expires_at_idx ON subscribers (expires_at) WHERE expires_at IS NOT NULL;`
+
+const initTableLevelNotificationsSQL = `
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS category VARCHAR(20);
+
+CREATE TABLE IF NOT EXISTS level_notifications (
+    level VARCHAR(255) PRIMARY KEY,
+    template_id INT REFERENCES messages(id) ON DELETE SET NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);`
+
+const initTablePlansSQL = `
+CREATE TABLE IF NOT EXISTS plans (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    period_days INT NOT NULL CHECK (period_days > 0),
+    price_cents BIGINT NOT NULL DEFAULT 0 CHECK (price_cents >= 0),
+    topics TEXT[] NOT NULL DEFAULT '{}',
+    max_mails_per_week INT NOT NULL DEFAULT 0 CHECK (max_mails_per_week >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS plan_id INT REFERENCES plans(id) ON DELETE RESTRICT;
+CREATE INDEX IF NOT EXISTS subscribers_plan_id_idx ON subscribers (plan_id);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS topic VARCHAR(100);`
+
+const initTableTopicsSQL = `
+CREATE TABLE IF NOT EXISTS topics (
+    key VARCHAR(100) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    default_opt_in BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+INSERT INTO topics (key, name) VALUES
+    ('advertisement', 'Advertisement'),
+    ('notification', 'Notification')
+ON CONFLICT (key) DO NOTHING;
+INSERT INTO topics (key, name)
+SELECT DISTINCT topic, topic FROM campaigns WHERE topic IS NOT NULL
+ON CONFLICT (key) DO NOTHING;
+INSERT INTO topics (key, name)
+SELECT DISTINCT t, t FROM plans, UNNEST(topics) AS t
+ON CONFLICT (key) DO NOTHING;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'campaigns_topic_fkey') THEN
+        ALTER TABLE campaigns ADD CONSTRAINT campaigns_topic_fkey
+            FOREIGN KEY (topic) REFERENCES topics(key) ON UPDATE CASCADE ON DELETE RESTRICT;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS user_topic_preferences (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    topic VARCHAR(100) NOT NULL REFERENCES topics(key) ON UPDATE CASCADE ON DELETE CASCADE,
+    opted_in BOOLEAN NOT NULL,
+    frequency VARCHAR(20) NOT NULL DEFAULT 'immediate' CHECK (frequency IN ('immediate', 'daily', 'weekly')),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, topic)
+);`
+
+// The user reference is added NOT VALID so that subscriptions orphaned before
+// it existed do not prevent startup; new rows are checked right away and the
+// constraint is validated once the orphans are repaired.
+const alterTableSubscribersUserSQL = `
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'subscribers_user_id_fkey') THEN
+        ALTER TABLE subscribers ADD CONSTRAINT subscribers_user_id_fkey
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE RESTRICT NOT VALID;
+    END IF;
+END $$;
+CREATE INDEX IF NOT EXISTS subscribers_user_id_idx ON subscribers (user_id);`
+
+const initTableRefreshTokensSQL = `
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP,
+    replaced_by INT REFERENCES refresh_tokens(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);`
+
+const alterTableUsersRoleSQL = `
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'subscriber'
+        CHECK (role IN ('admin', 'operator', 'subscriber'));`
+
+const initTableAPIKeysSQL = `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL UNIQUE,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP,
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);`
+
+const initTablePasswordResetsSQL = `
+CREATE TABLE IF NOT EXISTS password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS password_resets_email_idx ON password_resets (email, created_at);`
+
+// Transactional mails are the ones the service sends about a user's own
+// account, such as password resets and confirmation links.
+const alterTableMailsTransactionalSQL = `
+ALTER TABLE mails ADD COLUMN IF NOT EXISTS transactional BOOLEAN NOT NULL DEFAULT FALSE;`
+
 func InitDatabase(db *sql.DB) error {
 	if err := db.Ping(); err != nil {
 		return fmt.Errorf("Failed to connect to database: %w", err)
@@ -56,14 +426,130 @@ func InitDatabase(db *sql.DB) error {
 		return fmt.Errorf("Error creating subscriber table: %w", err)
 	}
 
+	_, err = db.Exec(alterTableSubscribersConfirmationSQL)
+	if err != nil {
+		return fmt.Errorf("Error altering subscriber table: %w", err)
+	}
+
+	_, err = db.Exec(initTableSubscriberTransitionsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating subscriber transition table: %w", err)
+	}
+
+	_, err = db.Exec(initTableSubscriberLevelChangesSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating subscriber level change table: %w", err)
+	}
+
+	_, err = db.Exec(alterTableSubscribersRenewalSQL)
+	if err != nil {
+		return fmt.Errorf("Error adding renewal columns to subscriber table: %w", err)
+	}
+
 	_, err = db.Exec(initTableMessagesSQL)
 	if err != nil {
 		return fmt.Errorf("Error creating message table: %w", err)
 	}
 
+	_, err = db.Exec(alterTableMessagesTemplateSQL)
+	if err != nil {
+		return fmt.Errorf("Error altering message table: %w", err)
+	}
+
 	_, err = db.Exec(initTableMailsSQL)
 	if err != nil {
 		return fmt.Errorf("Error creating mail table: %w", err)
 	}
+
+	_, err = db.Exec(alterTableMailsDeliverySQL)
+	if err != nil {
+		return fmt.Errorf("Error altering mail table: %w", err)
+	}
+
+	_, err = db.Exec(initTableMailOutboxSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating mail outbox table: %w", err)
+	}
+
+	_, err = db.Exec(initTableMailRecipientsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating mail recipient table: %w", err)
+	}
+
+	_, err = db.Exec(initTableSuppressionsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating suppression table: %w", err)
+	}
+
+	_, err = db.Exec(initTableCampaignsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating campaign table: %w", err)
+	}
+
+	_, err = db.Exec(alterTablesTemplateIDSQL)
+	if err != nil {
+		return fmt.Errorf("Error adding template references: %w", err)
+	}
+
+	_, err = db.Exec(initTableMessageVersionsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating message version table: %w", err)
+	}
+
+	_, err = db.Exec(initTableMailAttachmentsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating mail attachment table: %w", err)
+	}
+
+	_, err = db.Exec(initTableSchedulesSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating schedule table: %w", err)
+	}
+
+	_, err = db.Exec(initTableLevelNotificationsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating level notification table: %w", err)
+	}
+
+	_, err = db.Exec(initTablePlansSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating plan table: %w", err)
+	}
+
+	_, err = db.Exec(initTableTopicsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating topic tables: %w", err)
+	}
+
+	_, err = db.Exec(alterTableSubscribersUserSQL)
+	if err != nil {
+		return fmt.Errorf("Error adding subscriber user reference: %w", err)
+	}
+
+	_, err = db.Exec(initTableRefreshTokensSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating refresh token table: %w", err)
+	}
+
+	_, err = db.Exec(alterTableUsersRoleSQL)
+	if err != nil {
+		return fmt.Errorf("Error adding user roles: %w", err)
+	}
+
+	_, err = db.Exec(initTableAPIKeysSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating API key table: %w", err)
+	}
+
+	_, err = db.Exec(initTablePasswordResetsSQL)
+	if err != nil {
+		return fmt.Errorf("Error creating password reset table: %w", err)
+	}
+
+	_, err = db.Exec(alterTableMailsTransactionalSQL)
+	if err != nil {
+		return fmt.Errorf("Error adding transactional flag to mail table: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"subscription-mailing-service/storage/subscriber"
+)
+
+type AdminHandler interface {
+	GetOrphans() gin.HandlerFunc
+	RepairOrphans() gin.HandlerFunc
+}
+
+type Handler struct {
+	subscribers *subscriber.SubscriberStorage
+	logger      *slog.Logger
+}
+
+func NewHandler(subscribers *subscriber.SubscriberStorage, logger *slog.Logger) *Handler {
+	return &Handler{subscribers: subscribers, logger: logger}
+}
+
+// GetOrphans reports subscriptions whose user no longer exists and whether
+// the user reference is enforced for every subscription yet.
+func (h *Handler) GetOrphans() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report, err := h.subscribers.GetOrphans(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error getting orphaned subscriptions", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting orphaned subscriptions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// RepairOrphans deletes the orphaned subscriptions and enforces the user
+// reference for every subscription from then on.
+func (h *Handler) RepairOrphans() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report, err := h.subscribers.RepairOrphans(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error repairing orphaned subscriptions", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error repairing orphaned subscriptions"})
+			return
+		}
+
+		h.logger.Info("Repaired orphaned subscriptions", slog.Int64("deleted", report.Repaired))
+		c.JSON(http.StatusOK, report)
+	}
+}
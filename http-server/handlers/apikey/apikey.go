@@ -0,0 +1,159 @@
+package apikey
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"subscription-mailing-service/http-server/middleware"
+	"subscription-mailing-service/internal/auth"
+	"subscription-mailing-service/internal/model"
+	apikey2 "subscription-mailing-service/storage/apikey"
+	"time"
+)
+
+type APIKeyHandler interface {
+	GetAllAPIKeys() gin.HandlerFunc
+	CreateAPIKey() gin.HandlerFunc
+	RevokeAPIKey() gin.HandlerFunc
+}
+
+type Handler struct {
+	store  *apikey2.APIKeyStorage
+	auth   *auth.Service
+	logger *slog.Logger
+}
+
+func NewHandler(store *apikey2.APIKeyStorage, authService *auth.Service, logger *slog.Logger) *Handler {
+	return &Handler{store: store, auth: authService, logger: logger}
+}
+
+type createRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// GetAllAPIKeys lists the keys, revoked ones included. Keys themselves are
+// never shown again after creation.
+func (h *Handler) GetAllAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys, err := h.store.GetAll(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error getting API keys", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting API keys"})
+			return
+		}
+
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+// CreateAPIKey generates a key. The response is the only place the key is
+// shown.
+func (h *Handler) CreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req createRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		key, msg := newAPIKey(&req)
+		if msg != "" {
+			h.logger.Error("Invalid API key", slog.String("reason", msg))
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if identity, ok := middleware.CurrentIdentity(c); ok && identity.UserID != 0 {
+			key.CreatedBy = &identity.UserID
+		}
+
+		created, err := h.auth.CreateKey(c.Request.Context(), key)
+		if err != nil {
+			h.logger.Error("Error creating API key", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating API key"})
+			return
+		}
+
+		h.logger.Info("Created API key", slog.Int("api_key_id", key.ID), slog.String("prefix", key.Prefix))
+		c.JSON(http.StatusCreated, created)
+	}
+}
+
+// RevokeAPIKey ends a key for good; requests with it are rejected right away.
+func (h *Handler) RevokeAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			h.logger.Error("Invalid API key ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+			return
+		}
+
+		key, err := h.store.Revoke(c.Request.Context(), keyID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("API key not found", slog.Int("api_key_id", keyID))
+				c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+				return
+			}
+			h.logger.Error("Error revoking API key", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking API key"})
+			return
+		}
+
+		c.JSON(http.StatusOK, key)
+	}
+}
+
+// newAPIKey checks the request and turns it into a key with normalized scopes
+// and address ranges. It returns a message describing the first problem
+// found.
+func newAPIKey(req *createRequest) (*model.APIKey, string) {
+	key := &model.APIKey{
+		Name:       strings.TrimSpace(req.Name),
+		Scopes:     []string{},
+		AllowedIPs: []string{},
+		ExpiresAt:  req.ExpiresAt,
+	}
+
+	if key.Name == "" {
+		return nil, "Missing required field: 'name'"
+	}
+
+	seen := map[string]bool{}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			return nil, "Unknown scope: '" + scope + "'"
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			key.Scopes = append(key.Scopes, scope)
+		}
+	}
+
+	if len(key.Scopes) == 0 {
+		return nil, "Missing required field: 'scopes'"
+	}
+
+	for _, entry := range req.AllowedIPs {
+		normalized, err := auth.NormalizeAllowedIP(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, "Invalid address or CIDR range in 'allowed_ips': '" + entry + "'"
+		}
+		key.AllowedIPs = append(key.AllowedIPs, normalized)
+	}
+
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return nil, "Invalid 'expires_at': must be in the future"
+	}
+
+	return key, ""
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"subscription-mailing-service/internal/auth"
+	storageErrors "subscription-mailing-service/storage/errors"
+)
+
+type AuthHandler interface {
+	Login() gin.HandlerFunc
+	Refresh() gin.HandlerFunc
+	Logout() gin.HandlerFunc
+	ForgotPassword() gin.HandlerFunc
+	ResetPassword() gin.HandlerFunc
+}
+
+// forgotPasswordAccepted answers every well-formed forgot-password request,
+// whether or not the address belongs to anyone.
+const forgotPasswordAccepted = "If the address belongs to an account, a password reset link has been sent to it"
+
+type Handler struct {
+	service  *auth.Service
+	resetter *auth.Resetter
+	logger   *slog.Logger
+}
+
+func NewHandler(service *auth.Service, resetter *auth.Resetter, logger *slog.Logger) *Handler {
+	return &Handler{service: service, resetter: resetter, logger: logger}
+}
+
+type loginRequest struct {
+	Login    string `json:"login" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type resetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+func (h *Handler) Login() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req loginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		tokens, err := h.service.Login(c.Request.Context(), req.Login, req.Password)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				h.logger.Error("Failed login", slog.String("login", req.Login))
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid login or password"})
+				return
+			}
+			h.logger.Error("Error logging in", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error logging in"})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens.
+func (h *Handler) Refresh() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req refreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		tokens, err := h.service.Refresh(c.Request.Context(), req.RefreshToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrInvalidToken):
+				h.logger.Error("Invalid refresh token", slog.Any("error", err))
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			case errors.Is(err, storageErrors.ErrTokenReused):
+				h.logger.Warn("Refresh token reused, sessions of the user revoked", slog.Any("error", err))
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			default:
+				h.logger.Error("Error refreshing tokens", slog.Any("error", err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error refreshing tokens"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// Logout revokes the refresh token.
+func (h *Handler) Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req refreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if err := h.service.Logout(c.Request.Context(), req.RefreshToken); err != nil {
+			if errors.Is(err, auth.ErrInvalidToken) {
+				h.logger.Error("Invalid refresh token", slog.Any("error", err))
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+				return
+			}
+			h.logger.Error("Error logging out", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error logging out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+	}
+}
+
+// ForgotPassword mails a reset link. The answer is the same for known and
+// unknown addresses, rate limited ones and failures alike, so it reveals
+// nothing about which addresses have accounts.
+func (h *Handler) ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req forgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if err := h.resetter.Request(req.Email); err != nil {
+			h.logger.Warn("Password reset not queued", slog.Any("error", err), slog.String("ip", c.ClientIP()))
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": forgotPasswordAccepted})
+	}
+}
+
+// ResetPassword sets a new password with the token from a reset link.
+func (h *Handler) ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req resetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if len(req.NewPassword) < auth.MinPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters"})
+			return
+		}
+
+		if err := h.resetter.Reset(c.Request.Context(), req.Token, req.NewPassword); err != nil {
+			if errors.Is(err, auth.ErrInvalidToken) {
+				h.logger.Error("Invalid password reset token", slog.Any("error", err))
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+				return
+			}
+			h.logger.Error("Error resetting password", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+	}
+}
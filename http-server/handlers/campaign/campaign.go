@@ -0,0 +1,433 @@
+package campaign
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"subscription-mailing-service/internal/composer"
+	"subscription-mailing-service/internal/dispatcher"
+	"subscription-mailing-service/internal/lifecycle"
+	"subscription-mailing-service/internal/mail"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/subscriberlevel"
+	campaign2 "subscription-mailing-service/storage/campaign"
+	"subscription-mailing-service/storage/message"
+	"subscription-mailing-service/storage/topic"
+)
+
+const (
+	defaultSampleSize = 10
+	maxSampleSize     = 100
+)
+
+type CampaignHandler interface {
+	GetCampaignID() gin.HandlerFunc
+	GetAllCampaigns() gin.HandlerFunc
+	CreateCampaign() gin.HandlerFunc
+	UpdateCampaign() gin.HandlerFunc
+	DeleteCampaign() gin.HandlerFunc
+	SendCampaign() gin.HandlerFunc
+	DryRunCampaign() gin.HandlerFunc
+	DryRunSegment() gin.HandlerFunc
+}
+
+type Handler struct {
+	store      *campaign2.CampaignStorage
+	messages   *message.MessageStorage
+	topics     *topic.TopicStorage
+	dispatcher *dispatcher.Dispatcher
+	logger     *slog.Logger
+}
+
+func NewHandler(
+	store *campaign2.CampaignStorage,
+	messages *message.MessageStorage,
+	topics *topic.TopicStorage,
+	dispatcher *dispatcher.Dispatcher,
+	logger *slog.Logger,
+) *Handler {
+	return &Handler{store: store, messages: messages, topics: topics, dispatcher: dispatcher, logger: logger}
+}
+
+func (h *Handler) GetCampaignID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		campaignID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid campaign ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid campaign ID"})
+			return
+		}
+
+		campaign, err := h.store.Get(c.Request.Context(), campaignID)
+		if err != nil {
+			h.logger.Error("Error getting campaign", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting campaign"})
+			return
+		}
+
+		if campaign == nil {
+			h.logger.Error("Campaign not found", slog.Int("campaign_id", campaignID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, campaign)
+	}
+}
+
+func (h *Handler) GetAllCampaigns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		campaigns, err := h.store.GetAll(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error getting campaigns", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting campaigns"})
+			return
+		}
+
+		c.JSON(http.StatusOK, campaigns)
+	}
+}
+
+func (h *Handler) CreateCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var campaign *model.Campaign
+
+		if err := c.ShouldBindJSON(&campaign); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if msg := validateCampaign(campaign); msg != "" {
+			h.logger.Error(msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if !h.templateExists(c, campaign) || !h.topicExists(c, campaign.Topic) {
+			return
+		}
+
+		createdCampaign, err := h.store.Create(c.Request.Context(), campaign)
+		if err != nil {
+			h.logger.Error("Error creating campaign", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating campaign"})
+			return
+		}
+
+		c.JSON(http.StatusOK, createdCampaign)
+	}
+}
+
+func (h *Handler) UpdateCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		campaignID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid campaign ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid campaign ID"})
+			return
+		}
+
+		var campaign *model.Campaign
+		if err := c.ShouldBindJSON(&campaign); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if msg := validateCampaign(campaign); msg != "" {
+			h.logger.Error(msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if !h.templateExists(c, campaign) || !h.topicExists(c, campaign.Topic) {
+			return
+		}
+
+		err = h.store.Update(c.Request.Context(), campaign, campaignID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Campaign not found", slog.Any("error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+				return
+			}
+			h.logger.Error("Error updating campaign", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating campaign"})
+			return
+		}
+
+		c.JSON(http.StatusOK, campaign)
+	}
+}
+
+func (h *Handler) DeleteCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		campaignID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid campaign ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid campaign ID"})
+			return
+		}
+
+		err = h.store.Delete(c.Request.Context(), campaignID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Campaign not found", slog.Any("error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+				return
+			}
+			h.logger.Error("Error deleting campaign", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting campaign"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Campaign deleted successfully"})
+	}
+}
+
+func (h *Handler) SendCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		campaignID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid campaign ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid campaign ID"})
+			return
+		}
+
+		campaign, err := h.store.Get(c.Request.Context(), campaignID)
+		if err != nil {
+			h.logger.Error("Error getting campaign", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting campaign"})
+			return
+		}
+
+		if campaign == nil {
+			h.logger.Error("Campaign not found", slog.Int("campaign_id", campaignID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+			return
+		}
+
+		job, err := h.dispatcher.Dispatch(c.Request.Context(), campaign)
+		if err != nil {
+			if errors.Is(err, dispatcher.ErrEmptySegment) {
+				h.logger.Error("Campaign segment has no recipients", slog.Int("campaign_id", campaignID))
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Campaign segment has no recipients"})
+				return
+			}
+			h.logger.Error("Error sending campaign", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending campaign"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":  "Campaign queued for delivery",
+			"campaign": campaign,
+			"job":      job,
+		})
+	}
+}
+
+func (h *Handler) DryRunCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		campaignID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid campaign ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid campaign ID"})
+			return
+		}
+
+		sampleSize, ok := h.sampleSize(c)
+		if !ok {
+			return
+		}
+
+		campaign, err := h.store.Get(c.Request.Context(), campaignID)
+		if err != nil {
+			h.logger.Error("Error getting campaign", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting campaign"})
+			return
+		}
+
+		if campaign == nil {
+			h.logger.Error("Campaign not found", slog.Int("campaign_id", campaignID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+			return
+		}
+
+		preview, err := h.store.PreviewSegment(c.Request.Context(), campaign.Segment, campaign.Topic, campaign.Category, sampleSize)
+		if err != nil {
+			h.logger.Error("Error resolving campaign segment", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving campaign segment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, preview)
+	}
+}
+
+// DryRunSegment previews a segment. The optional "topic" and "category" query
+// parameters apply plan limits and preferences as for a campaign with them.
+func (h *Handler) DryRunSegment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var segment model.Segment
+
+		if err := c.ShouldBindJSON(&segment); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if msg := validateSegment(segment); msg != "" {
+			h.logger.Error(msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		sampleSize, ok := h.sampleSize(c)
+		if !ok {
+			return
+		}
+
+		topic := strings.ToLower(strings.TrimSpace(c.Query("topic")))
+		category := c.Query("category")
+		preview, err := h.store.PreviewSegment(c.Request.Context(), segment, topic, category, sampleSize)
+		if err != nil {
+			h.logger.Error("Error resolving segment", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving segment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, preview)
+	}
+}
+
+func (h *Handler) sampleSize(c *gin.Context) (int, bool) {
+	sampleStr := c.Query("sample")
+	if sampleStr == "" {
+		return defaultSampleSize, true
+	}
+
+	sampleSize, err := strconv.Atoi(sampleStr)
+	if err != nil || sampleSize < 0 || sampleSize > maxSampleSize {
+		h.logger.Error("Invalid sample size", slog.String("sample", sampleStr))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sample size"})
+		return 0, false
+	}
+
+	return sampleSize, true
+}
+
+// topicExists checks that the campaign topic, if any, is a known topic.
+func (h *Handler) topicExists(c *gin.Context, key string) bool {
+	if key == "" {
+		return true
+	}
+
+	t, err := h.topics.Get(c.Request.Context(), key)
+	if err != nil {
+		h.logger.Error("Error getting topic", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting topic"})
+		return false
+	}
+
+	if t == nil {
+		h.logger.Error("Topic not found", slog.String("topic", key))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Topic not found: " + key})
+		return false
+	}
+
+	return true
+}
+
+// templateExists checks that the template, and the version the campaign is
+// pinned to, exist.
+func (h *Handler) templateExists(c *gin.Context, campaign *model.Campaign) bool {
+	if campaign.TemplateID == nil {
+		campaign.TemplateVersion = nil
+		return true
+	}
+
+	template, err := h.messages.Get(c.Request.Context(), *campaign.TemplateID)
+	if err != nil {
+		h.logger.Error("Error getting template", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting template"})
+		return false
+	}
+
+	if template == nil {
+		h.logger.Error("Template not found", slog.Int("template_id", *campaign.TemplateID))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Template not found"})
+		return false
+	}
+
+	if campaign.TemplateVersion == nil {
+		return true
+	}
+
+	version, err := h.messages.GetVersion(c.Request.Context(), *campaign.TemplateID, *campaign.TemplateVersion)
+	if err != nil {
+		h.logger.Error("Error getting template version", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting template version"})
+		return false
+	}
+
+	if version == nil {
+		h.logger.Error("Template version not found", slog.Int("template_id", *campaign.TemplateID))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Template version not found"})
+		return false
+	}
+
+	return true
+}
+
+func validateCampaign(campaign *model.Campaign) string {
+	if campaign.Name == "" {
+		return "Missing required field: 'name'"
+	}
+
+	if campaign.TemplateID == nil && (campaign.Subject == "" || campaign.Body == "") {
+		return "Missing required fields: 'subject', 'body' or 'template_id'"
+	}
+
+	if !composer.ValidContentType(campaign.ContentType) {
+		return "Unsupported content type: use 'text/plain' or 'text/html'"
+	}
+
+	if campaign.Category == "" {
+		campaign.Category = mail.Subject2
+	}
+
+	if !mail.IsValid(campaign.Category) {
+		return "Invalid campaign category: " + campaign.Category
+	}
+
+	campaign.Topic = strings.ToLower(strings.TrimSpace(campaign.Topic))
+
+	return validateSegment(campaign.Segment)
+}
+
+func validateSegment(segment model.Segment) string {
+	for _, level := range segment.Levels {
+		if !subscriberlevel.IsValid(level) {
+			return "Invalid subscriber level: " + level
+		}
+	}
+
+	for _, status := range segment.Statuses {
+		if !lifecycle.IsValid(status) {
+			return "Invalid subscription status: " + status
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,148 @@
+package levelnotification
+
+import (
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/subscriberlevel"
+	levelnotification2 "subscription-mailing-service/storage/levelnotification"
+	message2 "subscription-mailing-service/storage/message"
+)
+
+type LevelNotificationHandler interface {
+	GetLevelNotification() gin.HandlerFunc
+	GetAllLevelNotifications() gin.HandlerFunc
+	UpdateLevelNotification() gin.HandlerFunc
+}
+
+type Handler struct {
+	store    *levelnotification2.LevelNotificationStorage
+	messages *message2.MessageStorage
+	logger   *slog.Logger
+}
+
+func NewHandler(
+	store *levelnotification2.LevelNotificationStorage,
+	messages *message2.MessageStorage,
+	logger *slog.Logger,
+) *Handler {
+	return &Handler{store: store, messages: messages, logger: logger}
+}
+
+// updateRequest configures the notification of a level. Enabled defaults to
+// true; without a template the built-in notification is sent.
+type updateRequest struct {
+	TemplateID *int  `json:"template_id"`
+	Enabled    *bool `json:"enabled"`
+}
+
+func (h *Handler) GetLevelNotification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		level, ok := h.level(c)
+		if !ok {
+			return
+		}
+
+		notification, err := h.store.Get(c.Request.Context(), level)
+		if err != nil {
+			h.logger.Error("Error getting level notification", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting level notification"})
+			return
+		}
+
+		if notification == nil {
+			notification = defaultNotification(level)
+		}
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+// GetAllLevelNotifications lists the settings of every level, including the
+// defaults of levels that were never configured.
+func (h *Handler) GetAllLevelNotifications() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stored, err := h.store.GetAll(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error getting level notifications", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting level notifications"})
+			return
+		}
+
+		byLevel := make(map[string]*model.LevelNotification, len(stored))
+		for _, n := range stored {
+			byLevel[n.Level] = n
+		}
+
+		notifications := []*model.LevelNotification{}
+		for _, level := range subscriberlevel.Levels() {
+			n, ok := byLevel[level]
+			if !ok {
+				n = defaultNotification(level)
+			}
+			notifications = append(notifications, n)
+		}
+
+		c.JSON(http.StatusOK, notifications)
+	}
+}
+
+func (h *Handler) UpdateLevelNotification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		level, ok := h.level(c)
+		if !ok {
+			return
+		}
+
+		var req updateRequest
+		if err := c.BindJSON(&req); err != nil {
+			h.logger.Error("Error binding JSON", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+			return
+		}
+
+		if req.TemplateID != nil {
+			message, err := h.messages.Get(c.Request.Context(), *req.TemplateID)
+			if err != nil {
+				h.logger.Error("Error getting message", slog.Any("error", err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting template"})
+				return
+			}
+
+			if message == nil {
+				h.logger.Error("Template not found", slog.Int("template_id", *req.TemplateID))
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Template not found"})
+				return
+			}
+		}
+
+		notification := &model.LevelNotification{Level: level, TemplateID: req.TemplateID, Enabled: true}
+		if req.Enabled != nil {
+			notification.Enabled = *req.Enabled
+		}
+
+		if err := h.store.Save(c.Request.Context(), notification); err != nil {
+			h.logger.Error("Error saving level notification", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving level notification"})
+			return
+		}
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+func (h *Handler) level(c *gin.Context) (string, bool) {
+	level := c.Param("level")
+	if !subscriberlevel.IsValid(level) {
+		h.logger.Error("Invalid level", slog.String("level", level))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid level"})
+		return "", false
+	}
+
+	return level, true
+}
+
+func defaultNotification(level string) *model.LevelNotification {
+	return &model.LevelNotification{Level: level, Enabled: true}
+}
@@ -4,12 +4,19 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/gin-gonic/gin"
+	"io"
 	"log/slog"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"subscription-mailing-service/internal/composer"
+	mailInfo "subscription-mailing-service/internal/mail"
 	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
 	mail2 "subscription-mailing-service/storage/mail"
-	"time"
+	"subscription-mailing-service/storage/message"
+	"subscription-mailing-service/storage/outbox"
 )
 
 type MailHandler interface {
@@ -17,18 +24,39 @@ type MailHandler interface {
 	GetAllMails() gin.HandlerFunc
 	CreateMail() gin.HandlerFunc
 	SendMail() gin.HandlerFunc
+	GetMailStatus() gin.HandlerFunc
+	GetMailRecipients() gin.HandlerFunc
+	GetDeadMails() gin.HandlerFunc
+	RequeueMail() gin.HandlerFunc
 	UpdateMail() gin.HandlerFunc
 	DeleteMail() gin.HandlerFunc
+	QueueMail() gin.HandlerFunc
+	AttachFile() gin.HandlerFunc
+	GetMailAttachments() gin.HandlerFunc
+	DetachFile() gin.HandlerFunc
 	SearchMails() gin.HandlerFunc
 }
 
+// multipartOverhead is the allowance for form boundaries and fields on top of
+// the attached file itself.
+const multipartOverhead = 64 << 10
+
 type Handler struct {
-	store  *mail2.MailStorage
-	logger *slog.Logger
+	store    *mail2.MailStorage
+	outbox   *outbox.OutboxStorage
+	messages *message.MessageStorage
+	composer *composer.Composer
+	logger   *slog.Logger
 }
 
-func NewHandler(store *mail2.MailStorage, logger *slog.Logger) *Handler {
-	return &Handler{store: store, logger: logger}
+func NewHandler(
+	store *mail2.MailStorage,
+	outbox *outbox.OutboxStorage,
+	messages *message.MessageStorage,
+	composer *composer.Composer,
+	logger *slog.Logger,
+) *Handler {
+	return &Handler{store: store, outbox: outbox, messages: messages, composer: composer, logger: logger}
 }
 
 func (h *Handler) GetMailInfo() gin.HandlerFunc {
@@ -54,6 +82,7 @@ func (h *Handler) GetMailInfo() gin.HandlerFunc {
 			return
 		}
 
+		redact(mail)
 		c.JSON(http.StatusOK, mail)
 	}
 }
@@ -67,10 +96,21 @@ func (h *Handler) GetAllMails() gin.HandlerFunc {
 			return
 		}
 
+		redact(mails...)
 		c.JSON(http.StatusOK, mails)
 	}
 }
 
+// redact blanks the bodies of transactional mails. They carry password reset
+// and confirmation links that must only ever reach their recipient.
+func redact(mails ...*model.Mail) {
+	for _, mail := range mails {
+		if mail.Transactional {
+			mail.Body = ""
+		}
+	}
+}
+
 func (h *Handler) CreateMail() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var mail *model.Mail
@@ -81,6 +121,10 @@ func (h *Handler) CreateMail() gin.HandlerFunc {
 			return
 		}
 
+		if !h.validateMail(c, mail) {
+			return
+		}
+
 		createdMail, err := h.store.Create(c.Request.Context(), mail)
 		if err != nil {
 			h.logger.Error("Error create mail", slog.Any("Error", err))
@@ -102,18 +146,233 @@ func (h *Handler) SendMail() gin.HandlerFunc {
 			return
 		}
 
+		if !h.validateMail(c, mail) {
+			return
+		}
+
+		// Campaign membership, delivery state and the transactional flag are
+		// the service's own; callers only choose the content.
+		mail = &model.Mail{
+			To:              mail.To,
+			Subject:         mail.Subject,
+			Body:            mail.Body,
+			ContentType:     mail.ContentType,
+			TemplateID:      mail.TemplateID,
+			TemplateVersion: mail.TemplateVersion,
+			Category:        mail.Category,
+		}
+
+		job, err := h.outbox.Enqueue(c.Request.Context(), mail)
+		if err != nil {
+			h.logger.Error("Error queueing mail", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error queueing mail"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "Mail queued for delivery",
+			"job":     job,
+		})
+	}
+}
+
+// validateMail checks the required fields. A mail built from a template takes
+// its subject and body from the template, which must exist. Without an explicit
+// version the mail is pinned to the current one when it is stored.
+func (h *Handler) validateMail(c *gin.Context, mail *model.Mail) bool {
+	if !composer.ValidContentType(mail.ContentType) {
+		h.logger.Error("Unsupported content type", slog.String("content_type", mail.ContentType))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported content type: use 'text/plain' or 'text/html'"})
+		return false
+	}
+
+	if mail.Category != "" && !mailInfo.IsValid(mail.Category) {
+		h.logger.Error("Invalid category", slog.String("category", mail.Category))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category: use 'advertisement' or 'notification'"})
+		return false
+	}
+
+	if mail.TemplateID == nil {
+		mail.TemplateVersion = nil
 		if len(mail.To) == 0 || mail.Subject == "" || mail.Body == "" {
 			h.logger.Error("Missing required fields")
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields: 'to', 'subject', 'body'"})
+			return false
+		}
+		return true
+	}
+
+	if len(mail.To) == 0 {
+		h.logger.Error("Missing required fields")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields: 'to'"})
+		return false
+	}
+
+	template, err := h.messages.Get(c.Request.Context(), *mail.TemplateID)
+	if err != nil {
+		h.logger.Error("Error getting template", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting template"})
+		return false
+	}
+
+	if template == nil {
+		h.logger.Error("Template not found", slog.Int("template_id", *mail.TemplateID))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Template not found"})
+		return false
+	}
+
+	if mail.TemplateVersion == nil {
+		return true
+	}
+
+	version, err := h.messages.GetVersion(c.Request.Context(), *mail.TemplateID, *mail.TemplateVersion)
+	if err != nil {
+		h.logger.Error("Error getting template version", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting template version"})
+		return false
+	}
+
+	if version == nil {
+		h.logger.Error("Template version not found", slog.Int("template_id", *mail.TemplateID))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Template version not found"})
+		return false
+	}
+
+	return true
+}
+
+func (h *Handler) GetMailStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		mailID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid mail ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mail ID"})
 			return
 		}
 
-		// TODO отпрака майла
-		mail.SentAt = time.Now()
+		job, err := h.outbox.GetByMail(c.Request.Context(), mailID)
+		if err != nil {
+			h.logger.Error("Error fetching mail status", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching mail status"})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Mail sent successfully",
-			"mail":    mail,
+		if job == nil {
+			h.logger.Error("Mail delivery not found", slog.Int("mail_id", mailID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Mail delivery not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+func (h *Handler) GetMailRecipients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		mailID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid mail ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mail ID"})
+			return
+		}
+
+		var statuses []string
+		for _, value := range c.QueryArray("status") {
+			for _, status := range strings.Split(value, ",") {
+				status = strings.TrimSpace(status)
+				if !validRecipientStatus(status) {
+					h.logger.Error("Invalid recipient status", slog.String("status", status))
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipient status"})
+					return
+				}
+				statuses = append(statuses, status)
+			}
+		}
+
+		mail, err := h.store.Get(c.Request.Context(), mailID)
+		if err != nil {
+			h.logger.Error("Error fetching mail info", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching mail info"})
+			return
+		}
+
+		if mail == nil {
+			h.logger.Error("Mail not found", slog.Int("mail_id", mailID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Mail not found"})
+			return
+		}
+
+		recipients, err := h.outbox.GetRecipients(c.Request.Context(), mailID, statuses...)
+		if err != nil {
+			h.logger.Error("Error getting mail recipients", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting mail recipients"})
+			return
+		}
+
+		c.JSON(http.StatusOK, recipients)
+	}
+}
+
+func validRecipientStatus(status string) bool {
+	switch status {
+	case model.RecipientStatusPending,
+		model.RecipientStatusSending,
+		model.RecipientStatusSent,
+		model.RecipientStatusDeferred,
+		model.RecipientStatusFailed,
+		model.RecipientStatusSuppressed:
+		return true
+	}
+
+	return false
+}
+
+func (h *Handler) GetDeadMails() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mails, err := h.store.GetByStatus(c.Request.Context(), model.MailStatusDead)
+		if err != nil {
+			h.logger.Error("Error getting dead mails", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting dead mails"})
+			return
+		}
+
+		redact(mails...)
+		c.JSON(http.StatusOK, mails)
+	}
+}
+
+func (h *Handler) RequeueMail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		mailID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid mail ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mail ID"})
+			return
+		}
+
+		job, err := h.outbox.Requeue(c.Request.Context(), mailID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Mail not found", slog.Any("error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Mail not found"})
+				return
+			}
+			if errors.Is(err, storageErrors.ErrMailNotDead) {
+				h.logger.Error("Mail is not dead-lettered", slog.Int("mail_id", mailID))
+				c.JSON(http.StatusConflict, gin.H{"error": "Mail is not dead-lettered"})
+				return
+			}
+			h.logger.Error("Error requeueing mail", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error requeueing mail"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "Mail requeued for delivery",
+			"job":     job,
 		})
 	}
 }
@@ -135,14 +394,22 @@ func (h *Handler) UpdateMail() gin.HandlerFunc {
 			return
 		}
 
-		if len(mail.To) == 0 || mail.Subject == "" || mail.Body == "" {
-			h.logger.Error("Missing required fields")
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields: 'to', 'subject', 'body'"})
+		if !h.validateMail(c, mail) {
 			return
 		}
 
 		err = h.store.Update(c.Request.Context(), mail, mailID)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Mail not found", slog.Any("error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Mail not found"})
+				return
+			}
+			if errors.Is(err, storageErrors.ErrMailNotDraft) {
+				h.logger.Error("Mail is not a draft", slog.Int("mail_id", mailID))
+				c.JSON(http.StatusConflict, gin.H{"error": "Mail is not a draft"})
+				return
+			}
 			h.logger.Error("Error updating mail", slog.Any("error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating mail"})
 			return
@@ -181,6 +448,251 @@ func (h *Handler) DeleteMail() gin.HandlerFunc {
 	}
 }
 
+// QueueMail queues a draft created earlier, e.g. after files were attached to
+// it.
+func (h *Handler) QueueMail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		mailID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid mail ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mail ID"})
+			return
+		}
+
+		job, err := h.outbox.EnqueueDraft(c.Request.Context(), mailID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Mail not found", slog.Any("error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Mail not found"})
+				return
+			}
+			if errors.Is(err, storageErrors.ErrMailNotDraft) {
+				h.logger.Error("Mail is not a draft", slog.Int("mail_id", mailID))
+				c.JSON(http.StatusConflict, gin.H{"error": "Mail is not a draft"})
+				return
+			}
+			if errors.Is(err, storageErrors.ErrMailNoRecipients) {
+				h.logger.Error("Mail has no recipients", slog.Int("mail_id", mailID))
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Mail has no recipients"})
+				return
+			}
+			h.logger.Error("Error queueing mail", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error queueing mail"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "Mail queued for delivery",
+			"job":     job,
+		})
+	}
+}
+
+// AttachFile stores the file uploaded in the "file" form field with a draft.
+// An optional "content_id" form field makes it an inline part the HTML body
+// can refer to as "cid:<content_id>".
+func (h *Handler) AttachFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		mailID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid mail ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mail ID"})
+			return
+		}
+
+		mail, ok := h.draft(c, mailID)
+		if !ok {
+			return
+		}
+
+		maxSize := h.composer.MaxAttachmentSize()
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				h.logger.Error("Attachment too large", slog.Int("mail_id", mailID))
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Attachment too large"})
+				return
+			}
+			h.logger.Error("Invalid upload", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required form field: 'file'"})
+			return
+		}
+
+		if fileHeader.Size > maxSize {
+			h.logger.Error("Attachment too large", slog.Int("mail_id", mailID), slog.Int64("size", fileHeader.Size))
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Attachment too large"})
+			return
+		}
+
+		contentID := c.PostForm("content_id")
+		if !validContentID(contentID) {
+			h.logger.Error("Invalid content ID", slog.String("content_id", contentID))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid content ID"})
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			h.logger.Error("Error reading upload", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error reading upload"})
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			h.logger.Error("Error reading upload", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error reading upload"})
+			return
+		}
+
+		total, err := h.store.AttachmentsSize(c.Request.Context(), mail.ID)
+		if err != nil {
+			h.logger.Error("Error getting mail attachments", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting mail attachments"})
+			return
+		}
+
+		if !h.composer.FitsAttachments(total + int64(len(data))) {
+			h.logger.Error("Attachments exceed message size limit", slog.Int("mail_id", mailID))
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Attachments exceed the message size limit"})
+			return
+		}
+
+		contentType := fileHeader.Header.Get("Content-Type")
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType = http.DetectContentType(data)
+		}
+
+		attachment, err := h.store.AddAttachment(c.Request.Context(), &model.Attachment{
+			MailID:      mail.ID,
+			Filename:    filepath.Base(fileHeader.Filename),
+			ContentType: contentType,
+			ContentID:   contentID,
+			Data:        data,
+		})
+		if err != nil {
+			if errors.Is(err, storageErrors.ErrDuplicateContentID) {
+				h.logger.Error("Duplicate content ID", slog.String("content_id", contentID))
+				c.JSON(http.StatusConflict, gin.H{"error": "Content ID is already used by another attachment"})
+				return
+			}
+			h.logger.Error("Error storing attachment", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error storing attachment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, attachment)
+	}
+}
+
+func (h *Handler) GetMailAttachments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		mailID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid mail ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mail ID"})
+			return
+		}
+
+		attachments, err := h.store.ListAttachments(c.Request.Context(), mailID)
+		if err != nil {
+			h.logger.Error("Error getting mail attachments", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting mail attachments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, attachments)
+	}
+}
+
+func (h *Handler) DetachFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		mailID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid mail ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mail ID"})
+			return
+		}
+
+		attachmentIDStr := c.Param("attachment_id")
+		attachmentID, err := strconv.Atoi(attachmentIDStr)
+		if err != nil {
+			h.logger.Error("Invalid attachment ID", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+			return
+		}
+
+		if _, ok := h.draft(c, mailID); !ok {
+			return
+		}
+
+		err = h.store.DeleteAttachment(c.Request.Context(), mailID, attachmentID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Attachment not found", slog.Any("error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+				return
+			}
+			h.logger.Error("Error deleting attachment", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting attachment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted successfully"})
+	}
+}
+
+// draft loads a mail whose attachments may still change.
+func (h *Handler) draft(c *gin.Context, mailID int) (*model.Mail, bool) {
+	mail, err := h.store.Get(c.Request.Context(), mailID)
+	if err != nil {
+		h.logger.Error("Error fetching mail info", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching mail info"})
+		return nil, false
+	}
+
+	if mail == nil {
+		h.logger.Error("Mail not found", slog.Int("mail_id", mailID))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mail not found"})
+		return nil, false
+	}
+
+	if mail.Status != model.MailStatusDraft {
+		h.logger.Error("Mail is not a draft", slog.Int("mail_id", mailID))
+		c.JSON(http.StatusConflict, gin.H{"error": "Attachments can only be changed on draft mails"})
+		return nil, false
+	}
+
+	return mail, true
+}
+
+// validContentID accepts an empty content ID or one made of characters that
+// need no quoting in a Content-ID header.
+func validContentID(contentID string) bool {
+	if len(contentID) > 255 {
+		return false
+	}
+
+	for _, r := range contentID {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(".-_@+", r):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
 //func (h *Handler) SearchMails() gin.HandlerFunc {
 //	return func(c *gin.Context) {
 //		query := c.DefaultQuery("query", "")
@@ -1,14 +1,23 @@
 package message
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"log/slog"
 	"net/http"
+	netmail "net/mail"
 	"strconv"
+	"subscription-mailing-service/internal/composer"
 	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/sender"
+	"subscription-mailing-service/internal/templating"
+	"subscription-mailing-service/internal/textdiff"
+	storageErrors "subscription-mailing-service/storage/errors"
 	message2 "subscription-mailing-service/storage/message"
+	"subscription-mailing-service/storage/suppression"
+	"time"
 )
 
 type MessageHandler interface {
@@ -17,15 +26,68 @@ type MessageHandler interface {
 	CreateMessage() gin.HandlerFunc
 	UpdateMessage() gin.HandlerFunc
 	DeleteMessage() gin.HandlerFunc
+	PreviewMessage() gin.HandlerFunc
+	TestSendMessage() gin.HandlerFunc
+	GetMessageVersions() gin.HandlerFunc
+	GetMessageVersion() gin.HandlerFunc
+	DiffMessageVersions() gin.HandlerFunc
+	RollbackMessage() gin.HandlerFunc
 }
 
+const testSendTimeout = 30 * time.Second
+
 type Handler struct {
-	store  *message2.MessageStorage
-	logger *slog.Logger
+	store        *message2.MessageStorage
+	renderer     *templating.Renderer
+	sender       sender.Sender
+	composer     *composer.Composer
+	suppressions *suppression.SuppressionStorage
+	logger       *slog.Logger
+}
+
+func NewHandler(
+	store *message2.MessageStorage,
+	renderer *templating.Renderer,
+	sender sender.Sender,
+	composer *composer.Composer,
+	suppressions *suppression.SuppressionStorage,
+	logger *slog.Logger,
+) *Handler {
+	return &Handler{
+		store:        store,
+		renderer:     renderer,
+		sender:       sender,
+		composer:     composer,
+		suppressions: suppressions,
+		logger:       logger,
+	}
+}
+
+// previewRequest selects what a template is rendered against: a user, a
+// subscription, or arbitrary JSON data. An empty request renders against empty
+// recipient data. Version selects an earlier version of the template.
+type previewRequest struct {
+	Version      *int           `json:"version,omitempty"`
+	UserID       *int           `json:"user_id,omitempty"`
+	SubscriberID *int           `json:"subscriber_id,omitempty"`
+	Data         map[string]any `json:"data,omitempty"`
+}
+
+type testSendRequest struct {
+	previewRequest
+	To string `json:"to"`
+}
+
+type fieldDiff struct {
+	Field string          `json:"field"`
+	Lines []textdiff.Line `json:"lines"`
 }
 
-func NewHandler(store *message2.MessageStorage, logger *slog.Logger) *Handler {
-	return &Handler{store: store, logger: logger}
+type versionDiff struct {
+	MessageID int         `json:"message_id"`
+	From      int         `json:"from"`
+	To        int         `json:"to"`
+	Changes   []fieldDiff `json:"changes"`
 }
 
 func (h *Handler) GetMessageID() gin.HandlerFunc {
@@ -46,6 +108,12 @@ func (h *Handler) GetMessageID() gin.HandlerFunc {
 			return
 		}
 
+		if message == nil {
+			h.logger.Error("Message not found", slog.Int("message_id", messageID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": message})
 	}
 }
@@ -73,6 +141,10 @@ func (h *Handler) CreateMessage() gin.HandlerFunc {
 			return
 		}
 
+		if !h.validate(c, message) {
+			return
+		}
+
 		createdMessage, err := h.store.Create(c.Request.Context(), message)
 		if err != nil {
 			h.logger.Error("Error creating message", slog.Any("Error", err))
@@ -101,8 +173,17 @@ func (h *Handler) UpdateMessage() gin.HandlerFunc {
 			return
 		}
 
+		if !h.validate(c, message) {
+			return
+		}
+
 		err = h.store.Update(c.Request.Context(), message, messageID)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Message not found", slog.Any("Error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+				return
+			}
 			h.logger.Error("Error updating message", slog.Any("Error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating message"})
 			return
@@ -129,6 +210,11 @@ func (h *Handler) DeleteMessage() gin.HandlerFunc {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
 				return
 			}
+			if errors.Is(err, storageErrors.ErrMessageInUse) {
+				h.logger.Error("Message is in use", slog.Int("message_id", messageID))
+				c.JSON(http.StatusConflict, gin.H{"error": "Message is referenced by mails or campaigns"})
+				return
+			}
 			h.logger.Error("Error deleting message", slog.Any("Error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting message"})
 			return
@@ -137,3 +223,386 @@ func (h *Handler) DeleteMessage() gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
 	}
 }
+
+func (h *Handler) PreviewMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		messageID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid message ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+
+		var req previewRequest
+		if err := bindOptionalJSON(c, &req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		content, ok := h.render(c, messageID, &req)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, content)
+	}
+}
+
+func (h *Handler) TestSendMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		messageID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid message ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+
+		var req testSendRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		address, err := netmail.ParseAddress(req.To)
+		if err != nil {
+			h.logger.Error("Invalid recipient address", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipient address: 'to'"})
+			return
+		}
+
+		suppressed, err := h.suppressions.GetByAddress(c.Request.Context(), address.Address)
+		if err != nil {
+			h.logger.Error("Error checking suppression list", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking suppression list"})
+			return
+		}
+
+		if suppressed != nil {
+			h.logger.Error("Test mail recipient is suppressed", slog.String("address", address.Address))
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":   "Recipient address is suppressed",
+				"skipped": []model.SkippedRecipient{{Address: suppressed.Address, Reason: suppressed.Reason}},
+			})
+			return
+		}
+
+		content, ok := h.render(c, messageID, &req.previewRequest)
+		if !ok {
+			return
+		}
+
+		msg, err := h.composer.Compose(content.Email([]string{address.Address}))
+		if err != nil {
+			if errors.Is(err, composer.ErrTooLarge) {
+				h.logger.Error("Test mail too large", slog.Any("Error", err))
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+				return
+			}
+			h.logger.Error("Error composing test mail", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error composing test mail"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), testSendTimeout)
+		defer cancel()
+
+		results, err := h.sender.Send(ctx, msg)
+		if err == nil && len(results) > 0 {
+			err = results[0].Err
+		}
+		if err != nil {
+			h.logger.Error("Error sending test mail", slog.Any("Error", err), slog.Int("message_id", messageID))
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Error sending test mail: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Test mail sent",
+			"to":         address.Address,
+			"message_id": msg.MessageID,
+			"rendered":   content,
+		})
+	}
+}
+
+func (h *Handler) GetMessageVersions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		messageID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid message ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+
+		versions, err := h.store.GetVersions(c.Request.Context(), messageID)
+		if err != nil {
+			h.logger.Error("Error getting message versions", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting message versions"})
+			return
+		}
+
+		if len(versions) == 0 {
+			h.logger.Error("Message not found", slog.Int("message_id", messageID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"versions": versions})
+	}
+}
+
+func (h *Handler) GetMessageVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		messageID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid message ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+
+		versionStr := c.Param("version")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			h.logger.Error("Invalid message version", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message version"})
+			return
+		}
+
+		v, ok := h.version(c, messageID, version)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"version": v})
+	}
+}
+
+// DiffMessageVersions compares two versions given by the from and to query
+// parameters, line by line for every field that changed.
+func (h *Handler) DiffMessageVersions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		messageID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid message ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+
+		from, errFrom := strconv.Atoi(c.Query("from"))
+		to, errTo := strconv.Atoi(c.Query("to"))
+		if errFrom != nil || errTo != nil {
+			h.logger.Error("Invalid diff versions", slog.String("from", c.Query("from")), slog.String("to", c.Query("to")))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameters 'from' and 'to' must be versions"})
+			return
+		}
+
+		fromVersion, ok := h.version(c, messageID, from)
+		if !ok {
+			return
+		}
+
+		toVersion, ok := h.version(c, messageID, to)
+		if !ok {
+			return
+		}
+
+		diff := versionDiff{MessageID: messageID, From: from, To: to, Changes: []fieldDiff{}}
+		for _, field := range []struct {
+			name     string
+			from, to string
+		}{
+			{"name", fromVersion.Name, toVersion.Name},
+			{templating.FieldSubject, fromVersion.Subject, toVersion.Subject},
+			{templating.FieldHTMLBody, fromVersion.HTMLBody, toVersion.HTMLBody},
+			{templating.FieldTextBody, fromVersion.TextBody, toVersion.TextBody},
+		} {
+			lines := textdiff.Lines(field.from, field.to)
+			if textdiff.Changed(lines) {
+				diff.Changes = append(diff.Changes, fieldDiff{Field: field.name, Lines: lines})
+			}
+		}
+
+		c.JSON(http.StatusOK, diff)
+	}
+}
+
+// RollbackMessage makes an earlier version current again. The rollback itself
+// is recorded as a new version.
+func (h *Handler) RollbackMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		messageID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid message ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+
+		versionStr := c.Param("version")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			h.logger.Error("Invalid message version", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message version"})
+			return
+		}
+
+		message, err := h.store.Rollback(c.Request.Context(), messageID, version)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Message version not found", slog.Int("message_id", messageID), slog.Int("version", version))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Message version not found"})
+				return
+			}
+			if errors.Is(err, storageErrors.ErrVersionCurrent) {
+				h.logger.Error("Message version is already current", slog.Int("message_id", messageID), slog.Int("version", version))
+				c.JSON(http.StatusConflict, gin.H{"error": "Message version is already current"})
+				return
+			}
+			h.logger.Error("Error rolling back message", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rolling back message"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": message})
+	}
+}
+
+func (h *Handler) version(c *gin.Context, messageID int, version int) (*model.MessageVersion, bool) {
+	v, err := h.store.GetVersion(c.Request.Context(), messageID, version)
+	if err != nil {
+		h.logger.Error("Error getting message version", slog.Any("Error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting message version"})
+		return nil, false
+	}
+
+	if v == nil {
+		h.logger.Error("Message version not found", slog.Int("message_id", messageID), slog.Int("version", version))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message version not found"})
+		return nil, false
+	}
+
+	return v, true
+}
+
+// render loads the message and renders it against the data selected by req.
+// Rendering errors are reported per field with 422.
+func (h *Handler) render(c *gin.Context, messageID int, req *previewRequest) (*templating.Content, bool) {
+	sources := 0
+	for _, set := range []bool{req.UserID != nil, req.SubscriberID != nil, req.Data != nil} {
+		if set {
+			sources++
+		}
+	}
+
+	if sources > 1 {
+		h.logger.Error("Ambiguous preview data")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only one of 'user_id', 'subscriber_id' or 'data' may be given"})
+		return nil, false
+	}
+
+	tmpl, err := h.renderer.Template(c.Request.Context(), messageID, req.Version)
+	if err != nil {
+		h.renderError(c, err)
+		return nil, false
+	}
+
+	if tmpl == nil {
+		h.logger.Error("Message version not found", slog.Int("message_id", messageID))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message version not found"})
+		return nil, false
+	}
+
+	var data any
+	switch {
+	case req.UserID != nil:
+		userData, err := h.renderer.UserData(c.Request.Context(), *req.UserID)
+		if err != nil {
+			h.logger.Error("Error getting user", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+			return nil, false
+		}
+		if userData == nil {
+			h.logger.Error("User not found", slog.Int("user_id", *req.UserID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return nil, false
+		}
+		data = userData
+	case req.SubscriberID != nil:
+		subscriberData, err := h.renderer.SubscriberData(c.Request.Context(), *req.SubscriberID)
+		if err != nil {
+			h.logger.Error("Error getting subscriber", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting subscriber"})
+			return nil, false
+		}
+		if subscriberData == nil {
+			h.logger.Error("Subscriber not found", slog.Int("subscriber_id", *req.SubscriberID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+			return nil, false
+		}
+		data = subscriberData
+	case req.Data != nil:
+		data = req.Data
+	default:
+		data = templating.NewData(nil, nil)
+	}
+
+	content, err := tmpl.Execute(data)
+	if err != nil {
+		h.renderError(c, err)
+		return nil, false
+	}
+
+	return content, true
+}
+
+func (h *Handler) renderError(c *gin.Context, err error) {
+	var templateErr *templating.Error
+	if errors.As(err, &templateErr) {
+		h.logger.Error("Error rendering template", slog.Any("Error", err))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Error rendering template", "fields": templateErr.Fields})
+		return
+	}
+
+	h.logger.Error("Error rendering template", slog.Any("Error", err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering template"})
+}
+
+// bindOptionalJSON binds the request body when there is one.
+func bindOptionalJSON(c *gin.Context, obj any) error {
+	if c.Request.ContentLength == 0 {
+		return nil
+	}
+
+	return c.ShouldBindJSON(obj)
+}
+
+// validate checks the required fields and that every part of the message is a
+// template that renders. Rendering errors are reported per field.
+func (h *Handler) validate(c *gin.Context, message *model.Message) bool {
+	if message.Name == "" || message.Subject == "" || (message.HTMLBody == "" && message.TextBody == "") {
+		h.logger.Error("Missing required fields")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields: 'name', 'subject' and 'html_body' or 'text_body'"})
+		return false
+	}
+
+	if err := templating.Validate(message); err != nil {
+		var templateErr *templating.Error
+		if errors.As(err, &templateErr) {
+			h.logger.Error("Invalid template", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template", "fields": templateErr.Fields})
+			return false
+		}
+		h.logger.Error("Error validating template", slog.Any("Error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error validating template"})
+		return false
+	}
+
+	return true
+}
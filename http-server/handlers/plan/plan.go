@@ -0,0 +1,239 @@
+package plan
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
+	plan2 "subscription-mailing-service/storage/plan"
+	"subscription-mailing-service/storage/topic"
+)
+
+type PlanHandler interface {
+	GetPlanID() gin.HandlerFunc
+	GetAllPlans() gin.HandlerFunc
+	CreatePlan() gin.HandlerFunc
+	UpdatePlan() gin.HandlerFunc
+	DeletePlan() gin.HandlerFunc
+}
+
+type Handler struct {
+	store  *plan2.PlanStorage
+	topics *topic.TopicStorage
+	logger *slog.Logger
+}
+
+func NewHandler(store *plan2.PlanStorage, topics *topic.TopicStorage, logger *slog.Logger) *Handler {
+	return &Handler{store: store, topics: topics, logger: logger}
+}
+
+func (h *Handler) GetPlanID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		planID, ok := h.planID(c)
+		if !ok {
+			return
+		}
+
+		plan, err := h.store.Get(c.Request.Context(), planID)
+		if err != nil {
+			h.logger.Error("Error getting plan", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting plan"})
+			return
+		}
+
+		if plan == nil {
+			h.logger.Error("Plan not found", slog.Int("plan_id", planID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, plan)
+	}
+}
+
+func (h *Handler) GetAllPlans() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plans, err := h.store.GetAll(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error getting plans", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting plans"})
+			return
+		}
+
+		c.JSON(http.StatusOK, plans)
+	}
+}
+
+func (h *Handler) CreatePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var plan model.Plan
+		if err := c.ShouldBindJSON(&plan); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if msg := validatePlan(&plan); msg != "" {
+			h.logger.Error("Invalid plan", slog.String("reason", msg))
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if !h.topicsExist(c, plan.Topics) {
+			return
+		}
+
+		if err := h.store.Create(c.Request.Context(), &plan); err != nil {
+			if errors.Is(err, storageErrors.ErrDuplicatePlan) {
+				h.logger.Error("Plan name already taken", slog.String("name", plan.Name))
+				c.JSON(http.StatusConflict, gin.H{"error": "A plan with this name already exists"})
+				return
+			}
+			h.logger.Error("Error creating plan", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating plan"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, plan)
+	}
+}
+
+func (h *Handler) UpdatePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		planID, ok := h.planID(c)
+		if !ok {
+			return
+		}
+
+		var plan model.Plan
+		if err := c.ShouldBindJSON(&plan); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if msg := validatePlan(&plan); msg != "" {
+			h.logger.Error("Invalid plan", slog.String("reason", msg))
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if !h.topicsExist(c, plan.Topics) {
+			return
+		}
+
+		if err := h.store.Update(c.Request.Context(), &plan, planID); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				h.logger.Error("Plan not found", slog.Int("plan_id", planID))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+			case errors.Is(err, storageErrors.ErrDuplicatePlan):
+				h.logger.Error("Plan name already taken", slog.String("name", plan.Name))
+				c.JSON(http.StatusConflict, gin.H{"error": "A plan with this name already exists"})
+			default:
+				h.logger.Error("Error updating plan", slog.Any("error", err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating plan"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, plan)
+	}
+}
+
+func (h *Handler) DeletePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		planID, ok := h.planID(c)
+		if !ok {
+			return
+		}
+
+		if err := h.store.Delete(c.Request.Context(), planID); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				h.logger.Error("Plan not found", slog.Int("plan_id", planID))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+			case errors.Is(err, storageErrors.ErrPlanInUse):
+				h.logger.Error("Plan is in use", slog.Int("plan_id", planID))
+				c.JSON(http.StatusConflict, gin.H{"error": "Plan is still used by subscriptions"})
+			default:
+				h.logger.Error("Error deleting plan", slog.Any("error", err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting plan"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Plan deleted successfully"})
+	}
+}
+
+func (h *Handler) planID(c *gin.Context) (int, bool) {
+	planID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid plan ID", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plan ID"})
+		return 0, false
+	}
+
+	return planID, true
+}
+
+// topicsExist checks that every topic of the plan is a known topic.
+func (h *Handler) topicsExist(c *gin.Context, topics []string) bool {
+	missing, err := h.topics.Missing(c.Request.Context(), topics)
+	if err != nil {
+		h.logger.Error("Error checking topics", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking topics"})
+		return false
+	}
+
+	if len(missing) > 0 {
+		h.logger.Error("Unknown plan topics", slog.Any("topics", missing))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown topics: " + strings.Join(missing, ", ")})
+		return false
+	}
+
+	return true
+}
+
+// validatePlan checks the plan and normalizes its name and topics. It returns
+// a message describing the first problem found.
+func validatePlan(plan *model.Plan) string {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return "Missing required field: 'name'"
+	}
+
+	if plan.PeriodDays <= 0 {
+		return "Invalid 'period_days': must be positive"
+	}
+
+	if plan.PriceCents < 0 {
+		return "Invalid 'price_cents': must not be negative"
+	}
+
+	if plan.MaxMailsPerWeek < 0 {
+		return "Invalid 'max_mails_per_week': must not be negative"
+	}
+
+	topics := make([]string, 0, len(plan.Topics))
+	seen := map[string]bool{}
+	for _, key := range plan.Topics {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			return "Invalid topic: topics must not be empty"
+		}
+		if !seen[key] {
+			seen[key] = true
+			topics = append(topics, key)
+		}
+	}
+	plan.Topics = topics
+
+	return ""
+}
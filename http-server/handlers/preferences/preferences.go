@@ -0,0 +1,146 @@
+package preferences
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/preferences"
+	"subscription-mailing-service/internal/token"
+	storageErrors "subscription-mailing-service/storage/errors"
+)
+
+var centerPage = template.Must(template.New("center").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Mail preferences</title></head>
+<body>
+{{with .Notice}}<p>{{.}}</p>{{end}}
+<form method="post">
+<table>
+<tr><th>Topic</th><th>Receive</th><th>How often</th></tr>
+{{range .Preferences}}
+<tr>
+<td>{{.Name}}</td>
+<td><input type="checkbox" name="optin_{{.Topic}}" value="on"{{if .OptedIn}} checked{{end}}></td>
+<td><select name="frequency_{{.Topic}}">
+{{$current := .Frequency}}{{range $.Frequencies}}<option value="{{.}}"{{if eq . $current}} selected{{end}}>{{.}}</option>
+{{end}}</select></td>
+</tr>
+{{end}}
+</table>
+<p><button type="submit">Save preferences</button></p>
+</form>
+</body>
+</html>
+`))
+
+var resultPage = template.Must(template.New("result").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Mail preferences</title></head>
+<body>
+<p>{{.}}</p>
+</body>
+</html>
+`))
+
+var frequencies = []string{model.FrequencyImmediate, model.FrequencyDaily, model.FrequencyWeekly}
+
+type PreferencesHandler interface {
+	GetPreferences() gin.HandlerFunc
+	UpdatePreferences() gin.HandlerFunc
+}
+
+type Handler struct {
+	center *preferences.Center
+	logger *slog.Logger
+}
+
+func NewHandler(center *preferences.Center, logger *slog.Logger) *Handler {
+	return &Handler{center: center, logger: logger}
+}
+
+// GetPreferences renders the preference center of the user the link was
+// issued for.
+func (h *Handler) GetPreferences() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		current, err := h.center.Get(c.Request.Context(), c.Query("token"))
+		if err != nil {
+			h.fail(c, err)
+			return
+		}
+
+		h.render(c, http.StatusOK, centerPage, gin.H{"Preferences": current, "Frequencies": frequencies})
+	}
+}
+
+// UpdatePreferences stores the submitted form. Every topic shown in the form
+// is saved: unchecked topics are opted out of.
+func (h *Handler) UpdatePreferences() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tok := c.Query("token")
+
+		current, err := h.center.Get(c.Request.Context(), tok)
+		if err != nil {
+			h.fail(c, err)
+			return
+		}
+
+		submitted := make([]*model.TopicPreference, 0, len(current))
+		for _, p := range current {
+			frequency := c.PostForm("frequency_" + p.Topic)
+			if frequency == "" {
+				frequency = p.Frequency
+			}
+
+			submitted = append(submitted, &model.TopicPreference{
+				Topic:     p.Topic,
+				OptedIn:   c.PostForm("optin_"+p.Topic) != "",
+				Frequency: frequency,
+			})
+		}
+
+		saved, err := h.center.Update(c.Request.Context(), tok, submitted)
+		if err != nil {
+			h.fail(c, err)
+			return
+		}
+
+		h.render(c, http.StatusOK, centerPage, gin.H{
+			"Preferences": saved,
+			"Frequencies": frequencies,
+			"Notice":      "Your preferences have been saved.",
+		})
+	}
+}
+
+func (h *Handler) fail(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, token.ErrExpired):
+		h.logger.Error("Preference center link expired", slog.Any("error", err))
+		h.render(c, http.StatusGone, resultPage, "This link has expired.")
+	case errors.Is(err, token.ErrInvalid), errors.Is(err, sql.ErrNoRows):
+		h.logger.Error("Invalid preference center token", slog.Any("error", err))
+		h.render(c, http.StatusBadRequest, resultPage, "This link is not valid.")
+	case errors.Is(err, preferences.ErrInvalidFrequency), errors.Is(err, storageErrors.ErrTopicNotFound):
+		h.logger.Error("Invalid preferences", slog.Any("error", err))
+		h.render(c, http.StatusBadRequest, resultPage, "These preferences are not valid, please try again.")
+	default:
+		h.logger.Error("Error handling preferences", slog.Any("error", err))
+		h.render(c, http.StatusInternalServerError, resultPage, "Something went wrong, please try again later.")
+	}
+}
+
+func (h *Handler) render(c *gin.Context, status int, page *template.Template, data any) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		h.logger.Error("Error rendering preferences page", slog.Any("error", err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
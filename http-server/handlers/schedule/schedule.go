@@ -0,0 +1,284 @@
+package schedule
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"subscription-mailing-service/internal/cron"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/scheduler"
+	"subscription-mailing-service/storage/campaign"
+	storageErrors "subscription-mailing-service/storage/errors"
+	schedule2 "subscription-mailing-service/storage/schedule"
+	"time"
+)
+
+type ScheduleHandler interface {
+	GetScheduleID() gin.HandlerFunc
+	GetAllSchedules() gin.HandlerFunc
+	GetScheduleRuns() gin.HandlerFunc
+	CreateSchedule() gin.HandlerFunc
+	PauseSchedule() gin.HandlerFunc
+	ResumeSchedule() gin.HandlerFunc
+	CancelSchedule() gin.HandlerFunc
+}
+
+type Handler struct {
+	store     *schedule2.ScheduleStorage
+	campaigns *campaign.CampaignStorage
+	logger    *slog.Logger
+}
+
+func NewHandler(store *schedule2.ScheduleStorage, campaigns *campaign.CampaignStorage, logger *slog.Logger) *Handler {
+	return &Handler{store: store, campaigns: campaigns, logger: logger}
+}
+
+func (h *Handler) GetScheduleID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduleID, ok := h.scheduleID(c)
+		if !ok {
+			return
+		}
+
+		schedule, err := h.store.Get(c.Request.Context(), scheduleID)
+		if err != nil {
+			h.logger.Error("Error getting schedule", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting schedule"})
+			return
+		}
+
+		if schedule == nil {
+			h.logger.Error("Schedule not found", slog.Int("schedule_id", scheduleID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, schedule)
+	}
+}
+
+func (h *Handler) GetAllSchedules() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schedules, err := h.store.GetAll(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error getting schedules", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting schedules"})
+			return
+		}
+
+		c.JSON(http.StatusOK, schedules)
+	}
+}
+
+func (h *Handler) GetScheduleRuns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduleID, ok := h.scheduleID(c)
+		if !ok {
+			return
+		}
+
+		runs, err := h.store.GetRuns(c.Request.Context(), scheduleID)
+		if err != nil {
+			h.logger.Error("Error getting schedule runs", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting schedule runs"})
+			return
+		}
+
+		c.JSON(http.StatusOK, runs)
+	}
+}
+
+func (h *Handler) CreateSchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var schedule *model.Schedule
+
+		if err := c.ShouldBindJSON(&schedule); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		now := time.Now().UTC()
+		if msg := validateSchedule(schedule, now); msg != "" {
+			h.logger.Error(msg)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		campaign, err := h.campaigns.Get(c.Request.Context(), schedule.CampaignID)
+		if err != nil {
+			h.logger.Error("Error getting campaign", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting campaign"})
+			return
+		}
+
+		if campaign == nil {
+			h.logger.Error("Campaign not found", slog.Int("campaign_id", schedule.CampaignID))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Campaign not found"})
+			return
+		}
+
+		nextRunAt, err := scheduler.NextRun(schedule, now)
+		if err != nil || nextRunAt == nil {
+			h.logger.Error("Schedule never fires", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Schedule never fires"})
+			return
+		}
+
+		schedule.Status = model.ScheduleStatusActive
+		schedule.NextRunAt = nextRunAt
+
+		createdSchedule, err := h.store.Create(c.Request.Context(), schedule)
+		if err != nil {
+			h.logger.Error("Error creating schedule", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating schedule"})
+			return
+		}
+
+		c.JSON(http.StatusOK, createdSchedule)
+	}
+}
+
+func (h *Handler) PauseSchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduleID, ok := h.scheduleID(c)
+		if !ok {
+			return
+		}
+
+		h.updateStatus(c, scheduleID, []string{model.ScheduleStatusActive}, model.ScheduleStatusPaused, nil)
+	}
+}
+
+func (h *Handler) ResumeSchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduleID, ok := h.scheduleID(c)
+		if !ok {
+			return
+		}
+
+		schedule, err := h.store.Get(c.Request.Context(), scheduleID)
+		if !h.found(c, schedule, err) {
+			return
+		}
+
+		// Occurrences missed while paused are skipped; a one-off schedule whose
+		// time has passed fires right away.
+		nextRunAt, err := scheduler.NextRun(schedule, time.Now().UTC())
+		if err != nil {
+			h.logger.Error("Error computing next run", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error computing next run"})
+			return
+		}
+
+		if nextRunAt == nil && schedule.SendAt != nil {
+			sendAt := schedule.SendAt.UTC()
+			nextRunAt = &sendAt
+		}
+
+		h.updateStatus(c, scheduleID, []string{model.ScheduleStatusPaused}, model.ScheduleStatusActive, nextRunAt)
+	}
+}
+
+func (h *Handler) CancelSchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduleID, ok := h.scheduleID(c)
+		if !ok {
+			return
+		}
+
+		h.updateStatus(
+			c,
+			scheduleID,
+			[]string{model.ScheduleStatusActive, model.ScheduleStatusPaused},
+			model.ScheduleStatusCancelled,
+			nil,
+		)
+	}
+}
+
+func (h *Handler) updateStatus(c *gin.Context, scheduleID int, from []string, to string, nextRunAt *time.Time) {
+	schedule, err := h.store.UpdateStatus(c.Request.Context(), scheduleID, from, to, nextRunAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.logger.Error("Schedule not found", slog.Any("error", err))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		if errors.Is(err, storageErrors.ErrScheduleStatus) {
+			h.logger.Error("Invalid schedule status change", slog.Int("schedule_id", scheduleID), slog.String("to", to))
+			c.JSON(http.StatusConflict, gin.H{"error": "Schedule cannot be " + to})
+			return
+		}
+		h.logger.Error("Error updating schedule", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (h *Handler) scheduleID(c *gin.Context) (int, bool) {
+	idStr := c.Param("id")
+	scheduleID, err := strconv.Atoi(idStr)
+	if err != nil {
+		h.logger.Error("Invalid schedule ID", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return 0, false
+	}
+
+	return scheduleID, true
+}
+
+func (h *Handler) found(c *gin.Context, schedule *model.Schedule, err error) bool {
+	if err != nil {
+		h.logger.Error("Error getting schedule", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting schedule"})
+		return false
+	}
+
+	if schedule == nil {
+		h.logger.Error("Schedule not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return false
+	}
+
+	return true
+}
+
+func validateSchedule(schedule *model.Schedule, now time.Time) string {
+	if schedule.CampaignID <= 0 {
+		return "Missing required field: 'campaign_id'"
+	}
+
+	if (schedule.SendAt == nil) == (schedule.CronExpression == "") {
+		return "Exactly one of 'send_at' or 'cron' is required"
+	}
+
+	if schedule.SendAt != nil {
+		if !schedule.SendAt.After(now) {
+			return "'send_at' must be in the future"
+		}
+		sendAt := schedule.SendAt.UTC()
+		schedule.SendAt = &sendAt
+	}
+
+	if schedule.CronExpression != "" {
+		if _, err := cron.Parse(schedule.CronExpression); err != nil {
+			return "Invalid cron expression: " + err.Error()
+		}
+	}
+
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return "Invalid timezone: " + schedule.Timezone
+	}
+
+	return ""
+}
@@ -7,7 +7,15 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"subscription-mailing-service/http-server/middleware"
+	"subscription-mailing-service/internal/lifecycle"
 	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/optin"
+	"subscription-mailing-service/internal/progression"
+	"subscription-mailing-service/internal/renewal"
+	"subscription-mailing-service/internal/subscriberlevel"
+	"subscription-mailing-service/internal/token"
+	storageErrors "subscription-mailing-service/storage/errors"
 	subs "subscription-mailing-service/storage/subscriber"
 )
 
@@ -16,18 +24,38 @@ type SubscriberHandler interface {
 	GetAllSubscribers() gin.HandlerFunc
 	GetSubscribersByLevel() gin.HandlerFunc
 	CreateSubscriber() gin.HandlerFunc
+	ConfirmSubscriber() gin.HandlerFunc
+	TransitionSubscriber() gin.HandlerFunc
+	RenewSubscriber() gin.HandlerFunc
+	GetSubscriberHistory() gin.HandlerFunc
 	UpdateSubscriber() gin.HandlerFunc
 	UpdateSubscriberLevel() gin.HandlerFunc
+	GetSubscriberLevelHistory() gin.HandlerFunc
 	DeleteSubscriber() gin.HandlerFunc
 }
 
 type Handler struct {
-	store  *subs.SubscriberStorage
-	logger *slog.Logger
+	store       *subs.SubscriberStorage
+	optIn       *optin.OptIn
+	progression *progression.Progression
+	renewal     *renewal.Renewal
+	logger      *slog.Logger
 }
 
-func NewHandler(store *subs.SubscriberStorage, logger *slog.Logger) *Handler {
-	return &Handler{store: store, logger: logger}
+func NewHandler(
+	store *subs.SubscriberStorage,
+	optIn *optin.OptIn,
+	progression *progression.Progression,
+	renewal *renewal.Renewal,
+	logger *slog.Logger,
+) *Handler {
+	return &Handler{
+		store:       store,
+		optIn:       optIn,
+		progression: progression,
+		renewal:     renewal,
+		logger:      logger,
+	}
 }
 
 func (h *Handler) GetSubscriberID() gin.HandlerFunc {
@@ -64,27 +92,108 @@ func (h *Handler) GetAllSubscribers() gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": subscribers})
 	}
 }
+// createRequest asks for a new subscription. Without a period the period of
+// the plan applies. Counters and level always start from scratch.
+type createRequest struct {
+	UserID     int  `json:"user_id"`
+	PlanID     *int `json:"plan_id"`
+	PeriodDays *int `json:"period_days"`
+}
+
 func (h *Handler) CreateSubscriber() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var subscriber *model.Subscriber
+		var req createRequest
 
-		if err := c.ShouldBindJSON(&subscriber); err != nil {
+		if err := c.ShouldBindJSON(&req); err != nil {
 			h.logger.Error("Invalid request", slog.Any("Error", err))
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		err := h.store.Create(c.Request.Context(), subscriber)
+		if req.UserID <= 0 {
+			h.logger.Error("Missing user ID")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required field: 'user_id'"})
+			return
+		}
+
+		subscriber := &model.Subscriber{UserID: req.UserID, PlanID: req.PlanID}
+		if req.PeriodDays != nil {
+			if *req.PeriodDays <= 0 {
+				h.logger.Error("Invalid period", slog.Int("period_days", *req.PeriodDays))
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'period_days': must be positive"})
+				return
+			}
+			subscriber.PeriodDays = *req.PeriodDays
+		}
+
+		err := h.optIn.Subscribe(c.Request.Context(), subscriber)
 		if err != nil {
+			if errors.Is(err, storageErrors.ErrUserNotFound) {
+				h.logger.Error("User not found", slog.Any("Error", err))
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'user_id': user not found"})
+				return
+			}
+			if errors.Is(err, optin.ErrNoAddress) {
+				h.logger.Error("User has no email address", slog.Any("Error", err))
+				c.JSON(http.StatusBadRequest, gin.H{"error": "User has no email address"})
+				return
+			}
+			if errors.Is(err, storageErrors.ErrPlanNotFound) {
+				h.logger.Error("Plan not found", slog.Any("Error", err))
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Plan not found"})
+				return
+			}
 			h.logger.Error("Error creating subscriber", slog.Any("Error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating subscriber"})
 			return
 		}
 
+		c.JSON(http.StatusAccepted, gin.H{"message": subscriber})
+	}
+}
+
+func (h *Handler) ConfirmSubscriber() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tok := c.Query("token")
+		if tok == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+			return
+		}
+
+		subscriber, err := h.optIn.Confirm(c.Request.Context(), tok)
+		if err != nil {
+			switch {
+			case errors.Is(err, token.ErrInvalid), errors.Is(err, sql.ErrNoRows):
+				h.logger.Error("Invalid confirmation token", slog.Any("Error", err))
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid confirmation link"})
+			case errors.Is(err, token.ErrExpired), errors.Is(err, storageErrors.ErrNotPending):
+				h.logger.Error("Confirmation link expired", slog.Any("Error", err))
+				c.JSON(http.StatusGone, gin.H{"error": "Confirmation link has expired"})
+			default:
+				h.logger.Error("Error confirming subscriber", slog.Any("Error", err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error confirming subscriber"})
+			}
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": subscriber})
 	}
 }
 
+// selfServiceStatuses are the status changes subscribers may make to their own
+// subscriptions, by target status: pausing, resuming and cancelling.
+var selfServiceStatuses = map[string][]string{
+	model.SubscriberStatusPaused: {model.SubscriberStatusActive},
+	model.SubscriberStatusActive: {model.SubscriberStatusPaused},
+	model.SubscriberStatusCancelled: {
+		model.SubscriberStatusPending,
+		model.SubscriberStatusActive,
+		model.SubscriberStatusPaused,
+	},
+}
+
+// UpdateSubscriber overwrites a subscription. Subscribers updating their own
+// subscription may only pause, resume or cancel it.
 func (h *Handler) UpdateSubscriber() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
@@ -95,6 +204,11 @@ func (h *Handler) UpdateSubscriber() gin.HandlerFunc {
 			return
 		}
 
+		if identity, ok := middleware.CurrentIdentity(c); ok && identity.Role == model.RoleSubscriber {
+			h.updateOwn(c, subscriberID)
+			return
+		}
+
 		var subscriber *model.Subscriber
 		if err := c.ShouldBindJSON(&subscriber); err != nil {
 			h.logger.Error("Invalid request", slog.Any("Error", err))
@@ -104,6 +218,14 @@ func (h *Handler) UpdateSubscriber() gin.HandlerFunc {
 
 		err = h.store.Update(c.Request.Context(), subscriber, subscriberID)
 		if err != nil {
+			if h.transitionFailed(c, err) {
+				return
+			}
+			if errors.Is(err, storageErrors.ErrPlanNotFound) {
+				h.logger.Error("Plan not found", slog.Any("Error", err))
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Plan not found"})
+				return
+			}
 			h.logger.Error("Error updating subscriber", slog.Any("Error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating subscriber"})
 			return
@@ -113,6 +235,174 @@ func (h *Handler) UpdateSubscriber() gin.HandlerFunc {
 	}
 }
 
+func (h *Handler) updateOwn(c *gin.Context, subscriberID int) {
+	var req transitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("Invalid request", slog.Any("Error", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	from, ok := selfServiceStatuses[req.Status]
+	if !ok {
+		h.logger.Error("Status not open to subscribers", slog.String("status", req.Status))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Subscribers may only pause, resume or cancel their subscription",
+			"allowed": []string{model.SubscriberStatusPaused, model.SubscriberStatusActive, model.SubscriberStatusCancelled},
+		})
+		return
+	}
+
+	subscriber, err := h.store.TransitionFrom(c.Request.Context(), subscriberID, from, req.Status, req.Reason)
+	if err != nil {
+		if h.transitionFailed(c, err) {
+			return
+		}
+		h.logger.Error("Error changing subscriber status", slog.Any("Error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error changing subscriber status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": subscriber})
+}
+
+// transitionRequest asks for a status change. Reason is recorded in the
+// subscription's history.
+type transitionRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// TransitionSubscriber changes the status of a subscription. Moving back to
+// pending sends a new confirmation mail.
+func (h *Handler) TransitionSubscriber() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		subscriberID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid subscriber ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscriber ID"})
+			return
+		}
+
+		var req transitionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		var subscriber *model.Subscriber
+		if req.Status == model.SubscriberStatusPending {
+			subscriber, err = h.optIn.Resubscribe(c.Request.Context(), subscriberID, req.Reason)
+		} else {
+			subscriber, err = h.store.Transition(c.Request.Context(), subscriberID, req.Status, req.Reason)
+		}
+		if err != nil {
+			if h.transitionFailed(c, err) {
+				return
+			}
+			if errors.Is(err, optin.ErrNoAddress) {
+				h.logger.Error("User has no email address", slog.Any("Error", err))
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "User has no email address to confirm with"})
+				return
+			}
+			h.logger.Error("Error changing subscriber status", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error changing subscriber status"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": subscriber})
+	}
+}
+
+// RenewSubscriber starts the next period of the subscription, reactivating it
+// if it has ended.
+func (h *Handler) RenewSubscriber() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		subscriberID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid subscriber ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscriber ID"})
+			return
+		}
+
+		subscriber, err := h.renewal.Renew(c.Request.Context(), subscriberID)
+		if err != nil {
+			if h.transitionFailed(c, err) {
+				return
+			}
+
+			h.logger.Error("Error renewing subscriber", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error renewing subscriber"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": subscriber})
+	}
+}
+
+func (h *Handler) GetSubscriberHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		subscriberID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid subscriber ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscriber ID"})
+			return
+		}
+
+		subscriber, err := h.store.Get(c.Request.Context(), subscriberID)
+		if err != nil {
+			h.logger.Error("Error getting subscriber", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting subscriber"})
+			return
+		}
+
+		if subscriber == nil {
+			h.logger.Error("Subscriber not found", slog.Int("subscriber_id", subscriberID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+			return
+		}
+
+		transitions, err := h.store.GetTransitions(c.Request.Context(), subscriberID)
+		if err != nil {
+			h.logger.Error("Error getting subscriber history", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting subscriber history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": transitions})
+	}
+}
+
+// transitionFailed answers requests whose status change was rejected and
+// reports whether it did.
+func (h *Handler) transitionFailed(c *gin.Context, err error) bool {
+	var transitionErr *lifecycle.TransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		h.logger.Error("Illegal subscriber status transition", slog.Any("Error", err))
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   err.Error(),
+			"from":    transitionErr.From,
+			"to":      transitionErr.To,
+			"allowed": lifecycle.Next(transitionErr.From),
+		})
+	case errors.Is(err, lifecycle.ErrUnknownStatus):
+		h.logger.Error("Unknown subscriber status", slog.Any("Error", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "allowed": lifecycle.Statuses()})
+	case errors.Is(err, sql.ErrNoRows):
+		h.logger.Error("Subscriber not found", slog.Any("Error", err))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+	default:
+		return false
+	}
+
+	return true
+}
+
 func (h *Handler) DeleteSubscriber() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
@@ -162,8 +452,19 @@ func (h *Handler) UpdateSubscriberLevel() gin.HandlerFunc {
 			return
 		}
 
-		err = h.store.LevelUp(c.Request.Context(), subscriber, subscriberID)
+		if !subscriberlevel.IsValid(subscriber.SubscriptionLevel) {
+			h.logger.Error("Invalid level subscriber", slog.String("level", subscriber.SubscriptionLevel))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid level subscriber", "allowed": subscriberlevel.Levels()})
+			return
+		}
+
+		_, err = h.progression.SetLevel(c.Request.Context(), subscriberID, subscriber.SubscriptionLevel)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Subscriber not found", slog.Any("Error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+				return
+			}
 			h.logger.Error("Error updating level subscriber", slog.Any("Error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating level subscriber"})
 			return
@@ -173,6 +474,40 @@ func (h *Handler) UpdateSubscriberLevel() gin.HandlerFunc {
 	}
 }
 
+func (h *Handler) GetSubscriberLevelHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		subscriberID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid subscriber ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscriber ID"})
+			return
+		}
+
+		subscriber, err := h.store.Get(c.Request.Context(), subscriberID)
+		if err != nil {
+			h.logger.Error("Error getting subscriber", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting subscriber"})
+			return
+		}
+
+		if subscriber == nil {
+			h.logger.Error("Subscriber not found", slog.Int("subscriber_id", subscriberID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+			return
+		}
+
+		changes, err := h.store.GetLevelChanges(c.Request.Context(), subscriberID)
+		if err != nil {
+			h.logger.Error("Error getting subscriber level history", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting subscriber level history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": changes})
+	}
+}
+
 func (h *Handler) GetSubscribersByLevel() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		level := c.Param("lvl")
@@ -0,0 +1,272 @@
+package suppression
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	netmail "net/mail"
+	"strconv"
+	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
+	suppression2 "subscription-mailing-service/storage/suppression"
+)
+
+// maxImportSize is the largest number of entries a single import may hold.
+const maxImportSize = 10000
+
+type SuppressionHandler interface {
+	GetSuppressionID() gin.HandlerFunc
+	GetAllSuppressions() gin.HandlerFunc
+	CreateSuppression() gin.HandlerFunc
+	ImportSuppressions() gin.HandlerFunc
+	UpdateSuppression() gin.HandlerFunc
+	DeleteSuppression() gin.HandlerFunc
+}
+
+type Handler struct {
+	store  *suppression2.SuppressionStorage
+	logger *slog.Logger
+}
+
+func NewHandler(store *suppression2.SuppressionStorage, logger *slog.Logger) *Handler {
+	return &Handler{store: store, logger: logger}
+}
+
+// importRequest holds the entries to import. Addresses is a shorthand for
+// entries that only give an address; Reason and Source apply to every entry
+// that does not set its own.
+type importRequest struct {
+	Reason    string               `json:"reason"`
+	Source    string               `json:"source"`
+	Entries   []*model.Suppression `json:"entries"`
+	Addresses []string             `json:"addresses"`
+}
+
+type rejectedEntry struct {
+	Address string `json:"address"`
+	Error   string `json:"error"`
+}
+
+func (h *Handler) GetSuppressionID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		suppressionID, ok := h.suppressionID(c)
+		if !ok {
+			return
+		}
+
+		suppression, err := h.store.Get(c.Request.Context(), suppressionID)
+		if err != nil {
+			h.logger.Error("Error getting suppression", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting suppression"})
+			return
+		}
+
+		if suppression == nil {
+			h.logger.Error("Suppression not found", slog.Int("suppression_id", suppressionID))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Suppression not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, suppression)
+	}
+}
+
+func (h *Handler) GetAllSuppressions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		suppressions, err := h.store.GetAll(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error getting suppressions", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting suppressions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, suppressions)
+	}
+}
+
+func (h *Handler) CreateSuppression() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var suppression model.Suppression
+		if err := c.ShouldBindJSON(&suppression); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if msg := validateSuppression(&suppression); msg != "" {
+			h.logger.Error("Invalid suppression", slog.String("reason", msg))
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if err := h.store.Create(c.Request.Context(), &suppression); err != nil {
+			if errors.Is(err, storageErrors.ErrAlreadySuppressed) {
+				h.logger.Error("Address already suppressed", slog.String("address", suppression.Address))
+				c.JSON(http.StatusConflict, gin.H{"error": "Address is already suppressed"})
+				return
+			}
+			h.logger.Error("Error creating suppression", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating suppression"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, suppression)
+	}
+}
+
+// ImportSuppressions adds many entries at once. Invalid entries are reported
+// and left out; addresses already on the list are counted as duplicates.
+func (h *Handler) ImportSuppressions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req importRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		for _, address := range req.Addresses {
+			req.Entries = append(req.Entries, &model.Suppression{Address: address})
+		}
+
+		if len(req.Entries) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to import: give 'entries' or 'addresses'"})
+			return
+		}
+
+		if len(req.Entries) > maxImportSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Too many entries: at most " + strconv.Itoa(maxImportSize)})
+			return
+		}
+
+		valid := make([]*model.Suppression, 0, len(req.Entries))
+		rejected := []rejectedEntry{}
+		for _, entry := range req.Entries {
+			if entry == nil {
+				continue
+			}
+			if entry.Reason == "" {
+				entry.Reason = req.Reason
+			}
+			if entry.Source == "" {
+				entry.Source = req.Source
+			}
+
+			if msg := validateSuppression(entry); msg != "" {
+				rejected = append(rejected, rejectedEntry{Address: entry.Address, Error: msg})
+				continue
+			}
+			valid = append(valid, entry)
+		}
+
+		imported, err := h.store.Import(c.Request.Context(), valid)
+		if err != nil {
+			h.logger.Error("Error importing suppressions", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error importing suppressions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"imported":   imported,
+			"duplicates": len(valid) - len(imported),
+			"rejected":   rejected,
+		})
+	}
+}
+
+func (h *Handler) UpdateSuppression() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		suppressionID, ok := h.suppressionID(c)
+		if !ok {
+			return
+		}
+
+		var suppression model.Suppression
+		if err := c.ShouldBindJSON(&suppression); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if !validReason(suppression.Reason) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reason: use 'hard_bounce', 'complaint', 'manual' or 'unsubscribed'"})
+			return
+		}
+
+		if err := h.store.Update(c.Request.Context(), &suppression, suppressionID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Suppression not found", slog.Int("suppression_id", suppressionID))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Suppression not found"})
+				return
+			}
+			h.logger.Error("Error updating suppression", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating suppression"})
+			return
+		}
+
+		c.JSON(http.StatusOK, suppression)
+	}
+}
+
+func (h *Handler) DeleteSuppression() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		suppressionID, ok := h.suppressionID(c)
+		if !ok {
+			return
+		}
+
+		if err := h.store.Delete(c.Request.Context(), suppressionID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Suppression not found", slog.Int("suppression_id", suppressionID))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Suppression not found"})
+				return
+			}
+			h.logger.Error("Error deleting suppression", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting suppression"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Suppression deleted successfully"})
+	}
+}
+
+func (h *Handler) suppressionID(c *gin.Context) (int, bool) {
+	suppressionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		h.logger.Error("Invalid suppression ID", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid suppression ID"})
+		return 0, false
+	}
+
+	return suppressionID, true
+}
+
+// validateSuppression checks the entry and reduces its address to the bare
+// address part. It returns a message describing the first problem found.
+func validateSuppression(suppression *model.Suppression) string {
+	address, err := netmail.ParseAddress(suppression.Address)
+	if err != nil {
+		return "Invalid address"
+	}
+	suppression.Address = address.Address
+
+	if !validReason(suppression.Reason) {
+		return "Invalid reason: use 'hard_bounce', 'complaint', 'manual' or 'unsubscribed'"
+	}
+
+	return ""
+}
+
+func validReason(reason string) bool {
+	switch reason {
+	case model.SuppressionReasonHardBounce,
+		model.SuppressionReasonComplaint,
+		model.SuppressionReasonManual,
+		model.SuppressionReasonUnsubscribed:
+		return true
+	}
+
+	return false
+}
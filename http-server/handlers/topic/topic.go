@@ -0,0 +1,194 @@
+package topic
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
+	topic2 "subscription-mailing-service/storage/topic"
+)
+
+// keyPattern is what topic keys look like; they end up in preference center
+// form fields and plan definitions.
+var keyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,99}$`)
+
+type TopicHandler interface {
+	GetTopic() gin.HandlerFunc
+	GetAllTopics() gin.HandlerFunc
+	CreateTopic() gin.HandlerFunc
+	UpdateTopic() gin.HandlerFunc
+	DeleteTopic() gin.HandlerFunc
+}
+
+type Handler struct {
+	store  *topic2.TopicStorage
+	logger *slog.Logger
+}
+
+func NewHandler(store *topic2.TopicStorage, logger *slog.Logger) *Handler {
+	return &Handler{store: store, logger: logger}
+}
+
+// topicRequest describes a topic. DefaultOptIn defaults to true, so users
+// receive new topics unless they opt out.
+type topicRequest struct {
+	Key          string `json:"key"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	DefaultOptIn *bool  `json:"default_opt_in"`
+}
+
+func (r *topicRequest) topic() *model.Topic {
+	topic := &model.Topic{
+		Key:          strings.ToLower(strings.TrimSpace(r.Key)),
+		Name:         strings.TrimSpace(r.Name),
+		Description:  r.Description,
+		DefaultOptIn: true,
+	}
+	if r.DefaultOptIn != nil {
+		topic.DefaultOptIn = *r.DefaultOptIn
+	}
+
+	return topic
+}
+
+func (h *Handler) GetTopic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+
+		topic, err := h.store.Get(c.Request.Context(), key)
+		if err != nil {
+			h.logger.Error("Error getting topic", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting topic"})
+			return
+		}
+
+		if topic == nil {
+			h.logger.Error("Topic not found", slog.String("topic", key))
+			c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, topic)
+	}
+}
+
+func (h *Handler) GetAllTopics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		topics, err := h.store.GetAll(c.Request.Context())
+		if err != nil {
+			h.logger.Error("Error getting topics", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting topics"})
+			return
+		}
+
+		c.JSON(http.StatusOK, topics)
+	}
+}
+
+func (h *Handler) CreateTopic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req topicRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		topic := req.topic()
+		if !keyPattern.MatchString(topic.Key) {
+			h.logger.Error("Invalid topic key", slog.String("topic", topic.Key))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key: use lowercase letters, digits, '-' and '_'"})
+			return
+		}
+
+		if msg := validateTopic(topic); msg != "" {
+			h.logger.Error("Invalid topic", slog.String("reason", msg))
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if err := h.store.Create(c.Request.Context(), topic); err != nil {
+			if errors.Is(err, storageErrors.ErrDuplicateTopic) {
+				h.logger.Error("Topic already exists", slog.String("topic", topic.Key))
+				c.JSON(http.StatusConflict, gin.H{"error": "A topic with this key already exists"})
+				return
+			}
+			h.logger.Error("Error creating topic", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating topic"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, topic)
+	}
+}
+
+func (h *Handler) UpdateTopic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+
+		var req topicRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		topic := req.topic()
+
+		if msg := validateTopic(topic); msg != "" {
+			h.logger.Error("Invalid topic", slog.String("reason", msg))
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if err := h.store.Update(c.Request.Context(), topic, key); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("Topic not found", slog.String("topic", key))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+				return
+			}
+			h.logger.Error("Error updating topic", slog.Any("error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating topic"})
+			return
+		}
+
+		c.JSON(http.StatusOK, topic)
+	}
+}
+
+func (h *Handler) DeleteTopic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+
+		if err := h.store.Delete(c.Request.Context(), key); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				h.logger.Error("Topic not found", slog.String("topic", key))
+				c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+			case errors.Is(err, storageErrors.ErrTopicInUse):
+				h.logger.Error("Topic is in use", slog.String("topic", key))
+				c.JSON(http.StatusConflict, gin.H{"error": "Topic is still used by campaigns or plans"})
+			default:
+				h.logger.Error("Error deleting topic", slog.Any("error", err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting topic"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Topic deleted successfully"})
+	}
+}
+
+func validateTopic(topic *model.Topic) string {
+	if topic.Name == "" {
+		return "Missing required field: 'name'"
+	}
+
+	return ""
+}
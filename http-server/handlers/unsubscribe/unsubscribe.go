@@ -0,0 +1,120 @@
+package unsubscribe
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"subscription-mailing-service/internal/token"
+	"subscription-mailing-service/internal/unsubscribe"
+)
+
+const (
+	// oneClickValue is the form body mail clients POST for RFC 8058 one-click
+	// unsubscribes.
+	oneClickValue = "One-Click"
+
+	reasonOneClick = "one-click"
+	reasonLink     = "unsubscribe link"
+)
+
+var confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+<p>Do you want to stop receiving mails at {{.Address}}?</p>
+<form method="post">
+<p><label>Reason (optional)<br><input type="text" name="reason" maxlength="500"></label></p>
+<p><button type="submit">Unsubscribe</button></p>
+</form>
+</body>
+</html>
+`))
+
+var resultPage = template.Must(template.New("result").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+<p>{{.}}</p>
+</body>
+</html>
+`))
+
+type UnsubscribeHandler interface {
+	ConfirmUnsubscribe() gin.HandlerFunc
+	Unsubscribe() gin.HandlerFunc
+}
+
+type Handler struct {
+	unsubscriber *unsubscribe.Unsubscriber
+	logger       *slog.Logger
+}
+
+func NewHandler(unsubscriber *unsubscribe.Unsubscriber, logger *slog.Logger) *Handler {
+	return &Handler{unsubscriber: unsubscriber, logger: logger}
+}
+
+// ConfirmUnsubscribe renders the page asking the recipient to confirm. It
+// changes nothing, so link scanners following it do no harm.
+func (h *Handler) ConfirmUnsubscribe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		recipient, err := h.unsubscriber.Recipient(c.Request.Context(), c.Query("token"))
+		if err != nil {
+			h.fail(c, err)
+			return
+		}
+
+		h.render(c, http.StatusOK, confirmPage, gin.H{"Address": recipient.Address})
+	}
+}
+
+// Unsubscribe ends the subscription. It serves both the confirmation form and
+// RFC 8058 one-click requests sent by mail clients.
+func (h *Handler) Unsubscribe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reason := strings.TrimSpace(c.PostForm("reason"))
+		if reason == "" {
+			reason = reasonLink
+			if c.PostForm("List-Unsubscribe") == oneClickValue {
+				reason = reasonOneClick
+			}
+		}
+
+		recipient, err := h.unsubscriber.Unsubscribe(c.Request.Context(), c.Query("token"), reason)
+		if err != nil {
+			h.fail(c, err)
+			return
+		}
+
+		h.logger.Info("Recipient unsubscribed", slog.Int("recipient_id", recipient.ID), slog.String("reason", reason))
+		h.render(c, http.StatusOK, resultPage, "You have been unsubscribed.")
+	}
+}
+
+func (h *Handler) fail(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, token.ErrExpired):
+		h.logger.Error("Unsubscribe link expired", slog.Any("Error", err))
+		h.render(c, http.StatusGone, resultPage, "This unsubscribe link has expired.")
+	case errors.Is(err, token.ErrInvalid):
+		h.logger.Error("Invalid unsubscribe token", slog.Any("Error", err))
+		h.render(c, http.StatusBadRequest, resultPage, "This unsubscribe link is not valid.")
+	default:
+		h.logger.Error("Error unsubscribing", slog.Any("Error", err))
+		h.render(c, http.StatusInternalServerError, resultPage, "Something went wrong, please try again later.")
+	}
+}
+
+func (h *Handler) render(c *gin.Context, status int, page *template.Template, data any) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		h.logger.Error("Error rendering unsubscribe page", slog.Any("Error", err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
@@ -7,7 +7,9 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"subscription-mailing-service/internal/auth"
 	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
 	user2 "subscription-mailing-service/storage/user"
 )
 
@@ -17,15 +19,20 @@ type UserHandler interface {
 	CreateUser() gin.HandlerFunc
 	UpdateUser() gin.HandlerFunc
 	DeleteUser() gin.HandlerFunc
+	SetUserRole() gin.HandlerFunc
+	ChangePassword() gin.HandlerFunc
 }
 
+const passwordTooShort = "Password must be at least 8 characters"
+
 type Handler struct {
 	store  *user2.UserStorage
+	auth   *auth.Service
 	logger *slog.Logger
 }
 
-func NewHandler(store *user2.UserStorage, logger *slog.Logger) *Handler {
-	return &Handler{store: store, logger: logger}
+func NewHandler(store *user2.UserStorage, authService *auth.Service, logger *slog.Logger) *Handler {
+	return &Handler{store: store, auth: authService, logger: logger}
 }
 
 func (h *Handler) GetUserID() gin.HandlerFunc {
@@ -52,7 +59,7 @@ func (h *Handler) GetUserID() gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, user)
+		c.JSON(http.StatusOK, user.Response())
 	}
 }
 
@@ -65,36 +72,58 @@ func (h *Handler) GetAllUsers() gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, users)
+		response := make([]*model.UserResponse, 0, len(users))
+		for _, user := range users {
+			response = append(response, user.Response())
+		}
+
+		c.JSON(http.StatusOK, response)
 	}
 }
 
 func (h *Handler) CreateUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var user *model.User
+		var req model.UserRequest
 
-		if err := c.ShouldBindJSON(&user); err != nil {
+		if err := c.ShouldBindJSON(&req); err != nil {
 			h.logger.Error("Invalid request", slog.Any("Error", err))
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		if user.Login == "" {
+		if req.Login == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Login is required"})
 			return
 		}
 
-		createdUser, err := h.store.Create(c.Request.Context(), user)
+		if len(req.Password) < auth.MinPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": passwordTooShort})
+			return
+		}
+
+		if req.Role != "" && !model.ValidRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be 'admin', 'operator' or 'subscriber'"})
+			return
+		}
+
+		createdUser, err := h.store.Create(c.Request.Context(), &req)
 		if err != nil {
+			if errors.Is(err, storageErrors.ErrDuplicateLogin) {
+				h.logger.Error("Login already taken", slog.Any("Error", err))
+				c.JSON(http.StatusConflict, gin.H{"error": "Login is already taken"})
+				return
+			}
 			h.logger.Error("Error create user", slog.Any("Error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error create user"})
 			return
 		}
 
-		c.JSON(http.StatusOK, createdUser)
+		c.JSON(http.StatusOK, createdUser.Response())
 	}
 }
 
+// UpdateUser changes the profile of a user. Passwords change through
+// ChangePassword.
 func (h *Handler) UpdateUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
@@ -105,26 +134,81 @@ func (h *Handler) UpdateUser() gin.HandlerFunc {
 			return
 		}
 
-		var user *model.User
-		if err := c.ShouldBindJSON(&user); err != nil {
+		var req model.UserUpdate
+		if err := c.ShouldBindJSON(&req); err != nil {
 			h.logger.Error("Invalid request", slog.Any("Error", err))
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
 
-		err = h.store.Update(c.Request.Context(), user, userID)
+		if req.Login == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Login is required"})
+			return
+		}
+
+		user, err := h.store.Update(c.Request.Context(), &req, userID)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				h.logger.Error("User not found", slog.Any("Error", err))
 				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 				return
 			}
+			if errors.Is(err, storageErrors.ErrDuplicateLogin) {
+				h.logger.Error("Login already taken", slog.Any("Error", err))
+				c.JSON(http.StatusConflict, gin.H{"error": "Login is already taken"})
+				return
+			}
 			h.logger.Error("Error update user", slog.Any("Error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error update user"})
 			return
 		}
 
-		c.JSON(http.StatusOK, user)
+		c.JSON(http.StatusOK, user.Response())
+	}
+}
+
+// ChangePassword replaces the password of a user who knows the current one.
+// Every session of the user ends, so other devices have to log in again.
+func (h *Handler) ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		userID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid user ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var req model.PasswordChange
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if len(req.NewPassword) < auth.MinPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": passwordTooShort})
+			return
+		}
+
+		err = h.auth.ChangePassword(c.Request.Context(), userID, req.CurrentPassword, req.NewPassword)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("User not found", slog.Any("Error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				h.logger.Error("Wrong current password", slog.Int("user_id", userID))
+				c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+				return
+			}
+			h.logger.Error("Error change password", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error change password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 	}
 }
 
@@ -138,18 +222,72 @@ func (h *Handler) DeleteUser() gin.HandlerFunc {
 			return
 		}
 
-		err = h.store.Delete(c.Request.Context(), userID)
+		anonymized, err := h.store.Delete(c.Request.Context(), userID)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				h.logger.Error("User not found", slog.Any("Error", err))
 				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 				return
 			}
+			if errors.Is(err, storageErrors.ErrUserHasSubscribers) {
+				h.logger.Error("User has subscriptions", slog.Any("Error", err))
+				c.JSON(http.StatusConflict, gin.H{"error": "User still has subscriptions"})
+				return
+			}
 			h.logger.Error("Error delete user", slog.Any("Error", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error delete user"})
 			return
 		}
 
+		if anonymized {
+			c.JSON(http.StatusOK, gin.H{"message": "User anonymized and subscriptions ended"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 	}
 }
+
+type roleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// SetUserRole changes the role of a user. It takes effect when the user's
+// access token is next refreshed.
+func (h *Handler) SetUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		userID, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.logger.Error("Invalid user ID", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var req roleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.logger.Error("Invalid request", slog.Any("Error", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if !model.ValidRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be 'admin', 'operator' or 'subscriber'"})
+			return
+		}
+
+		err = h.store.SetRole(c.Request.Context(), userID, req.Role)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.logger.Error("User not found", slog.Any("Error", err))
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			h.logger.Error("Error set user role", slog.Any("Error", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error set user role"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully"})
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"strings"
+	"subscription-mailing-service/internal/auth"
+	"subscription-mailing-service/internal/jwt"
+)
+
+// identityKey is the context key the authenticated identity is stored under.
+const identityKey = "identity"
+
+// apiKeyHeader carries the API keys of other services.
+const apiKeyHeader = "X-API-Key"
+
+// Authenticate rejects requests without either a valid bearer access token or
+// a valid API key, and makes the caller available through CurrentIdentity.
+func Authenticate(service *auth.Service, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader(apiKeyHeader); key != "" {
+			identity, err := service.AuthenticateKey(c.Request.Context(), key, c.ClientIP())
+			if err != nil {
+				switch {
+				case errors.Is(err, auth.ErrKeyExpired):
+					logger.Error("Rejected API key", slog.Any("error", err))
+					unauthorized(c, "API key expired")
+				case errors.Is(err, auth.ErrInvalidKey), errors.Is(err, auth.ErrAddressNotAllowed):
+					logger.Error("Rejected API key", slog.Any("error", err), slog.String("ip", c.ClientIP()),
+						slog.String("remote_addr", c.Request.RemoteAddr))
+					unauthorized(c, "Invalid API key")
+				default:
+					logger.Error("Error checking API key", slog.Any("error", err))
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error checking API key"})
+				}
+				return
+			}
+
+			c.Set(identityKey, identity)
+			c.Next()
+			return
+		}
+
+		scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			unauthorized(c, "Missing bearer token")
+			return
+		}
+
+		identity, err := service.Authenticate(token)
+		if err != nil {
+			logger.Error("Rejected access token", slog.Any("error", err))
+			if errors.Is(err, jwt.ErrExpired) {
+				unauthorized(c, "Access token expired")
+				return
+			}
+			unauthorized(c, "Invalid access token")
+			return
+		}
+
+		c.Set(identityKey, identity)
+		c.Next()
+	}
+}
+
+// CurrentIdentity returns who the request was authenticated as.
+func CurrentIdentity(c *gin.Context) (*auth.Identity, bool) {
+	value, ok := c.Get(identityKey)
+	if !ok {
+		return nil, false
+	}
+
+	identity, ok := value.(*auth.Identity)
+	return identity, ok
+}
+
+func unauthorized(c *gin.Context, msg string) {
+	c.Header("WWW-Authenticate", `Bearer realm="api"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+}
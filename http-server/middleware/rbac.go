@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/subscriber"
+)
+
+// Access is what a role may do within a route group.
+type Access int
+
+const (
+	AccessNone Access = iota
+	// AccessOwn allows the self-service routes, on records of the caller
+	// only.
+	AccessOwn
+	// AccessRead allows reading every record.
+	AccessRead
+	// AccessFull allows everything.
+	AccessFull
+)
+
+// Permissions is the permission matrix: the access of every role per route
+// group. Roles missing from a group have no access to it.
+var Permissions = map[string]map[string]Access{
+	"users": {
+		model.RoleAdmin:      AccessFull,
+		model.RoleSubscriber: AccessOwn,
+	},
+	"subscribers": {
+		model.RoleAdmin:      AccessFull,
+		model.RoleOperator:   AccessRead,
+		model.RoleSubscriber: AccessOwn,
+	},
+	"topics": {
+		model.RoleAdmin:      AccessFull,
+		model.RoleOperator:   AccessFull,
+		model.RoleSubscriber: AccessRead,
+	},
+	"plans": {
+		model.RoleAdmin:      AccessFull,
+		model.RoleOperator:   AccessFull,
+		model.RoleSubscriber: AccessRead,
+	},
+	"messages":           {model.RoleAdmin: AccessFull, model.RoleOperator: AccessFull},
+	"mails":              {model.RoleAdmin: AccessFull, model.RoleOperator: AccessFull},
+	"campaigns":          {model.RoleAdmin: AccessFull, model.RoleOperator: AccessFull},
+	"schedules":          {model.RoleAdmin: AccessFull, model.RoleOperator: AccessFull},
+	"suppressions":       {model.RoleAdmin: AccessFull, model.RoleOperator: AccessFull},
+	"apikeys":            {model.RoleAdmin: AccessFull},
+	"levelnotifications": {model.RoleAdmin: AccessFull, model.RoleOperator: AccessFull},
+	"admin":              {model.RoleAdmin: AccessFull},
+}
+
+// selfService are the routes open to AccessOwn, by method and route path.
+// Each of them addresses a single record through its ":id" parameter.
+var selfService = map[string]bool{
+	"GET /api/users/get/:id":                true,
+	"PUT /api/users/:id/password":           true,
+	"PUT /api/users/update/:id":             true,
+	"GET /api/subscribers/get/:id":          true,
+	"PUT /api/subscribers/update/:id":       true,
+	"GET /api/subscribers/history/:id":      true,
+	"GET /api/subscribers/levelhistory/:id": true,
+}
+
+// scopeRoutes are the routes each API key scope opens, by method and route
+// path. API keys reach nothing else.
+var scopeRoutes = map[string][]string{
+	model.ScopeMailSend: {
+		"POST /api/mails/send",
+		"GET /api/mails/status/:id",
+	},
+	model.ScopeSubscribersRead: {
+		"GET /api/subscribers/getall",
+		"GET /api/subscribers/getall/:lvl",
+		"GET /api/subscribers/get/:id",
+		"GET /api/subscribers/history/:id",
+		"GET /api/subscribers/levelhistory/:id",
+	},
+}
+
+// Owner returns the user owning the record a request addresses, or false when
+// there is no such record.
+type Owner func(c *gin.Context) (int, bool, error)
+
+// UserOwner resolves routes addressing a user: users own themselves.
+func UserOwner(c *gin.Context) (int, bool, error) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, false, nil
+	}
+
+	return userID, true, nil
+}
+
+// SubscriberOwner resolves routes addressing a subscription to its user.
+func SubscriberOwner(store *subscriber.SubscriberStorage) Owner {
+	return func(c *gin.Context) (int, bool, error) {
+		subscriberID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return 0, false, nil
+		}
+
+		s, err := store.Get(c.Request.Context(), subscriberID)
+		if err != nil || s == nil {
+			return 0, false, err
+		}
+
+		return s.UserID, true, nil
+	}
+}
+
+// Authorize enforces the permission matrix for the route group, or the scopes
+// for API keys. It has to run after Authenticate. owner is only consulted for
+// AccessOwn and may be nil for groups no role has that access to.
+func Authorize(group string, owner Owner, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, ok := CurrentIdentity(c)
+		if !ok {
+			unauthorized(c, "Missing bearer token")
+			return
+		}
+
+		route := c.Request.Method + " " + c.FullPath()
+
+		if identity.APIKeyID != 0 {
+			for _, scope := range identity.Scopes {
+				if slices.Contains(scopeRoutes[scope], route) {
+					c.Next()
+					return
+				}
+			}
+
+			logger.Warn("Access denied", slog.Int("api_key_id", identity.APIKeyID), slog.String("route", route))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		switch Permissions[group][identity.Role] {
+		case AccessFull:
+			c.Next()
+			return
+		case AccessRead:
+			if c.Request.Method == http.MethodGet {
+				c.Next()
+				return
+			}
+		case AccessOwn:
+			if owner != nil && selfService[route] {
+				userID, found, err := owner(c)
+				if err != nil {
+					logger.Error("Error resolving record owner", slog.Any("error", err))
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error checking permissions"})
+					return
+				}
+				if found && userID == identity.UserID {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		logger.Warn("Access denied",
+			slog.Int("user_id", identity.UserID),
+			slog.String("role", identity.Role),
+			slog.String("route", route),
+		)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	}
+}
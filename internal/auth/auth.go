@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/jwt"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/apikey"
+	"subscription-mailing-service/storage/refreshtoken"
+	"subscription-mailing-service/storage/user"
+	"time"
+)
+
+const (
+	// MinPasswordLength is the shortest password users can set.
+	MinPasswordLength = 8
+	// MinSecretLength is the shortest secret accepted for signing access
+	// tokens.
+	MinSecretLength = 32
+
+	defaultAccessTTL  = 15 * time.Minute
+	defaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	ErrInvalidToken       = errors.New("token is invalid or expired")
+)
+
+// dummyHash is compared against when the login does not exist, so unknown
+// logins take as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// Identity is who a request is made by: a user holding an access token, or a
+// client holding an API key. The role is the one the user had when the token
+// was issued; role changes apply from the next refresh.
+type Identity struct {
+	UserID int
+	Role   string
+
+	// APIKeyID is set for API keys, which act with their scopes instead of
+	// a role.
+	APIKeyID int
+	Scopes   []string
+}
+
+// Service logs users in with their password and issues short-lived access
+// tokens together with refresh tokens that are exchanged on every use. It also
+// issues and checks the API keys of other services.
+type Service struct {
+	users      *user.UserStorage
+	tokens     *refreshtoken.RefreshTokenStorage
+	keys       *apikey.APIKeyStorage
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewService uses the configured secret, which must be at least
+// MinSecretLength bytes long.
+func NewService(
+	users *user.UserStorage,
+	tokens *refreshtoken.RefreshTokenStorage,
+	keys *apikey.APIKeyStorage,
+	cfg *config.Config,
+) (*Service, error) {
+	s := &Service{
+		users:      users,
+		tokens:     tokens,
+		keys:       keys,
+		secret:     []byte(cfg.Auth.Secret),
+		accessTTL:  cfg.Auth.AccessTTL,
+		refreshTTL: cfg.Auth.RefreshTTL,
+	}
+
+	if len(s.secret) < MinSecretLength {
+		return nil, fmt.Errorf("auth.secret must be at least %d bytes long", MinSecretLength)
+	}
+	if s.accessTTL <= 0 {
+		s.accessTTL = defaultAccessTTL
+	}
+	if s.refreshTTL <= 0 {
+		s.refreshTTL = defaultRefreshTTL
+	}
+
+	return s, nil
+}
+
+// Login checks the password of the user with the login and starts a session.
+func (s *Service) Login(ctx context.Context, login, password string) (*model.Tokens, error) {
+	u, err := s.users.GetByLogin(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil || u.Password == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	refresh, hash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.tokens.Create(ctx, u.ID, hash, s.refreshTTL); err != nil {
+		return nil, err
+	}
+
+	return s.issue(u, refresh)
+}
+
+// Refresh exchanges a refresh token for new tokens; the old refresh token
+// stops working. Presenting a refresh token a second time ends every session
+// of its user.
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (*model.Tokens, error) {
+	refresh, hash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := s.tokens.Rotate(ctx, hashToken(refreshToken), hash, s.refreshTTL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil {
+		return nil, ErrInvalidToken
+	}
+
+	return s.issue(u, refresh)
+}
+
+// Logout revokes the refresh token. Access tokens already issued stay valid
+// until they expire.
+func (s *Service) Logout(ctx context.Context, refreshToken string) error {
+	err := s.tokens.Revoke(ctx, hashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidToken
+	}
+
+	return err
+}
+
+// ChangePassword replaces the password of the user after checking the current
+// one, and ends every session of the user.
+func (s *Service) ChangePassword(ctx context.Context, userID int, current, password string) error {
+	u, err := s.users.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if u == nil {
+		return sql.ErrNoRows
+	}
+
+	if u.Password == "" || bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(current)) != nil {
+		return ErrInvalidCredentials
+	}
+
+	if err := s.users.SetPassword(ctx, userID, password); err != nil {
+		return err
+	}
+
+	return s.tokens.RevokeAll(ctx, userID)
+}
+
+// Authenticate verifies an access token and returns who it was issued to.
+func (s *Service) Authenticate(accessToken string) (*Identity, error) {
+	claims, err := jwt.Parse(accessToken, s.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || !model.ValidRole(claims.Role) {
+		return nil, jwt.ErrInvalid
+	}
+
+	return &Identity{UserID: userID, Role: claims.Role}, nil
+}
+
+func (s *Service) issue(u *model.User, refresh string) (*model.Tokens, error) {
+	now := time.Now()
+	access, err := jwt.Sign(&jwt.Claims{
+		Subject:   strconv.Itoa(u.ID),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.accessTTL).Unix(),
+		Role:      u.Role,
+	}, s.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Tokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+	}, nil
+}
+
+// newToken returns a random token and the hash it is stored by.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
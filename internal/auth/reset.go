@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/url"
+	"strings"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/outbox"
+	"subscription-mailing-service/storage/passwordreset"
+	"subscription-mailing-service/storage/refreshtoken"
+	"subscription-mailing-service/storage/user"
+	"time"
+)
+
+const (
+	defaultResetTTL         = time.Hour
+	defaultResetMaxRequests = 3
+	defaultResetWindow      = time.Hour
+
+	// resetQueueSize is how many requests may wait for the background worker.
+	resetQueueSize = 100
+
+	resetSubject = "Reset your password"
+)
+
+var (
+	ErrRateLimited = errors.New("too many password reset requests for this address")
+	ErrResetBusy   = errors.New("too many password reset requests waiting")
+)
+
+// Resetter lets users who forgot their password set a new one through a
+// single-use link mailed to their address.
+type Resetter struct {
+	users       *user.UserStorage
+	resets      *passwordreset.PasswordResetStorage
+	tokens      *refreshtoken.RefreshTokenStorage
+	outbox      *outbox.OutboxStorage
+	url         string
+	ttl         time.Duration
+	maxRequests int
+	window      time.Duration
+	requests    chan string
+	logger      *slog.Logger
+}
+
+func NewResetter(
+	users *user.UserStorage,
+	resets *passwordreset.PasswordResetStorage,
+	tokens *refreshtoken.RefreshTokenStorage,
+	outbox *outbox.OutboxStorage,
+	cfg *config.Config,
+	logger *slog.Logger,
+) *Resetter {
+	r := &Resetter{
+		users:       users,
+		resets:      resets,
+		tokens:      tokens,
+		outbox:      outbox,
+		url:         cfg.PasswordReset.URL,
+		ttl:         cfg.PasswordReset.TTL,
+		maxRequests: cfg.PasswordReset.MaxRequests,
+		window:      cfg.PasswordReset.Window,
+		requests:    make(chan string, resetQueueSize),
+		logger:      logger,
+	}
+
+	if r.ttl <= 0 {
+		r.ttl = defaultResetTTL
+	}
+	if r.maxRequests <= 0 {
+		r.maxRequests = defaultResetMaxRequests
+	}
+	if r.window <= 0 {
+		r.window = defaultResetWindow
+	}
+
+	return r
+}
+
+// Request queues a reset link for every user with the address. The links are
+// mailed by Run, so a request takes as long for known addresses as for
+// unknown ones and callers cannot tell them apart. It only fails with
+// ErrResetBusy, when too many requests are waiting already.
+func (r *Resetter) Request(email string) error {
+	select {
+	case r.requests <- strings.ToLower(strings.TrimSpace(email)):
+		return nil
+	default:
+		return ErrResetBusy
+	}
+}
+
+// Run mails the requested reset links until ctx is cancelled.
+func (r *Resetter) Run(ctx context.Context) {
+	r.logger.Info("Password reset mailer started")
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info("Password reset mailer stopped")
+			return
+		case email := <-r.requests:
+			err := r.mail(ctx, email)
+			switch {
+			case errors.Is(err, ErrRateLimited):
+				r.logger.Warn("Password reset rate limited")
+			case err != nil && ctx.Err() == nil:
+				r.logger.Error("Error mailing password reset", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// mail sends a reset link to every user with the address. Addresses without
+// users are ignored; addresses that asked too often yield ErrRateLimited.
+func (r *Resetter) mail(ctx context.Context, email string) error {
+	users, err := r.users.GetByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if err := r.send(ctx, u, email); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset sets the password of the user the token was issued for and ends
+// every session of the user. The token, and any other outstanding token of
+// the user, cannot be used again.
+func (r *Resetter) Reset(ctx context.Context, token, password string) error {
+	userID, err := r.resets.Consume(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.users.SetPassword(ctx, userID, password)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.tokens.RevokeAll(ctx, userID)
+}
+
+func (r *Resetter) send(ctx context.Context, u *model.User, email string) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	created, err := r.resets.Create(ctx, u.ID, email, hash, r.ttl, r.window, r.maxRequests)
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		return ErrRateLimited
+	}
+
+	link, err := url.Parse(r.url)
+	if err != nil {
+		return err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	_, err = r.outbox.Enqueue(ctx, &model.Mail{
+		To:            []string{u.Email},
+		Subject:       resetSubject,
+		Body:          resetBody(u, link.String(), r.ttl),
+		ContentType:   model.ContentTypeHTML,
+		Transactional: true,
+	})
+	if err != nil {
+		return fmt.Errorf("queueing password reset mail: %w", err)
+	}
+
+	return nil
+}
+
+func resetBody(u *model.User, link string, ttl time.Duration) string {
+	name := u.FirstName
+	if name == "" {
+		name = u.Login
+	}
+
+	return fmt.Sprintf(
+		"<p>Hello %s,</p>\n"+
+			"<p>A password reset was requested for your account <b>%s</b>. Follow this link to choose a new password:</p>\n"+
+			"<p><a href=\"%s\">Reset password</a></p>\n"+
+			"<p>The link works once and expires in %s.</p>\n"+
+			"<p>If you did not ask for this, you can ignore this message; your password stays unchanged.</p>\n",
+		html.EscapeString(name),
+		html.EscapeString(u.Login),
+		html.EscapeString(link),
+		validity(ttl),
+	)
+}
+
+// validity describes how long a link works in words.
+func validity(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		if ttl == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", ttl/time.Hour)
+	}
+
+	minutes := int(ttl.Round(time.Minute) / time.Minute)
+	if minutes <= 1 {
+		return "1 minute"
+	}
+
+	return fmt.Sprintf("%d minutes", minutes)
+}
@@ -0,0 +1,379 @@
+package composer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/sender"
+	"time"
+)
+
+const (
+	defaultMaxMessageSize    = 25 << 20
+	defaultMaxAttachmentSize = 10 << 20
+
+	// maxLineLength is the line length RFC 5322 recommends for headers and
+	// RFC 2045 requires for base64 bodies.
+	maxLineLength = 76
+)
+
+var ErrTooLarge = errors.New("message exceeds the maximum size")
+
+// Email is the content of a message before composition.
+type Email struct {
+	To      []string
+	Subject string
+	// HTML and Text are the two renderings of the body. When only HTML is set
+	// the text rendering is generated from it.
+	HTML        string
+	Text        string
+	Attachments []*model.Attachment
+	// UnsubscribeURL, when set, is announced in the List-Unsubscribe header
+	// together with RFC 8058 one-click support.
+	UnsubscribeURL string
+}
+
+// FromMail builds the email for a stored mail. The body is treated as HTML when
+// the content type says so and as plain text otherwise.
+func FromMail(m *model.Mail) *Email {
+	email := &Email{To: m.To, Subject: m.Subject}
+
+	if mediaType(m.ContentType) == model.ContentTypeHTML {
+		email.HTML = m.Body
+	} else {
+		email.Text = m.Body
+	}
+
+	return email
+}
+
+// ValidContentType reports whether a mail body may have the content type. An
+// empty content type means plain text.
+func ValidContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mt := mediaType(contentType)
+	return mt == model.ContentTypeText || mt == model.ContentTypeHTML
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return mt
+}
+
+// Composer turns emails into RFC 5322 messages with RFC 2045 MIME bodies.
+type Composer struct {
+	from              string
+	maxMessageSize    int64
+	maxAttachmentSize int64
+}
+
+func NewComposer(cfg *config.Config) *Composer {
+	c := &Composer{
+		from:              cfg.SMTP.From,
+		maxMessageSize:    cfg.Composer.MaxMessageSize,
+		maxAttachmentSize: cfg.Composer.MaxAttachmentSize,
+	}
+
+	if c.maxMessageSize <= 0 {
+		c.maxMessageSize = defaultMaxMessageSize
+	}
+	if c.maxAttachmentSize <= 0 {
+		c.maxAttachmentSize = defaultMaxAttachmentSize
+	}
+
+	return c
+}
+
+func (c *Composer) MaxAttachmentSize() int64 {
+	return c.maxAttachmentSize
+}
+
+// FitsAttachments reports whether attachments of the given total size still
+// leave the message under the size limit once encoded.
+func (c *Composer) FitsAttachments(total int64) bool {
+	return EncodedSize(total) <= c.maxMessageSize
+}
+
+// EncodedSize is the size of n bytes once base64 encoded and wrapped.
+func EncodedSize(n int64) int64 {
+	encoded := (n + 2) / 3 * 4
+	return encoded + encoded/maxLineLength*2
+}
+
+// Compose builds the message for the envelope recipients in email.To. Messages
+// larger than the configured limit yield ErrTooLarge.
+//
+// The body is structured as
+//
+//	multipart/mixed               when there are attachments
+//	  multipart/related           when the HTML has inline images
+//	    multipart/alternative     when there is an HTML body
+//	      text/plain
+//	      text/html
+//	    inline images
+//	  attachments
+//
+// with every level left out when it would hold a single part.
+func (c *Composer) Compose(email *Email) (*sender.Message, error) {
+	messageID, err := newMessageID(c.from)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.body(email)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", formatAddress(c.from))
+	writeHeader(&buf, "To", formatAddressList(email.To))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", email.Subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", messageID)
+	if email.UnsubscribeURL != "" {
+		writeHeader(&buf, "List-Unsubscribe", "<"+email.UnsubscribeURL+">")
+		writeHeader(&buf, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+	for _, key := range sortedKeys(body.header) {
+		writeHeader(&buf, key, body.header.Get(key))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.data)
+
+	if int64(buf.Len()) > c.maxMessageSize {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrTooLarge, buf.Len(), c.maxMessageSize)
+	}
+
+	return &sender.Message{
+		From:      c.from,
+		To:        email.To,
+		MessageID: messageID,
+		Data:      buf.Bytes(),
+	}, nil
+}
+
+// entity is a MIME entity: the Content-* headers and the encoded body.
+type entity struct {
+	header textproto.MIMEHeader
+	data   []byte
+}
+
+func (c *Composer) body(email *Email) (*entity, error) {
+	var inline, attached []*model.Attachment
+	for _, attachment := range email.Attachments {
+		if int64(len(attachment.Data)) > c.maxAttachmentSize {
+			return nil, fmt.Errorf("%w: attachment %q", ErrTooLarge, attachment.Filename)
+		}
+
+		if attachment.ContentID != "" && email.HTML != "" {
+			inline = append(inline, attachment)
+		} else {
+			attached = append(attached, attachment)
+		}
+	}
+
+	text := email.Text
+	if text == "" && email.HTML != "" {
+		text = HTMLToText(email.HTML)
+	}
+
+	body, err := textEntity(model.ContentTypeText, text)
+	if err != nil {
+		return nil, err
+	}
+
+	if email.HTML != "" {
+		html, err := textEntity(model.ContentTypeHTML, email.HTML)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err = multipartEntity("alternative", []*entity{body, html})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(inline) > 0 {
+		parts := []*entity{body}
+		for _, attachment := range inline {
+			parts = append(parts, attachmentEntity(attachment, "inline"))
+		}
+
+		body, err = multipartEntity("related", parts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(attached) > 0 {
+		parts := []*entity{body}
+		for _, attachment := range attached {
+			parts = append(parts, attachmentEntity(attachment, "attachment"))
+		}
+
+		body, err = multipartEntity("mixed", parts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
+
+func textEntity(mediaType, text string) (*entity, error) {
+	var buf bytes.Buffer
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "UTF-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	return &entity{header: header, data: buf.Bytes()}, nil
+}
+
+func attachmentEntity(attachment *model.Attachment, disposition string) *entity {
+	contentType := attachment.ContentType
+	if mediaType(contentType) == "" {
+		contentType = "application/octet-stream"
+	}
+
+	params := map[string]string{}
+	if attachment.Filename != "" {
+		params["filename"] = attachment.Filename
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, params))
+	if disposition == "inline" {
+		header.Set("Content-ID", "<"+attachment.ContentID+">")
+	}
+
+	return &entity{header: header, data: wrapBase64(attachment.Data)}
+}
+
+func multipartEntity(subtype string, parts []*entity) (*entity, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, part := range parts {
+		pw, err := w.CreatePart(part.header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write(part.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "multipart/"+subtype+"; boundary="+w.Boundary())
+
+	return &entity{header: header, data: buf.Bytes()}, nil
+}
+
+func wrapBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var buf bytes.Buffer
+	for len(encoded) > maxLineLength {
+		buf.WriteString(encoded[:maxLineLength])
+		buf.WriteString("\r\n")
+		encoded = encoded[maxLineLength:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
+
+// writeHeader writes a header field, folding it at spaces so that lines stay
+// within the recommended length.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	line := key + ":"
+	for _, word := range strings.Fields(value) {
+		if len(line)+1+len(word) > maxLineLength && strings.Contains(line, " ") {
+			buf.WriteString(line)
+			buf.WriteString("\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+// formatAddress encodes the display name of an address when it is not plain
+// ASCII. Addresses that do not parse are written unchanged.
+func formatAddress(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+
+	return parsed.String()
+}
+
+func formatAddressList(addresses []string) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, formatAddress(address))
+	}
+
+	return strings.Join(formatted, ", ")
+}
+
+func sortedKeys(header textproto.MIMEHeader) []string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = strings.Trim(from[i+1:], "> ")
+	}
+
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain), nil
+}
@@ -0,0 +1,409 @@
+package composer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"testing"
+)
+
+func newTestComposer(maxMessageSize, maxAttachmentSize int64) *Composer {
+	cfg := &config.Config{}
+	cfg.SMTP.From = "News <news@example.com>"
+	cfg.Composer.MaxMessageSize = maxMessageSize
+	cfg.Composer.MaxAttachmentSize = maxAttachmentSize
+
+	return NewComposer(cfg)
+}
+
+// part is a decoded MIME entity of a composed message.
+type part struct {
+	mediaType string
+	header    map[string][]string
+	body      string
+	parts     []*part
+}
+
+// structure renders the MIME tree as e.g. "multipart/alternative(text/plain,text/html)".
+func (p *part) structure() string {
+	if len(p.parts) == 0 {
+		return p.mediaType
+	}
+
+	children := make([]string, 0, len(p.parts))
+	for _, child := range p.parts {
+		children = append(children, child.structure())
+	}
+
+	return p.mediaType + "(" + strings.Join(children, ",") + ")"
+}
+
+// find returns the first part of the given media type, depth first.
+func (p *part) find(mediaType string) *part {
+	if p.mediaType == mediaType {
+		return p
+	}
+	for _, child := range p.parts {
+		if found := child.find(mediaType); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func parsePart(t *testing.T, header map[string][]string, body io.Reader) *part {
+	t.Helper()
+
+	get := func(key string) string {
+		if values := header[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	mediaType, params, err := mime.ParseMediaType(get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type %q: %v", get("Content-Type"), err)
+	}
+
+	p := &part{mediaType: mediaType, header: header}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			child, err := r.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading %s: %v", mediaType, err)
+			}
+			p.parts = append(p.parts, parsePart(t, child.Header, child))
+		}
+		return p
+	}
+
+	switch get("Content-Transfer-Encoding") {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", mediaType, err)
+	}
+	p.body = string(data)
+
+	return p
+}
+
+func compose(t *testing.T, c *Composer, email *Email) (*mail.Message, *part) {
+	t.Helper()
+
+	msg, err := c.Compose(email)
+	if err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+
+	return parsed, parsePart(t, parsed.Header, parsed.Body)
+}
+
+func TestComposeStructure(t *testing.T) {
+	pdf := &model.Attachment{Filename: "report.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}
+	logo := &model.Attachment{Filename: "logo.png", ContentType: "image/png", ContentID: "logo", Data: []byte{0x89, 'P', 'N', 'G'}}
+	unknown := &model.Attachment{Filename: "data.bin", ContentType: "not a type", Data: []byte{1, 2, 3}}
+
+	tests := []struct {
+		name  string
+		email *Email
+		want  string
+	}{
+		{
+			name:  "text only",
+			email: &Email{Text: "Hello"},
+			want:  "text/plain",
+		},
+		{
+			name:  "html only",
+			email: &Email{HTML: "<p>Hello</p>"},
+			want:  "multipart/alternative(text/plain,text/html)",
+		},
+		{
+			name:  "text with attachment",
+			email: &Email{Text: "Hello", Attachments: []*model.Attachment{pdf}},
+			want:  "multipart/mixed(text/plain,application/pdf)",
+		},
+		{
+			name:  "inline image without html is attached",
+			email: &Email{Text: "Hello", Attachments: []*model.Attachment{logo}},
+			want:  "multipart/mixed(text/plain,image/png)",
+		},
+		{
+			name:  "html with inline image",
+			email: &Email{HTML: `<img src="cid:logo">`, Attachments: []*model.Attachment{logo}},
+			want:  "multipart/related(multipart/alternative(text/plain,text/html),image/png)",
+		},
+		{
+			name:  "html with inline image and attachment",
+			email: &Email{HTML: `<img src="cid:logo">`, Attachments: []*model.Attachment{logo, pdf}},
+			want:  "multipart/mixed(multipart/related(multipart/alternative(text/plain,text/html),image/png),application/pdf)",
+		},
+		{
+			name:  "unknown attachment type",
+			email: &Email{Text: "Hello", Attachments: []*model.Attachment{unknown}},
+			want:  "multipart/mixed(text/plain,application/octet-stream)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.email.To = []string{"alice@example.com"}
+			_, root := compose(t, newTestComposer(0, 0), tt.email)
+
+			if got := root.structure(); got != tt.want {
+				t.Errorf("structure = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComposeContent(t *testing.T) {
+	logo := &model.Attachment{Filename: "logo.png", ContentType: "image/png", ContentID: "logo", Data: bytes.Repeat([]byte{0xff}, 200)}
+	email := &Email{
+		To:             []string{"Zoë <zoe@example.com>"},
+		Subject:        "Grüße aus Köln",
+		HTML:           `<p>Hallo Zoë,</p><p><a href="https://example.com/news">Neuigkeiten</a></p><img src="cid:logo">`,
+		Attachments:    []*model.Attachment{logo},
+		UnsubscribeURL: "https://example.com/unsubscribe/abc",
+	}
+
+	msg, root := compose(t, newTestComposer(0, 0), email)
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != email.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, email.Subject)
+	}
+
+	to, err := msg.Header.AddressList("To")
+	if err != nil || len(to) != 1 || to[0].Name != "Zoë" || to[0].Address != "zoe@example.com" {
+		t.Errorf("To = %v (%v), want Zoë <zoe@example.com>", to, err)
+	}
+
+	if got := msg.Header.Get("List-Unsubscribe"); got != "<https://example.com/unsubscribe/abc>" {
+		t.Errorf("List-Unsubscribe = %q", got)
+	}
+	if got := msg.Header.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q", got)
+	}
+	if got := msg.Header.Get("Message-Id"); !strings.HasSuffix(got, "@example.com>") {
+		t.Errorf("Message-ID = %q, want the sender's domain", got)
+	}
+	if got := msg.Header.Get("MIME-Version"); got != "1.0" {
+		t.Errorf("MIME-Version = %q", got)
+	}
+
+	text := root.find("text/plain")
+	if text == nil || !strings.Contains(text.body, "Hallo Zoë,") || !strings.Contains(text.body, "Neuigkeiten (https://example.com/news)") {
+		t.Errorf("text/plain part = %+v, want the generated text rendering", text)
+	}
+
+	html := root.find("text/html")
+	if html == nil || html.body != email.HTML {
+		t.Errorf("text/html part = %+v, want the HTML body", html)
+	}
+
+	image := root.find("image/png")
+	if image == nil {
+		t.Fatal("missing image/png part")
+	}
+	if image.body != string(logo.Data) {
+		t.Errorf("image/png part does not round-trip")
+	}
+	if got := image.header["Content-Id"]; len(got) != 1 || got[0] != "<logo>" {
+		t.Errorf("Content-ID = %q, want <logo>", got)
+	}
+	if got := image.header["Content-Disposition"]; len(got) != 1 || !strings.HasPrefix(got[0], "inline") {
+		t.Errorf("Content-Disposition = %q, want inline", got)
+	}
+}
+
+func TestComposeLineLength(t *testing.T) {
+	email := &Email{
+		To:          []string{"alice@example.com"},
+		Subject:     strings.Repeat("a very long subject ", 20),
+		Text:        strings.Repeat("x", 500),
+		Attachments: []*model.Attachment{{Filename: "big.bin", Data: bytes.Repeat([]byte{7}, 1000)}},
+	}
+
+	msg, err := newTestComposer(0, 0).Compose(email)
+	if err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+
+	for i, line := range strings.Split(string(msg.Data), "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("line %d is %d bytes long", i+1, len(line))
+		}
+		if len(line) > maxLineLength+2 && !strings.Contains(line, " ") {
+			t.Errorf("line %d is %d bytes long: %.40q...", i+1, len(line), line)
+		}
+	}
+}
+
+func TestComposeTooLarge(t *testing.T) {
+	tests := []struct {
+		name              string
+		maxMessageSize    int64
+		maxAttachmentSize int64
+		email             *Email
+		wantErr           bool
+	}{
+		{
+			name:           "within limits",
+			maxMessageSize: 4096,
+			email:          &Email{Text: "Hello"},
+		},
+		{
+			name:           "message over limit",
+			maxMessageSize: 1024,
+			email:          &Email{Text: strings.Repeat("x", 2048)},
+			wantErr:        true,
+		},
+		{
+			name:              "attachment over limit",
+			maxAttachmentSize: 10,
+			email:             &Email{Text: "Hello", Attachments: []*model.Attachment{{Filename: "a.bin", Data: make([]byte, 11)}}},
+			wantErr:           true,
+		},
+		{
+			name:              "attachment at limit",
+			maxAttachmentSize: 10,
+			email:             &Email{Text: "Hello", Attachments: []*model.Attachment{{Filename: "a.bin", Data: make([]byte, 10)}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.email.To = []string{"alice@example.com"}
+			_, err := newTestComposer(tt.maxMessageSize, tt.maxAttachmentSize).Compose(tt.email)
+			if tt.wantErr != errors.Is(err, ErrTooLarge) {
+				t.Errorf("Compose() error = %v, want ErrTooLarge %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Compose() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want int64
+	}{
+		{n: 0, want: 0},
+		{n: 1, want: 4},
+		{n: 3, want: 4},
+		{n: 57, want: 78},
+		{n: 114, want: 156},
+	}
+
+	for _, tt := range tests {
+		if got := EncodedSize(tt.n); got != tt.want {
+			t.Errorf("EncodedSize(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+		if got := int64(len(wrapBase64(make([]byte, tt.n)))); tt.n > 0 && got > EncodedSize(tt.n)+2 {
+			t.Errorf("wrapBase64(%d bytes) is %d bytes, more than EncodedSize allows", tt.n, got)
+		}
+	}
+}
+
+func TestFromMail(t *testing.T) {
+	tests := []struct {
+		contentType string
+		wantHTML    bool
+	}{
+		{contentType: "", wantHTML: false},
+		{contentType: "text/plain", wantHTML: false},
+		{contentType: "text/html", wantHTML: true},
+		{contentType: "text/html; charset=utf-8", wantHTML: true},
+		{contentType: "garbage;;", wantHTML: false},
+	}
+
+	for _, tt := range tests {
+		email := FromMail(&model.Mail{To: []string{"a@example.com"}, Subject: "Hi", Body: "body", ContentType: tt.contentType})
+
+		wantHTML, wantText := "", "body"
+		if tt.wantHTML {
+			wantHTML, wantText = "body", ""
+		}
+		if email.HTML != wantHTML || email.Text != wantText {
+			t.Errorf("FromMail(%q) = HTML %q, Text %q, want HTML %q, Text %q", tt.contentType, email.HTML, email.Text, wantHTML, wantText)
+		}
+	}
+}
+
+func TestValidContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{contentType: "", want: true},
+		{contentType: "text/plain", want: true},
+		{contentType: "text/html; charset=UTF-8", want: true},
+		{contentType: "TEXT/HTML", want: true},
+		{contentType: "application/json", want: false},
+		{contentType: "text/", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidContentType(tt.contentType); got != tt.want {
+			t.Errorf("ValidContentType(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{name: "plain", html: "Hello", want: "Hello"},
+		{name: "whitespace collapsed", html: "Hello \n\t  world", want: "Hello world"},
+		{name: "entities", html: "Tom &amp; Jerry &lt;3", want: "Tom & Jerry <3"},
+		{name: "paragraphs", html: "<p>One</p><p>Two</p>", want: "One\n\nTwo"},
+		{name: "line breaks", html: "One<br>Two<br/>Three", want: "One\nTwo\nThree"},
+		{name: "list", html: "<ul><li>One</li><li>Two</li></ul>", want: "* One\n* Two"},
+		{name: "link", html: `<a href="https://example.com">Site</a>`, want: "Site (https://example.com)"},
+		{name: "anchor link", html: `<a href="#top">Top</a>`, want: "Top"},
+		{name: "mailto link", html: `<a href="mailto:a@example.com">Mail</a>`, want: "Mail"},
+		{name: "skipped tags", html: "<head><title>T</title><style>p{}</style></head><body>Body<script>x()</script></body>", want: "Body"},
+		{name: "comment", html: "A<!-- hidden -->B", want: "AB"},
+		{name: "heading", html: "<h1>Title</h1>Text", want: "Title\n\nText"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTMLToText(tt.html); got != tt.want {
+				t.Errorf("HTMLToText(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package composer
+
+import (
+	"html"
+	"strings"
+)
+
+// blockTags start a new line in the text rendering.
+var blockTags = map[string]bool{
+	"address": true, "article": true, "br": true, "div": true, "footer": true,
+	"header": true, "hr": true, "li": true, "section": true, "tr": true,
+}
+
+// paragraphTags are separated from the surrounding text by a blank line.
+var paragraphTags = map[string]bool{
+	"blockquote": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "ol": true, "p": true, "table": true, "ul": true,
+}
+
+// skippedTags have content that is not meant to be read.
+var skippedTags = map[string]bool{"head": true, "script": true, "style": true, "title": true}
+
+// HTMLToText renders an HTML body as plain text: tags are dropped, block
+// elements start new lines, paragraphs are set apart by blank lines, list
+// items get a bullet, links keep their target after the link text, and
+// entities are decoded.
+func HTMLToText(s string) string {
+	var out strings.Builder
+	var pendingHref string
+	skip := ""
+
+	for len(s) > 0 {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			start = len(s)
+		}
+
+		if skip == "" {
+			writeText(&out, s[:start])
+		}
+		s = s[start:]
+		if s == "" {
+			break
+		}
+
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				break
+			}
+			s = s[end+3:]
+			continue
+		}
+
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			break
+		}
+		tag := s[1:end]
+		s = s[end+1:]
+
+		name, closing := tagName(tag)
+		if skip != "" {
+			if closing && name == skip {
+				skip = ""
+			}
+			continue
+		}
+
+		switch {
+		case skippedTags[name] && !closing:
+			skip = name
+		case name == "a" && !closing:
+			pendingHref = attribute(tag, "href")
+		case name == "a" && closing:
+			if pendingHref != "" && !strings.HasPrefix(pendingHref, "#") && !strings.HasPrefix(pendingHref, "mailto:") {
+				out.WriteString(" (" + pendingHref + ")")
+			}
+			pendingHref = ""
+		case name == "li" && !closing:
+			newLine(&out)
+			out.WriteString("* ")
+		case paragraphTags[name]:
+			newLine(&out)
+			out.WriteString("\n")
+		case blockTags[name]:
+			newLine(&out)
+		}
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	var result []string
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			blank = len(result) > 0
+			continue
+		}
+		if blank {
+			result = append(result, "")
+			blank = false
+		}
+		result = append(result, line)
+	}
+
+	return strings.Join(result, "\n")
+}
+
+// writeText appends text with runs of whitespace collapsed, as a browser would
+// display them.
+func writeText(out *strings.Builder, text string) {
+	text = html.UnescapeString(text)
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		if text != "" {
+			out.WriteString(" ")
+		}
+		return
+	}
+
+	if startsWithSpace(text) {
+		out.WriteString(" ")
+	}
+	out.WriteString(strings.Join(fields, " "))
+	if endsWithSpace(text) {
+		out.WriteString(" ")
+	}
+}
+
+// newLine ends the current line unless the output is already at the start of
+// one.
+func newLine(out *strings.Builder) {
+	if s := out.String(); s != "" && !strings.HasSuffix(strings.TrimRight(s, " "), "\n") {
+		out.WriteString("\n")
+	}
+}
+
+func startsWithSpace(s string) bool {
+	return strings.TrimLeft(s, " \t\r\n") != s
+}
+
+func endsWithSpace(s string) bool {
+	return strings.TrimRight(s, " \t\r\n") != s
+}
+
+func tagName(tag string) (string, bool) {
+	closing := strings.HasPrefix(tag, "/")
+	tag = strings.TrimPrefix(tag, "/")
+
+	end := strings.IndexAny(tag, " \t\r\n/")
+	if end >= 0 {
+		tag = tag[:end]
+	}
+
+	return strings.ToLower(tag), closing
+}
+
+func attribute(tag, name string) string {
+	lower := strings.ToLower(tag)
+	i := strings.Index(lower, name+"=")
+	if i < 0 {
+		return ""
+	}
+
+	value := tag[i+len(name)+1:]
+	if value == "" {
+		return ""
+	}
+
+	if quote := value[0]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(value[1:], quote)
+		if end < 0 {
+			return ""
+		}
+		return html.UnescapeString(value[1 : end+1])
+	}
+
+	end := strings.IndexAny(value, " \t\r\n")
+	if end >= 0 {
+		value = value[:end]
+	}
+
+	return html.UnescapeString(value)
+}
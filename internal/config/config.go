@@ -5,11 +5,23 @@ import (
 	"gopkg.in/yaml.v3"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// LevelThresholds is what a subscriber needs to reach a level.
+type LevelThresholds struct {
+	NumberSubscriptions int           `yaml:"number_subscriptions"`
+	SubscriptionsInRow  int           `yaml:"subscriptions_in_row"`
+	Tenure              time.Duration `yaml:"tenure"`
+}
+
 type Config struct {
 	Server struct {
 		Port string `yaml:"port"`
+		// TrustedProxies lists the proxies (addresses or CIDRs) whose
+		// X-Forwarded-For and X-Real-IP headers are believed. Without any,
+		// the client address is the address of the connection.
+		TrustedProxies []string `yaml:"trusted_proxies"`
 	} `yaml:"server"`
 
 	Database struct {
@@ -20,6 +32,126 @@ type Config struct {
 		DBName   string `yaml:"dbname"`
 		SSLMode  string `yaml:"sslmode"`
 	} `yaml:"database"`
+
+	SMTP struct {
+		Host     string `yaml:"host"`
+		Port     string `yaml:"port"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		From     string `yaml:"from"`
+		// Encryption is one of "none", "starttls" or "tls" (implicit TLS).
+		Encryption string `yaml:"encryption"`
+		// Auth is one of "none", "plain" or "login".
+		Auth    string        `yaml:"auth"`
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"smtp"`
+
+	Delivery struct {
+		Workers      int           `yaml:"workers"`
+		PollInterval time.Duration `yaml:"poll_interval"`
+		LockTimeout  time.Duration `yaml:"lock_timeout"`
+		MaxAttempts  int           `yaml:"max_attempts"`
+		RetryInitial time.Duration `yaml:"retry_initial"`
+		RetryMax     time.Duration `yaml:"retry_max"`
+		// RetryJitter is the fraction (0..1) by which a retry delay is randomized.
+		RetryJitter float64 `yaml:"retry_jitter"`
+	} `yaml:"delivery"`
+
+	Composer struct {
+		// MaxMessageSize is the largest composed message in bytes, encoded
+		// attachments included.
+		MaxMessageSize    int64 `yaml:"max_message_size"`
+		MaxAttachmentSize int64 `yaml:"max_attachment_size"`
+	} `yaml:"composer"`
+
+	Scheduler struct {
+		PollInterval time.Duration `yaml:"poll_interval"`
+	} `yaml:"scheduler"`
+
+	Tokens struct {
+		// Secret signs the links sent to subscribers. It is required, at
+		// least 32 bytes long, and may be given in TOKENS_SECRET instead.
+		Secret string `yaml:"secret"`
+	} `yaml:"tokens"`
+
+	Confirmation struct {
+		// URL is the confirmation endpoint as reachable by subscribers; the
+		// token is appended as the "token" query parameter.
+		URL string `yaml:"url"`
+		// Window is how long a pending subscription waits for confirmation
+		// before it expires.
+		Window        time.Duration `yaml:"window"`
+		CheckInterval time.Duration `yaml:"check_interval"`
+	} `yaml:"confirmation"`
+
+	Unsubscribe struct {
+		// URL is the unsubscribe endpoint as reachable by recipients; the
+		// token is appended as the "token" query parameter.
+		URL string `yaml:"url"`
+		// TTL is how long an unsubscribe link keeps working after the mail
+		// was sent.
+		TTL time.Duration `yaml:"ttl"`
+	} `yaml:"unsubscribe"`
+
+	Levels struct {
+		EvaluateInterval time.Duration `yaml:"evaluate_interval"`
+		// Rules holds the thresholds per level above newbie.
+		Rules map[string]LevelThresholds `yaml:"rules"`
+	} `yaml:"levels"`
+
+	Preferences struct {
+		// URL is the preference center as reachable by users; the token is
+		// appended as the "token" query parameter.
+		URL string `yaml:"url"`
+		// TTL is how long a preference center link keeps working after the
+		// mail was sent.
+		TTL time.Duration `yaml:"ttl"`
+	} `yaml:"preferences"`
+
+	Auth struct {
+		// Secret signs access tokens. It is required, at least 32 bytes
+		// long, and may be given in AUTH_SECRET instead.
+		Secret     string        `yaml:"secret"`
+		AccessTTL  time.Duration `yaml:"access_ttl"`
+		RefreshTTL time.Duration `yaml:"refresh_ttl"`
+		// Admin is created at startup when there is no admin yet and a
+		// password is set, preferably through ADMIN_PASSWORD.
+		Admin struct {
+			Login    string `yaml:"login"`
+			Email    string `yaml:"email"`
+			Password string `yaml:"password"`
+		} `yaml:"admin"`
+	} `yaml:"auth"`
+
+	PasswordReset struct {
+		// URL is the reset page as reachable by users; the token is appended
+		// as the "token" query parameter.
+		URL string        `yaml:"url"`
+		TTL time.Duration `yaml:"ttl"`
+		// MaxRequests is how many reset mails one address gets per Window.
+		MaxRequests int           `yaml:"max_requests"`
+		Window      time.Duration `yaml:"window"`
+	} `yaml:"password_reset"`
+
+	Users struct {
+		// DeletePolicy decides what happens to the subscriptions of a deleted
+		// user: "block" refuses the deletion, "cascade" deletes them with the
+		// user and "anonymize" ends them and keeps the user without personal
+		// data.
+		DeletePolicy string `yaml:"delete_policy"`
+	} `yaml:"users"`
+
+	Renewal struct {
+		// PeriodDays is the subscription period of subscriptions that do not
+		// set their own.
+		PeriodDays int `yaml:"period_days"`
+		// GraceDays is how long after expiry a renewal still continues the
+		// streak of consecutive subscriptions.
+		GraceDays int `yaml:"grace_days"`
+		// ReminderDays is how many days before expiry the reminder is sent.
+		ReminderDays  int           `yaml:"reminder_days"`
+		CheckInterval time.Duration `yaml:"check_interval"`
+	} `yaml:"renewal"`
 }
 
 func LoadConfig(configPath string) (*Config, error) {
@@ -39,5 +171,21 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("error parsing config file: %w", err)
 	}
 
+	applyEnv(config)
+
 	return config, nil
 }
+
+// applyEnv overrides secrets from the environment, so that they do not have to
+// be kept in the config file.
+func applyEnv(config *Config) {
+	if secret := os.Getenv("TOKENS_SECRET"); secret != "" {
+		config.Tokens.Secret = secret
+	}
+	if secret := os.Getenv("AUTH_SECRET"); secret != "" {
+		config.Auth.Secret = secret
+	}
+	if password := os.Getenv("ADMIN_PASSWORD"); password != "" {
+		config.Auth.Admin.Password = password
+	}
+}
@@ -0,0 +1,148 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "* * * * *"},
+		{expr: "0 9 * * 1-5"},
+		{expr: "*/15 0-6,18-23 1,15 jan-mar,dec ?"},
+		{expr: "30 8 * * MON,wed,Fri"},
+		{expr: "0 0 * * 7"},
+		{expr: "5/10 * * * *"},
+		{expr: "  @daily  "},
+		{expr: "@Weekly"},
+		{expr: "", wantErr: true},
+		{expr: "* * * *", wantErr: true},
+		{expr: "* * * * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "* 24 * * *", wantErr: true},
+		{expr: "* * 0 * *", wantErr: true},
+		{expr: "* * 32 * *", wantErr: true},
+		{expr: "* * * 13 *", wantErr: true},
+		{expr: "* * * * 8", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "*/x * * * *", wantErr: true},
+		{expr: "10-5 * * * *", wantErr: true},
+		{expr: "* * * foo *", wantErr: true},
+		{expr: "@every 5m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		for _, layout := range []string{"2006-01-02 15:04", "2006-01-02 15:04:05"} {
+			if v, err := time.Parse(layout, s); err == nil {
+				return v
+			}
+		}
+		t.Fatalf("invalid time %q", s)
+		return time.Time{}
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		after string
+		want  string
+	}{
+		{name: "every minute", expr: "* * * * *", after: "2026-03-10 12:00", want: "2026-03-10 12:01"},
+		{name: "seconds are dropped", expr: "* * * * *", after: "2026-03-10 12:00:30", want: "2026-03-10 12:01"},
+		{name: "strictly after", expr: "0 9 * * *", after: "2026-03-10 09:00", want: "2026-03-11 09:00"},
+		{name: "later today", expr: "0 9 * * *", after: "2026-03-10 08:59", want: "2026-03-10 09:00"},
+		{name: "step", expr: "*/15 * * * *", after: "2026-03-10 12:16", want: "2026-03-10 12:30"},
+		{name: "step from value", expr: "5/20 * * * *", after: "2026-03-10 12:26", want: "2026-03-10 12:45"},
+		{name: "hour rollover", expr: "0 * * * *", after: "2026-03-10 23:30", want: "2026-03-11 00:00"},
+		{name: "weekdays skip weekend", expr: "0 9 * * 1-5", after: "2026-03-13 10:00", want: "2026-03-16 09:00"},
+		{name: "sunday as 7", expr: "0 0 * * 7", after: "2026-03-10 00:00", want: "2026-03-15 00:00"},
+		{name: "named month", expr: "0 0 1 jun *", after: "2026-03-10 00:00", want: "2026-06-01 00:00"},
+		{name: "year rollover", expr: "@yearly", after: "2026-03-10 00:00", want: "2027-01-01 00:00"},
+		{name: "month end skips short months", expr: "0 0 31 * *", after: "2026-04-01 00:00", want: "2026-05-31 00:00"},
+		{name: "leap day", expr: "0 0 29 2 *", after: "2026-03-01 00:00", want: "2028-02-29 00:00"},
+		{name: "day of month or day of week", expr: "0 0 13 * 5", after: "2026-03-01 00:00", want: "2026-03-06 00:00"},
+		{name: "day of month and starred day of week", expr: "0 0 13 * *", after: "2026-03-01 00:00", want: "2026-03-13 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+
+			after := utc(tt.after)
+			if got, want := s.Next(after), utc(tt.want); !got.Equal(want) {
+				t.Errorf("Next(%v) = %v, want %v", after, got, want)
+			}
+		})
+	}
+}
+
+func TestNextNeverMatches(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if got := s.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next() = %v, want the zero time", got)
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	s, err := Parse("30 2 * * *")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		after time.Time
+		want  time.Time
+	}{
+		{
+			name:  "regular day",
+			after: time.Date(2026, 3, 20, 12, 0, 0, 0, loc),
+			want:  time.Date(2026, 3, 21, 2, 30, 0, 0, loc),
+		},
+		{
+			// 02:30 does not exist on the day clocks go forward.
+			name:  "spring forward",
+			after: time.Date(2026, 3, 28, 12, 0, 0, 0, loc),
+			want:  time.Date(2026, 3, 30, 2, 30, 0, 0, loc),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Next(tt.after)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, got, tt.want)
+			}
+			if got.Location() != loc {
+				t.Errorf("Next() location = %v, want %v", got.Location(), loc)
+			}
+		})
+	}
+}
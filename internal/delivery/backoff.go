@@ -0,0 +1,39 @@
+package delivery
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Jitter  float64
+}
+
+// Delay returns how long to wait before the given retry attempt (starting at 1):
+// Initial doubled for every previous attempt, capped at Max and spread by
+// ±Jitter so that failed batches do not retry in lockstep.
+func (b Backoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	d := b.Max
+	if shift := attempt - 1; shift < 63 {
+		if next := b.Initial << shift; next > 0 && next < b.Max {
+			d = next
+		}
+	}
+
+	if b.Jitter > 0 {
+		delta := float64(d) * b.Jitter
+		d = time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+	}
+
+	if d < 0 {
+		d = 0
+	}
+
+	return d
+}
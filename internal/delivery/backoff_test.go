@@ -0,0 +1,84 @@
+package delivery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 30 * time.Second, Max: 6 * time.Hour}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: -1, want: 30 * time.Second},
+		{attempt: 0, want: 30 * time.Second},
+		{attempt: 1, want: 30 * time.Second},
+		{attempt: 2, want: time.Minute},
+		{attempt: 3, want: 2 * time.Minute},
+		{attempt: 8, want: 64 * time.Minute},
+		{attempt: 10, want: 256 * time.Minute},
+		{attempt: 11, want: 6 * time.Hour},
+		{attempt: 40, want: 6 * time.Hour},
+		{attempt: 63, want: 6 * time.Hour},
+		{attempt: 64, want: 6 * time.Hour},
+		{attempt: 1000, want: 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := b.Delay(tt.attempt); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffDelayJitter(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{
+			name:    "first attempt",
+			backoff: Backoff{Initial: time.Minute, Max: time.Hour, Jitter: 0.2},
+			attempt: 1,
+			min:     48 * time.Second,
+			max:     72 * time.Second,
+		},
+		{
+			name:    "capped",
+			backoff: Backoff{Initial: time.Minute, Max: time.Hour, Jitter: 0.5},
+			attempt: 20,
+			min:     30 * time.Minute,
+			max:     90 * time.Minute,
+		},
+		{
+			name:    "full jitter",
+			backoff: Backoff{Initial: time.Minute, Max: time.Hour, Jitter: 1},
+			attempt: 1,
+			min:     0,
+			max:     2 * time.Minute,
+		},
+		{
+			name:    "never negative",
+			backoff: Backoff{Initial: time.Minute, Max: time.Hour, Jitter: 3},
+			attempt: 1,
+			min:     0,
+			max:     4 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				got := tt.backoff.Delay(tt.attempt)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("Delay(%d) = %v, want within [%v, %v]", tt.attempt, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,516 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"subscription-mailing-service/internal/composer"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/preferences"
+	"subscription-mailing-service/internal/sender"
+	"subscription-mailing-service/internal/templating"
+	"subscription-mailing-service/internal/unsubscribe"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/mail"
+	"subscription-mailing-service/storage/outbox"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers      = 4
+	defaultPollInterval = time.Second
+	defaultLockTimeout  = 5 * time.Minute
+	defaultMaxAttempts  = 8
+	defaultRetryInitial = 30 * time.Second
+	defaultRetryMax     = 6 * time.Hour
+	sendTimeout         = 2 * time.Minute
+	outcomeUnknown      = "delivery outcome unknown: the worker sending it lost the job"
+)
+
+// Pool runs a fixed number of workers that drain the mail outbox.
+type Pool struct {
+	store        *outbox.OutboxStorage
+	mails        *mail.MailStorage
+	renderer     *templating.Renderer
+	sender       sender.Sender
+	composer     *composer.Composer
+	unsubscriber *unsubscribe.Unsubscriber
+	preferences  *preferences.Center
+	workers      int
+	pollInterval time.Duration
+	lockTimeout  time.Duration
+	maxAttempts  int
+	backoff      Backoff
+	logger       *slog.Logger
+}
+
+func NewPool(
+	store *outbox.OutboxStorage,
+	mails *mail.MailStorage,
+	renderer *templating.Renderer,
+	sender sender.Sender,
+	composer *composer.Composer,
+	unsubscriber *unsubscribe.Unsubscriber,
+	preferences *preferences.Center,
+	cfg *config.Config,
+	logger *slog.Logger,
+) *Pool {
+	p := &Pool{
+		store:        store,
+		mails:        mails,
+		renderer:     renderer,
+		sender:       sender,
+		composer:     composer,
+		unsubscriber: unsubscriber,
+		preferences:  preferences,
+		workers:      cfg.Delivery.Workers,
+		pollInterval: cfg.Delivery.PollInterval,
+		lockTimeout:  cfg.Delivery.LockTimeout,
+		maxAttempts:  cfg.Delivery.MaxAttempts,
+		backoff: Backoff{
+			Initial: cfg.Delivery.RetryInitial,
+			Max:     cfg.Delivery.RetryMax,
+			Jitter:  cfg.Delivery.RetryJitter,
+		},
+		logger: logger,
+	}
+
+	if p.workers <= 0 {
+		p.workers = defaultWorkers
+	}
+	if p.pollInterval <= 0 {
+		p.pollInterval = defaultPollInterval
+	}
+	if p.lockTimeout <= 0 {
+		p.lockTimeout = defaultLockTimeout
+	}
+	// The lease is renewed before every recipient, so it only has to outlast
+	// a single send.
+	if p.lockTimeout < 2*sendTimeout {
+		p.lockTimeout = 2 * sendTimeout
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = defaultMaxAttempts
+	}
+	if p.backoff.Initial <= 0 {
+		p.backoff.Initial = defaultRetryInitial
+	}
+	if p.backoff.Max <= 0 {
+		p.backoff.Max = defaultRetryMax
+	}
+
+	return p
+}
+
+// Run blocks until ctx is cancelled and every worker has finished the job it
+// was processing.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			p.work(ctx, id)
+		}(i)
+	}
+
+	p.logger.Info("Delivery workers started", slog.Int("workers", p.workers))
+	wg.Wait()
+	p.logger.Info("Delivery workers stopped")
+}
+
+func (p *Pool) work(ctx context.Context, id int) {
+	logger := p.logger.With(slog.Int("worker", id))
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		job, err := p.store.Claim(ctx, p.lockTimeout)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Error claiming outbox job", slog.Any("error", err))
+			}
+			timer.Reset(p.pollInterval)
+			continue
+		}
+
+		if job == nil {
+			timer.Reset(p.pollInterval)
+			continue
+		}
+
+		p.process(ctx, logger, job)
+
+		timer.Reset(0)
+	}
+}
+
+// process delivers a claimed mail to every recipient that is still pending or
+// deferred, one message per recipient. When ctx is cancelled in the middle of a
+// pass the remaining recipients are left pending and the job goes straight back
+// into the queue, so that shutdown never waits for a whole mailing. When the
+// lease on the job is lost to another worker the pass stops without touching
+// the job again.
+func (p *Pool) process(ctx context.Context, logger *slog.Logger, job *model.OutboxJob) {
+	logger = logger.With(slog.Int("job_id", job.ID), slog.Int("mail_id", job.MailID))
+
+	// Bookkeeping must survive shutdown, otherwise the job stays locked until
+	// its lease runs out.
+	storeCtx := context.WithoutCancel(ctx)
+
+	m, err := p.mails.Get(storeCtx, job.MailID)
+	if err != nil {
+		logger.Error("Error loading mail", slog.Any("error", err))
+		job.Status = model.OutboxStatusQueued
+		job.AvailableAt = time.Now().Add(p.backoff.Delay(1))
+		p.complete(storeCtx, logger, job, nil)
+		return
+	}
+
+	if m == nil {
+		logger.Error("Mail of outbox job not found")
+		job.Status = model.OutboxStatusFailed
+		job.AvailableAt = time.Now()
+		p.complete(storeCtx, logger, job, nil)
+		return
+	}
+
+	var tmpl *templating.Template
+	if m.TemplateID != nil {
+		tmpl, err = p.renderer.Template(storeCtx, *m.TemplateID, m.TemplateVersion)
+		if err != nil {
+			logger.Error("Error loading mail template", slog.Any("error", err), slog.Int("template_id", *m.TemplateID))
+			p.fail(storeCtx, logger, job, m, err, false)
+			return
+		}
+		if tmpl == nil {
+			logger.Error("Mail template not found", slog.Int("template_id", *m.TemplateID))
+			p.fail(storeCtx, logger, job, m, errors.New("template not found"), true)
+			return
+		}
+	}
+
+	attachments, err := p.mails.GetAttachments(storeCtx, m.ID)
+	if err != nil {
+		logger.Error("Error loading mail attachments", slog.Any("error", err))
+		p.fail(storeCtx, logger, job, m, err, false)
+		return
+	}
+
+	skipped, err := p.store.SuppressRecipients(storeCtx, m.ID)
+	if err != nil {
+		logger.Error("Error filtering suppressed recipients", slog.Any("error", err))
+		p.fail(storeCtx, logger, job, m, err, false)
+		return
+	}
+	for _, recipient := range skipped {
+		logger.Info("Skipping suppressed recipient", slog.String("address", recipient.Address), slog.String("reason", recipient.Reason))
+	}
+
+	// Recipients still marked sending were in flight when a previous worker
+	// lost the job. Whether they got the mail is unknown, and sending it again
+	// risks a duplicate, so they are given up on.
+	if !p.failRecipients(storeCtx, logger, job, m, outcomeUnknown, model.RecipientStatusSending) {
+		return
+	}
+
+	recipients, err := p.store.GetRecipients(
+		storeCtx,
+		m.ID,
+		model.RecipientStatusPending,
+		model.RecipientStatusDeferred,
+	)
+	if err != nil {
+		logger.Error("Error loading mail recipients", slog.Any("error", err))
+		p.fail(storeCtx, logger, job, m, err, false)
+		return
+	}
+
+	m.LastError = ""
+	interrupted := false
+	for _, recipient := range recipients {
+		if ctx.Err() != nil {
+			interrupted = true
+			break
+		}
+
+		claimed, err := p.store.MarkSending(storeCtx, job, recipient)
+		if errors.Is(err, storageErrors.ErrLeaseLost) {
+			logger.Warn("Lost the lease on outbox job, leaving it to its new worker")
+			return
+		}
+		if err != nil {
+			logger.Error("Error marking mail recipient as sending", slog.Any("error", err), slog.Int("recipient_id", recipient.ID))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		p.deliver(storeCtx, m, tmpl, attachments, recipient)
+
+		if err := p.store.UpdateRecipient(storeCtx, job, recipient); err != nil {
+			if errors.Is(err, storageErrors.ErrLeaseLost) {
+				logger.Warn("Lost the lease on outbox job, leaving it to its new worker")
+				return
+			}
+			logger.Error("Error updating mail recipient", slog.Any("error", err), slog.Int("recipient_id", recipient.ID))
+		}
+
+		if recipient.Error != "" {
+			m.LastError = recipient.Error
+		}
+	}
+
+	now := time.Now()
+	if interrupted {
+		job.Status = model.OutboxStatusQueued
+		job.AvailableAt = now
+		p.complete(storeCtx, logger, job, m)
+		return
+	}
+
+	counts, err := p.store.CountRecipients(storeCtx, m.ID)
+	if err != nil {
+		logger.Error("Error counting mail recipients", slog.Any("error", err))
+		p.fail(storeCtx, logger, job, m, err, false)
+		return
+	}
+
+	sent := counts[model.RecipientStatusSent]
+	// Recipients left pending or sending could not be settled in this pass
+	// and keep the job going along with the deferred ones.
+	deferred := counts[model.RecipientStatusDeferred] +
+		counts[model.RecipientStatusPending] +
+		counts[model.RecipientStatusSending]
+	suppressed := counts[model.RecipientStatusSuppressed]
+
+	if sent == 0 && deferred == 0 && counts[model.RecipientStatusFailed] == 0 && suppressed > 0 {
+		m.LastError = "all recipients are suppressed"
+	}
+
+	m.Attempts++
+	m.NextAttemptAt = nil
+	if sent > 0 && m.SentAt == nil {
+		m.SentAt = &now
+	}
+
+	switch {
+	case deferred == 0 && sent > 0:
+		m.Status = model.MailStatusSent
+		job.Status = model.OutboxStatusSent
+	case deferred == 0 || m.Attempts >= p.maxAttempts:
+		m.Status = model.MailStatusDead
+		job.Status = model.OutboxStatusFailed
+	default:
+		next := now.Add(p.backoff.Delay(m.Attempts))
+		m.Status = model.MailStatusRetrying
+		m.NextAttemptAt = &next
+		job.Status = model.OutboxStatusQueued
+		job.AvailableAt = next
+	}
+
+	if m.Status == model.MailStatusDead && deferred > 0 {
+		if !p.failRecipients(
+			storeCtx,
+			logger,
+			job,
+			m,
+			"",
+			model.RecipientStatusDeferred,
+			model.RecipientStatusPending,
+			model.RecipientStatusSending,
+		) {
+			return
+		}
+	}
+
+	if !p.complete(storeCtx, logger, job, m) {
+		return
+	}
+
+	logger.Info(
+		"Outbox job processed",
+		slog.String("status", m.Status),
+		slog.Int("attempts", m.Attempts),
+		slog.Int("sent", sent),
+		slog.Int("deferred", deferred),
+		slog.Int("failed", counts[model.RecipientStatusFailed]),
+		slog.Int("suppressed", suppressed),
+	)
+}
+
+// fail records a delivery pass that broke off before reaching the recipients.
+// Temporary errors are retried with the usual backoff until the attempts run
+// out; permanent ones dead-letter the mail right away.
+func (p *Pool) fail(ctx context.Context, logger *slog.Logger, job *model.OutboxJob, m *model.Mail, err error, permanent bool) {
+	now := time.Now()
+
+	m.Attempts++
+	m.LastError = err.Error()
+	m.NextAttemptAt = nil
+
+	if permanent || m.Attempts >= p.maxAttempts {
+		m.Status = model.MailStatusDead
+		job.Status = model.OutboxStatusFailed
+		job.AvailableAt = now
+		if !p.failRecipients(ctx, logger, job, m, m.LastError, model.RecipientStatusPending, model.RecipientStatusDeferred) {
+			return
+		}
+	} else {
+		next := now.Add(p.backoff.Delay(m.Attempts))
+		m.Status = model.MailStatusRetrying
+		m.NextAttemptAt = &next
+		job.Status = model.OutboxStatusQueued
+		job.AvailableAt = next
+	}
+
+	if p.complete(ctx, logger, job, m) {
+		logger.Warn("Outbox job failed", slog.String("status", m.Status), slog.Int("attempts", m.Attempts))
+	}
+}
+
+// failRecipients marks the mail's recipients in one of statuses as failed. A
+// non-empty reason replaces their last error. It reports false when the lease
+// on job has been lost, after which the job must be left alone.
+func (p *Pool) failRecipients(
+	ctx context.Context,
+	logger *slog.Logger,
+	job *model.OutboxJob,
+	m *model.Mail,
+	reason string,
+	statuses ...string,
+) bool {
+	recipients, err := p.store.GetRecipients(ctx, m.ID, statuses...)
+	if err != nil {
+		logger.Error("Error loading mail recipients", slog.Any("error", err))
+		return true
+	}
+
+	for _, recipient := range recipients {
+		recipient.Status = model.RecipientStatusFailed
+		if reason != "" {
+			recipient.Error = reason
+		}
+		if err := p.store.UpdateRecipient(ctx, job, recipient); err != nil {
+			if errors.Is(err, storageErrors.ErrLeaseLost) {
+				logger.Warn("Lost the lease on outbox job, leaving it to its new worker")
+				return false
+			}
+			logger.Error("Error updating mail recipient", slog.Any("error", err), slog.Int("recipient_id", recipient.ID))
+		}
+	}
+
+	return true
+}
+
+// complete hands the job back to the outbox and reports whether that worked.
+func (p *Pool) complete(ctx context.Context, logger *slog.Logger, job *model.OutboxJob, m *model.Mail) bool {
+	err := p.store.Complete(ctx, job, m)
+	if errors.Is(err, storageErrors.ErrLeaseLost) {
+		logger.Warn("Lost the lease on outbox job, leaving it to its new worker")
+		return false
+	}
+	if err != nil {
+		logger.Error("Error completing outbox job", slog.Any("error", err))
+		return false
+	}
+
+	return true
+}
+
+// deliver sends the mail to one recipient. Mails built from a template are
+// personalized first; a template that fails to render for the recipient, or a
+// message over the size limit, fails them permanently.
+func (p *Pool) deliver(
+	ctx context.Context,
+	m *model.Mail,
+	tmpl *templating.Template,
+	attachments []*model.Attachment,
+	recipient *model.MailRecipient,
+) {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	email := composer.FromMail(m)
+	email.To = []string{recipient.Address}
+
+	// Transactional mails concern the recipient's account, not a mailing
+	// they could leave.
+	var (
+		unsubscribeURL string
+		err            error
+	)
+	if !m.Transactional {
+		unsubscribeURL, err = p.unsubscriber.URL(recipient.ID)
+	}
+	if err == nil && tmpl != nil {
+		email, err = p.personalize(ctx, tmpl, recipient, unsubscribeURL)
+	}
+
+	var msg *sender.Message
+	if err == nil {
+		email.Attachments = attachments
+		email.UnsubscribeURL = unsubscribeURL
+		msg, err = p.composer.Compose(email)
+	}
+
+	if err == nil {
+		var results []sender.Result
+		results, err = p.sender.Send(ctx, msg)
+		if err == nil && len(results) > 0 {
+			err = results[0].Err
+		}
+		recipient.MessageID = msg.MessageID
+	}
+
+	var templateErr *templating.Error
+	switch {
+	case err == nil:
+		now := time.Now()
+		recipient.Status = model.RecipientStatusSent
+		recipient.Error = ""
+		recipient.SentAt = &now
+	case sender.IsPermanent(err), errors.As(err, &templateErr), errors.Is(err, composer.ErrTooLarge):
+		recipient.Status = model.RecipientStatusFailed
+		recipient.Error = err.Error()
+	default:
+		recipient.Status = model.RecipientStatusDeferred
+		recipient.Error = err.Error()
+	}
+}
+
+func (p *Pool) personalize(
+	ctx context.Context,
+	tmpl *templating.Template,
+	recipient *model.MailRecipient,
+	unsubscribeURL string,
+) (*composer.Email, error) {
+	data, err := p.renderer.Data(ctx, recipient.UserID, recipient.Address)
+	if err != nil {
+		return nil, err
+	}
+	data.UnsubscribeURL = unsubscribeURL
+
+	if recipient.UserID != nil {
+		data.PreferencesURL, err = p.preferences.URL(*recipient.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := tmpl.Execute(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return content.Email([]string{recipient.Address}), nil
+}
@@ -0,0 +1,62 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/campaign"
+	"subscription-mailing-service/storage/outbox"
+	"time"
+)
+
+var ErrEmptySegment = errors.New("segment has no recipients")
+
+// Dispatcher fans a campaign out into the delivery pipeline by resolving its
+// segment at send time and queueing a mail for the matching users.
+type Dispatcher struct {
+	campaigns *campaign.CampaignStorage
+	outbox    *outbox.OutboxStorage
+}
+
+func NewDispatcher(campaigns *campaign.CampaignStorage, outbox *outbox.OutboxStorage) *Dispatcher {
+	return &Dispatcher{campaigns: campaigns, outbox: outbox}
+}
+
+func (d *Dispatcher) Dispatch(ctx context.Context, c *model.Campaign) (*model.OutboxJob, error) {
+	recipients, err := d.campaigns.ResolveSegment(ctx, c.Segment, c.Topic, c.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recipients) == 0 {
+		return nil, ErrEmptySegment
+	}
+
+	to := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		to = append(to, recipient.Email)
+	}
+
+	campaignID := c.ID
+	job, err := d.outbox.Enqueue(ctx, &model.Mail{
+		To:              to,
+		Subject:         c.Subject,
+		Body:            c.Body,
+		ContentType:     c.ContentType,
+		CampaignID:      &campaignID,
+		TemplateID:      c.TemplateID,
+		TemplateVersion: c.TemplateVersion,
+		Category:        c.Category,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sentAt := time.Now()
+	if err := d.campaigns.MarkSent(ctx, c.ID, sentAt); err != nil {
+		return nil, err
+	}
+	c.LastSentAt = &sentAt
+
+	return job, nil
+}
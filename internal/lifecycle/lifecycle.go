@@ -0,0 +1,151 @@
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"subscription-mailing-service/internal/model"
+)
+
+var ErrUnknownStatus = errors.New("unknown subscription status")
+
+// transitions lists, per status, the statuses a subscription may move to.
+//
+//	pending      -> active, expired, cancelled, unsubscribed
+//	active       -> paused, cancelled, expired, unsubscribed
+//	paused       -> active, cancelled, expired, unsubscribed
+//	cancelled    -> active, pending, unsubscribed
+//	expired      -> active, pending, unsubscribed
+//	unsubscribed -> pending
+//
+// Moving from cancelled or expired back to active is only possible for a
+// subscription that was confirmed once; otherwise it has to go through pending
+// again. An unsubscribed address always opts in again through pending.
+var transitions = map[string][]string{
+	model.SubscriberStatusPending: {
+		model.SubscriberStatusActive,
+		model.SubscriberStatusExpired,
+		model.SubscriberStatusCancelled,
+		model.SubscriberStatusUnsubscribed,
+	},
+	model.SubscriberStatusActive: {
+		model.SubscriberStatusPaused,
+		model.SubscriberStatusCancelled,
+		model.SubscriberStatusExpired,
+		model.SubscriberStatusUnsubscribed,
+	},
+	model.SubscriberStatusPaused: {
+		model.SubscriberStatusActive,
+		model.SubscriberStatusCancelled,
+		model.SubscriberStatusExpired,
+		model.SubscriberStatusUnsubscribed,
+	},
+	model.SubscriberStatusCancelled: {
+		model.SubscriberStatusActive,
+		model.SubscriberStatusPending,
+		model.SubscriberStatusUnsubscribed,
+	},
+	model.SubscriberStatusExpired: {
+		model.SubscriberStatusActive,
+		model.SubscriberStatusPending,
+		model.SubscriberStatusUnsubscribed,
+	},
+	model.SubscriberStatusUnsubscribed: {
+		model.SubscriberStatusPending,
+	},
+}
+
+// TransitionError is returned for a status change the state machine does not
+// allow.
+type TransitionError struct {
+	From   string
+	To     string
+	Reason string
+}
+
+func (e *TransitionError) Error() string {
+	msg := fmt.Sprintf("subscription cannot change from %q to %q", e.From, e.To)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+
+	return msg
+}
+
+// IsValid reports whether status is one of the lifecycle statuses.
+func IsValid(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// Next returns the statuses a subscription in the given status may move to.
+func Next(from string) []string {
+	if !IsValid(from) {
+		return Statuses()
+	}
+
+	return append([]string(nil), transitions[from]...)
+}
+
+// Sources returns the statuses from which a subscription may move to the given
+// one.
+func Sources(to string) []string {
+	var sources []string
+	for _, from := range Statuses() {
+		for _, next := range transitions[from] {
+			if next == to {
+				sources = append(sources, from)
+			}
+		}
+	}
+
+	return sources
+}
+
+// Statuses lists every lifecycle status.
+func Statuses() []string {
+	return []string{
+		model.SubscriberStatusPending,
+		model.SubscriberStatusActive,
+		model.SubscriberStatusPaused,
+		model.SubscriberStatusCancelled,
+		model.SubscriberStatusExpired,
+		model.SubscriberStatusUnsubscribed,
+	}
+}
+
+// Check validates a status change. confirmed tells whether the subscription
+// was confirmed at some point, which re-activation requires. Subscriptions
+// with a status from before the lifecycle existed may move to any status.
+func Check(from, to string, confirmed bool) error {
+	if !IsValid(to) {
+		return fmt.Errorf("%w: %q", ErrUnknownStatus, to)
+	}
+
+	if !IsValid(from) {
+		return nil
+	}
+
+	if from == to {
+		return &TransitionError{From: from, To: to, Reason: "subscription already has this status"}
+	}
+
+	allowed := false
+	for _, next := range transitions[from] {
+		if next == to {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return &TransitionError{From: from, To: to}
+	}
+
+	reactivation := to == model.SubscriberStatusActive &&
+		(from == model.SubscriberStatusCancelled || from == model.SubscriberStatusExpired)
+	if reactivation && !confirmed {
+		return &TransitionError{From: from, To: to, Reason: "subscription was never confirmed and has to be pending again"}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package lifecycle
+
+import (
+	"errors"
+	"slices"
+	"subscription-mailing-service/internal/model"
+	"testing"
+)
+
+const (
+	pending      = model.SubscriberStatusPending
+	active       = model.SubscriberStatusActive
+	paused       = model.SubscriberStatusPaused
+	cancelled    = model.SubscriberStatusCancelled
+	expired      = model.SubscriberStatusExpired
+	unsubscribed = model.SubscriberStatusUnsubscribed
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		from      string
+		to        string
+		confirmed bool
+		wantErr   bool
+	}{
+		{from: pending, to: active},
+		{from: pending, to: expired},
+		{from: pending, to: cancelled},
+		{from: pending, to: unsubscribed},
+		{from: pending, to: paused, wantErr: true},
+
+		{from: active, to: paused},
+		{from: active, to: cancelled},
+		{from: active, to: expired},
+		{from: active, to: unsubscribed},
+		{from: active, to: pending, wantErr: true},
+
+		{from: paused, to: active},
+		{from: paused, to: cancelled},
+		{from: paused, to: expired},
+		{from: paused, to: unsubscribed},
+		{from: paused, to: pending, wantErr: true},
+
+		{from: cancelled, to: active, confirmed: true},
+		{from: cancelled, to: active, confirmed: false, wantErr: true},
+		{from: cancelled, to: pending},
+		{from: cancelled, to: unsubscribed},
+		{from: cancelled, to: paused, wantErr: true},
+		{from: cancelled, to: expired, wantErr: true},
+
+		{from: expired, to: active, confirmed: true},
+		{from: expired, to: active, confirmed: false, wantErr: true},
+		{from: expired, to: pending},
+		{from: expired, to: unsubscribed},
+		{from: expired, to: cancelled, wantErr: true},
+
+		{from: unsubscribed, to: pending},
+		{from: unsubscribed, to: active, confirmed: true, wantErr: true},
+		{from: unsubscribed, to: cancelled, wantErr: true},
+
+		{from: active, to: active, wantErr: true},
+		{from: unsubscribed, to: unsubscribed, wantErr: true},
+
+		// Statuses from before the lifecycle existed may move anywhere.
+		{from: "", to: active},
+		{from: "legacy", to: unsubscribed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			err := Check(tt.from, tt.to, tt.confirmed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check(%q, %q, %v) error = %v, wantErr %v", tt.from, tt.to, tt.confirmed, err, tt.wantErr)
+			}
+
+			var transitionErr *TransitionError
+			if err != nil && !errors.As(err, &transitionErr) {
+				t.Errorf("Check(%q, %q) error = %v, want a *TransitionError", tt.from, tt.to, err)
+			}
+		})
+	}
+}
+
+func TestCheckUnknownTarget(t *testing.T) {
+	for _, from := range []string{active, "", "legacy"} {
+		if err := Check(from, "deleted", true); !errors.Is(err, ErrUnknownStatus) {
+			t.Errorf("Check(%q, \"deleted\") error = %v, want ErrUnknownStatus", from, err)
+		}
+	}
+}
+
+func TestTransitionErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *TransitionError
+		want string
+	}{
+		{
+			err:  &TransitionError{From: active, To: pending},
+			want: `subscription cannot change from "active" to "pending"`,
+		},
+		{
+			err:  &TransitionError{From: active, To: active, Reason: "subscription already has this status"},
+			want: `subscription cannot change from "active" to "active": subscription already has this status`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	for _, status := range Statuses() {
+		if !IsValid(status) {
+			t.Errorf("IsValid(%q) = false, want true", status)
+		}
+	}
+
+	for _, status := range []string{"", "Active", "deleted"} {
+		if IsValid(status) {
+			t.Errorf("IsValid(%q) = true, want false", status)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		from string
+		want []string
+	}{
+		{from: pending, want: []string{active, expired, cancelled, unsubscribed}},
+		{from: unsubscribed, want: []string{pending}},
+		{from: "legacy", want: Statuses()},
+	}
+
+	for _, tt := range tests {
+		if got := Next(tt.from); !slices.Equal(got, tt.want) {
+			t.Errorf("Next(%q) = %v, want %v", tt.from, got, tt.want)
+		}
+	}
+
+	// The result must not alias the transition table.
+	Next(pending)[0] = "mutated"
+	if Next(pending)[0] != active {
+		t.Error("Next returned the transition table itself")
+	}
+}
+
+func TestSources(t *testing.T) {
+	tests := []struct {
+		to   string
+		want []string
+	}{
+		{to: pending, want: []string{cancelled, expired, unsubscribed}},
+		{to: active, want: []string{pending, paused, cancelled, expired}},
+		{to: paused, want: []string{active}},
+		{to: unsubscribed, want: []string{pending, active, paused, cancelled, expired}},
+		{to: "legacy", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := Sources(tt.to); !slices.Equal(got, tt.want) {
+			t.Errorf("Sources(%q) = %v, want %v", tt.to, got, tt.want)
+		}
+	}
+}
@@ -4,3 +4,7 @@ const (
 	Subject1 = "advertisement"
 	Subject2 = "notification"
 )
+
+func IsValid(subject string) bool {
+	return subject == Subject1 || subject == Subject2
+}
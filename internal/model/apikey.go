@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// Scopes API keys can be granted.
+const (
+	ScopeMailSend        = "mail:send"
+	ScopeSubscribersRead = "subscribers:read"
+)
+
+// APIKey lets another service call the API without a user session. Only the
+// hash of the key is stored; Prefix is the start of the key, kept to tell keys
+// apart. AllowedIPs holds addresses or CIDR ranges; empty allows any.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedBy  *int       `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatedAPIKey is returned once, when the key is created; the key cannot be
+// retrieved afterwards.
+type CreatedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
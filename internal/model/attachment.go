@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Attachment is a file stored with a mail. Attachments with a ContentID are
+// inline parts the HTML body refers to as "cid:<content_id>".
+type Attachment struct {
+	ID          int       `json:"id"`
+	MailID      int       `json:"mail_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	ContentID   string    `json:"content_id,omitempty"`
+	Size        int64     `json:"size"`
+	Data        []byte    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
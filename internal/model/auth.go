@@ -0,0 +1,10 @@
+package model
+
+// Tokens is what a successful login or refresh returns. ExpiresIn is the
+// lifetime of the access token in seconds.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// Segment selects the subscriptions a campaign reaches. Without Statuses only
+// active subscriptions are reached.
+type Segment struct {
+	Levels   []string `json:"levels,omitempty"`
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+// Campaign is a mail sent to the users of a segment. A campaign with a Topic
+// only reaches subscriptions whose plan includes the topic.
+type Campaign struct {
+	ID              int        `json:"id"`
+	Name            string     `json:"name"`
+	Subject         string     `json:"subject"`
+	Body            string     `json:"body"`
+	ContentType     string     `json:"content_type,omitempty"`
+	TemplateID      *int       `json:"template_id,omitempty"`
+	TemplateVersion *int       `json:"template_version,omitempty"`
+	Category        string     `json:"category"`
+	Topic           string     `json:"topic,omitempty"`
+	Segment         Segment    `json:"segment"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	LastSentAt      *time.Time `json:"last_sent_at,omitempty"`
+}
+
+type SegmentRecipient struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+type SegmentPreview struct {
+	Count  int                `json:"count"`
+	Sample []SegmentRecipient `json:"sample"`
+}
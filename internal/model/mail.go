@@ -2,11 +2,59 @@ package model
 
 import "time"
 
+const (
+	ContentTypeText = "text/plain"
+	ContentTypeHTML = "text/html"
+)
+
+const (
+	MailStatusDraft    = "draft"
+	MailStatusQueued   = "queued"
+	MailStatusRetrying = "retrying"
+	MailStatusSent     = "sent"
+	MailStatusDead     = "dead"
+)
+
 type Mail struct {
-	ID          int       `json:"id"`
-	To          []string  `json:"to"`
-	Subject     string    `json:"subject"`
-	Body        string    `json:"body"`
-	ContentType string    `json:"content_type,omitempty"`
-	SentAt      time.Time `json:"sent_at,omitempty"`
+	ID              int        `json:"id"`
+	To              []string   `json:"to"`
+	Subject         string     `json:"subject"`
+	Body            string     `json:"body"`
+	ContentType     string     `json:"content_type,omitempty"`
+	SentAt          *time.Time `json:"sent_at,omitempty"`
+	Status          string     `json:"status,omitempty"`
+	Attempts        int        `json:"attempts"`
+	LastError       string     `json:"last_error,omitempty"`
+	NextAttemptAt   *time.Time `json:"next_attempt_at,omitempty"`
+	CampaignID      *int       `json:"campaign_id,omitempty"`
+	TemplateID      *int       `json:"template_id,omitempty"`
+	TemplateVersion *int       `json:"template_version,omitempty"`
+	Category        string     `json:"category,omitempty"`
+	// Transactional mails are sent by the service itself about the
+	// recipient's own account. They carry no unsubscribe link and are only
+	// held back by suppressions other than unsubscribes.
+	Transactional bool `json:"transactional,omitempty"`
+}
+
+const (
+	RecipientStatusPending    = "pending"
+	RecipientStatusSending    = "sending"
+	RecipientStatusSent       = "sent"
+	RecipientStatusDeferred   = "deferred"
+	RecipientStatusFailed     = "failed"
+	RecipientStatusSuppressed = "suppressed"
+)
+
+type MailRecipient struct {
+	ID        int        `json:"id"`
+	MailID    int        `json:"mail_id"`
+	UserID    *int       `json:"user_id,omitempty"`
+	Address   string     `json:"address"`
+	Status    string     `json:"status"`
+	MessageID string     `json:"message_id,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Attempts  int        `json:"attempts"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
 }
@@ -1,6 +1,29 @@
 package model
 
+import "time"
+
+// Message is a reusable mail template. Subject and TextBody are text/template
+// sources, HTMLBody is an html/template source.
 type Message struct {
-	ID      int    `json:"id"`
-	Message string `json:"message,omitempty"`
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Subject   string    `json:"subject"`
+	HTMLBody  string    `json:"html_body,omitempty"`
+	TextBody  string    `json:"text_body,omitempty"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MessageVersion is an immutable snapshot of a message. Every save of a message
+// creates the next version.
+type MessageVersion struct {
+	ID        int       `json:"id"`
+	MessageID int       `json:"message_id"`
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	Subject   string    `json:"subject"`
+	HTMLBody  string    `json:"html_body,omitempty"`
+	TextBody  string    `json:"text_body,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+const (
+	OutboxStatusQueued     = "queued"
+	OutboxStatusProcessing = "processing"
+	OutboxStatusSent       = "sent"
+	OutboxStatusFailed     = "failed"
+)
+
+type OutboxJob struct {
+	ID          int       `json:"id"`
+	MailID      int       `json:"mail_id"`
+	Status      string    `json:"status"`
+	AvailableAt time.Time `json:"available_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// ClaimToken identifies the worker's claim on a job being processed.
+	ClaimToken string `json:"-"`
+	Mail       *Mail  `json:"mail,omitempty"`
+	// Skipped lists the recipients left out when the job was queued because
+	// their address is suppressed.
+	Skipped []SkippedRecipient `json:"skipped,omitempty"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// Plan is a subscription tier. Subscribers on a plan only receive campaigns on
+// its topics, and at most MaxMailsPerWeek campaign mails a week; zero means no
+// limit.
+type Plan struct {
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	PeriodDays      int       `json:"period_days"`
+	PriceCents      int64     `json:"price_cents"`
+	Topics          []string  `json:"topics"`
+	MaxMailsPerWeek int       `json:"max_mails_per_week"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
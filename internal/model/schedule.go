@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+const (
+	ScheduleStatusActive    = "active"
+	ScheduleStatusPaused    = "paused"
+	ScheduleStatusCancelled = "cancelled"
+	ScheduleStatusCompleted = "completed"
+)
+
+type Schedule struct {
+	ID             int        `json:"id"`
+	CampaignID     int        `json:"campaign_id"`
+	SendAt         *time.Time `json:"send_at,omitempty"`
+	CronExpression string     `json:"cron,omitempty"`
+	Timezone       string     `json:"timezone,omitempty"`
+	Status         string     `json:"status"`
+	NextRunAt      *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type ScheduleRun struct {
+	ID           int       `json:"id"`
+	ScheduleID   int       `json:"schedule_id"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	MailID       *int      `json:"mail_id,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
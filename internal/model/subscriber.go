@@ -2,12 +2,72 @@ package model
 
 import "time"
 
+const (
+	SubscriberStatusPending      = "pending"
+	SubscriberStatusActive       = "active"
+	SubscriberStatusPaused       = "paused"
+	SubscriberStatusCancelled    = "cancelled"
+	SubscriberStatusExpired      = "expired"
+	SubscriberStatusUnsubscribed = "unsubscribed"
+)
+
+// Subscriber is a subscription of a user, optionally to a plan. It runs for
+// PeriodDays at a time, until ExpiresAt; when PeriodDays is zero the period of
+// the plan applies, or the configured default without a plan.
 type Subscriber struct {
-	ID                  int       `json:"id"`
-	UserID              int       `json:"user_id"`
-	StatusSubscription  string    `json:"status,omitempty"`
-	NumberSubscriptions int       `json:"number_subscriptions"`
-	SubscriptionTime    time.Time `json:"subscription_time"`
-	SubscriptionsInRow  int       `json:"subscriptions_in_row"`
-	SubscriptionLevel   string    `json:"subscriptions_level"`
+	ID                  int        `json:"id"`
+	UserID              int        `json:"user_id"`
+	StatusSubscription  string     `json:"status,omitempty"`
+	NumberSubscriptions int        `json:"number_subscriptions"`
+	SubscriptionTime    time.Time  `json:"subscription_time"`
+	SubscriptionsInRow  int        `json:"subscriptions_in_row"`
+	SubscriptionLevel   string     `json:"subscriptions_level"`
+	UnsubscribedAt      *time.Time `json:"unsubscribed_at,omitempty"`
+	UnsubscribeReason   string     `json:"unsubscribe_reason,omitempty"`
+	ConfirmedAt         *time.Time `json:"confirmed_at,omitempty"`
+	PlanID              *int       `json:"plan_id,omitempty"`
+	PeriodDays          int        `json:"period_days,omitempty"`
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// SubscriberTransition is one status change in the history of a subscription.
+// From is empty for the creation of the subscription.
+type SubscriberTransition struct {
+	ID           int       `json:"id"`
+	SubscriberID int       `json:"subscriber_id"`
+	From         string    `json:"from,omitempty"`
+	To           string    `json:"to"`
+	Reason       string    `json:"reason,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// LevelChange is one change of a subscriber's level. Rule describes what
+// caused it.
+type LevelChange struct {
+	ID           int       `json:"id"`
+	SubscriberID int       `json:"subscriber_id"`
+	From         string    `json:"from,omitempty"`
+	To           string    `json:"to"`
+	Rule         string    `json:"rule"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// LevelNotification configures the mail sent to subscribers reaching a level.
+// Without a template the built-in message is sent.
+type LevelNotification struct {
+	Level      string     `json:"level"`
+	TemplateID *int       `json:"template_id"`
+	Enabled    bool       `json:"enabled"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// OrphanReport lists the subscriptions whose user no longer exists.
+// Enforced tells whether the user reference is validated for every row, which
+// is only the case once no orphans are left.
+type OrphanReport struct {
+	Count       int           `json:"count"`
+	Subscribers []*Subscriber `json:"subscribers"`
+	Enforced    bool          `json:"enforced"`
+	Repaired    int64         `json:"repaired,omitempty"`
 }
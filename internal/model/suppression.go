@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+const (
+	SuppressionReasonHardBounce   = "hard_bounce"
+	SuppressionReasonComplaint    = "complaint"
+	SuppressionReasonManual       = "manual"
+	SuppressionReasonUnsubscribed = "unsubscribed"
+)
+
+// Suppression blocks every delivery to an address, whatever the send path.
+type Suppression struct {
+	ID        int       `json:"id"`
+	Address   string    `json:"address"`
+	Reason    string    `json:"reason"`
+	Source    string    `json:"source,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SkippedRecipient is a recipient left out of a delivery, and why.
+type SkippedRecipient struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+}
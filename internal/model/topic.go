@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+const (
+	FrequencyImmediate = "immediate"
+	FrequencyDaily     = "daily"
+	FrequencyWeekly    = "weekly"
+)
+
+// Topic is something users can receive mails about. Users who never set a
+// preference for a topic receive it if DefaultOptIn is set.
+type Topic struct {
+	Key          string    `json:"key"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description,omitempty"`
+	DefaultOptIn bool      `json:"default_opt_in"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// TopicPreference is what a user wants to receive about a topic. Frequency
+// limits campaign mails on the topic to one a day or a week. UpdatedAt is nil
+// while the topic's defaults apply.
+type TopicPreference struct {
+	Topic     string     `json:"topic"`
+	Name      string     `json:"name"`
+	OptedIn   bool       `json:"opted_in"`
+	Frequency string     `json:"frequency"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
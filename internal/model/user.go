@@ -1,10 +1,79 @@
 package model
 
+// Roles of users. Admins manage users, operators run campaigns and
+// subscribers manage their own records.
+const (
+	RoleAdmin      = "admin"
+	RoleOperator   = "operator"
+	RoleSubscriber = "subscriber"
+)
+
+// User is a stored user. Password holds the bcrypt hash and is never
+// serialized: the API takes UserRequest and UserUpdate and answers with
+// UserResponse.
 type User struct {
 	ID        int    `json:"id"`
 	FirstName string `json:"first_name,omitempty"`
 	LastName  string `json:"last_name,omitempty"`
 	Login     string `json:"login"`
 	Email     string `json:"email,omitempty"`
-	Password  string `json:"password,omitempty"`
+	Password  string `json:"-"`
+	Role      string `json:"role,omitempty"`
+}
+
+// Response returns what the API shows of the user.
+func (u *User) Response() *UserResponse {
+	return &UserResponse{
+		ID:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Login:     u.Login,
+		Email:     u.Email,
+		Role:      u.Role,
+	}
+}
+
+// UserRequest creates a user. Password is the plain password; it is only
+// ever stored hashed.
+type UserRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Login     string `json:"login"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	Role      string `json:"role"`
+}
+
+// UserUpdate changes the profile of a user. Passwords change through
+// PasswordChange and roles on their own.
+type UserUpdate struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Login     string `json:"login"`
+	Email     string `json:"email"`
+}
+
+// PasswordChange replaces the password of a user who knows the current one.
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type UserResponse struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Login     string `json:"login"`
+	Email     string `json:"email,omitempty"`
+	Role      string `json:"role"`
+}
+
+// ValidRole reports whether role is one of the user roles.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleOperator, RoleSubscriber:
+		return true
+	}
+
+	return false
 }
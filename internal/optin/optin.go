@@ -0,0 +1,227 @@
+package optin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/url"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/token"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/outbox"
+	"subscription-mailing-service/storage/subscriber"
+	"subscription-mailing-service/storage/user"
+	"time"
+)
+
+const (
+	// Purpose is the token purpose of confirmation links.
+	Purpose = "confirm"
+
+	defaultWindow        = 48 * time.Hour
+	defaultCheckInterval = 10 * time.Minute
+
+	confirmationSubject = "Please confirm your subscription"
+)
+
+var ErrNoAddress = errors.New("user has no email address")
+
+// OptIn implements double opt-in: new subscriptions stay pending until the
+// link mailed to the user is followed, and expire when it is not followed
+// within the confirmation window.
+type OptIn struct {
+	subscribers   *subscriber.SubscriberStorage
+	users         *user.UserStorage
+	outbox        *outbox.OutboxStorage
+	signer        *token.Signer
+	url           string
+	window        time.Duration
+	checkInterval time.Duration
+	logger        *slog.Logger
+}
+
+func NewOptIn(
+	subscribers *subscriber.SubscriberStorage,
+	users *user.UserStorage,
+	outbox *outbox.OutboxStorage,
+	signer *token.Signer,
+	cfg *config.Config,
+	logger *slog.Logger,
+) *OptIn {
+	o := &OptIn{
+		subscribers:   subscribers,
+		users:         users,
+		outbox:        outbox,
+		signer:        signer,
+		url:           cfg.Confirmation.URL,
+		window:        cfg.Confirmation.Window,
+		checkInterval: cfg.Confirmation.CheckInterval,
+		logger:        logger,
+	}
+
+	if o.window <= 0 {
+		o.window = defaultWindow
+	}
+	if o.checkInterval <= 0 {
+		o.checkInterval = defaultCheckInterval
+	}
+
+	return o
+}
+
+// Subscribe stores the subscription as pending and queues the confirmation
+// mail for its user.
+func (o *OptIn) Subscribe(ctx context.Context, s *model.Subscriber) error {
+	u, err := o.users.Get(ctx, s.UserID)
+	if err != nil {
+		return err
+	}
+
+	if u == nil {
+		return storageErrors.ErrUserNotFound
+	}
+
+	if u.Email == "" {
+		return ErrNoAddress
+	}
+
+	s.StatusSubscription = model.SubscriberStatusPending
+	if err := o.subscribers.Create(ctx, s); err != nil {
+		return err
+	}
+
+	return o.requestConfirmation(ctx, s.ID, u)
+}
+
+// Resubscribe moves an ended subscription back to pending and queues a new
+// confirmation mail.
+func (o *OptIn) Resubscribe(ctx context.Context, subscriberID int, reason string) (*model.Subscriber, error) {
+	s, err := o.subscribers.Get(ctx, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	u, err := o.users.Get(ctx, s.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil || u.Email == "" {
+		return nil, ErrNoAddress
+	}
+
+	s, err = o.subscribers.Transition(ctx, subscriberID, model.SubscriberStatusPending, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.requestConfirmation(ctx, subscriberID, u); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (o *OptIn) requestConfirmation(ctx context.Context, subscriberID int, u *model.User) error {
+	link, err := o.link(subscriberID, time.Now().Add(o.window))
+	if err != nil {
+		return err
+	}
+
+	_, err = o.outbox.Enqueue(ctx, &model.Mail{
+		To:            []string{u.Email},
+		Subject:       confirmationSubject,
+		Body:          confirmationBody(u, link),
+		ContentType:   model.ContentTypeHTML,
+		Transactional: true,
+	})
+	if err != nil {
+		return fmt.Errorf("queueing confirmation mail: %w", err)
+	}
+
+	return nil
+}
+
+// Confirm activates the subscription the token was issued for.
+func (o *OptIn) Confirm(ctx context.Context, tok string) (*model.Subscriber, error) {
+	claims, err := o.signer.Verify(Purpose, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.subscribers.Confirm(ctx, claims.Subject)
+}
+
+func (o *OptIn) link(subscriberID int, expiresAt time.Time) (string, error) {
+	tok, err := o.signer.Sign(Purpose, subscriberID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(o.url)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("token", tok)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func confirmationBody(u *model.User, link string) string {
+	name := u.FirstName
+	if name == "" {
+		name = u.Login
+	}
+
+	return fmt.Sprintf(
+		"<p>Hello %s,</p>\n"+
+			"<p>Please confirm your subscription by following this link:</p>\n"+
+			"<p><a href=\"%s\">Confirm subscription</a></p>\n"+
+			"<p>If you did not subscribe, you can ignore this message.</p>\n",
+		html.EscapeString(name),
+		html.EscapeString(link),
+	)
+}
+
+// Run expires unconfirmed subscriptions until ctx is cancelled.
+func (o *OptIn) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.checkInterval)
+	defer ticker.Stop()
+
+	o.logger.Info("Confirmation expiry started", slog.Duration("window", o.window))
+	for {
+		o.expire(ctx)
+
+		select {
+		case <-ctx.Done():
+			o.logger.Info("Confirmation expiry stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *OptIn) expire(ctx context.Context) {
+	expired, err := o.subscribers.ExpirePending(ctx, time.Now().Add(-o.window))
+	if err != nil {
+		if ctx.Err() == nil {
+			o.logger.Error("Error expiring pending subscriptions", slog.Any("error", err))
+		}
+		return
+	}
+
+	if expired > 0 {
+		o.logger.Info("Expired pending subscriptions", slog.Int64("count", expired))
+	}
+}
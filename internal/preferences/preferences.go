@@ -0,0 +1,106 @@
+package preferences
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/token"
+	"subscription-mailing-service/storage/topic"
+	"time"
+)
+
+const (
+	// Purpose is the token purpose of preference center links.
+	Purpose = "preferences"
+
+	defaultTTL = 365 * 24 * time.Hour
+)
+
+var ErrInvalidFrequency = errors.New("frequency must be 'immediate', 'daily' or 'weekly'")
+
+// ValidFrequency reports whether frequency is one of the delivery frequencies.
+func ValidFrequency(frequency string) bool {
+	switch frequency {
+	case model.FrequencyImmediate, model.FrequencyDaily, model.FrequencyWeekly:
+		return true
+	}
+
+	return false
+}
+
+// Center is the self-service preference center. Its links identify the user,
+// so users can manage their topics without logging in.
+type Center struct {
+	topics *topic.TopicStorage
+	signer *token.Signer
+	url    string
+	ttl    time.Duration
+}
+
+func NewCenter(topics *topic.TopicStorage, signer *token.Signer, cfg *config.Config) *Center {
+	c := &Center{
+		topics: topics,
+		signer: signer,
+		url:    cfg.Preferences.URL,
+		ttl:    cfg.Preferences.TTL,
+	}
+
+	if c.ttl <= 0 {
+		c.ttl = defaultTTL
+	}
+
+	return c
+}
+
+// URL returns the preference center link of a user.
+func (c *Center) URL(userID int) (string, error) {
+	tok, err := c.signer.Sign(Purpose, userID, time.Now().Add(c.ttl))
+	if err != nil {
+		return "", err
+	}
+
+	link, err := url.Parse(c.url)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", tok)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
+
+// Get returns the preferences of the user the token was issued for.
+func (c *Center) Get(ctx context.Context, tok string) ([]*model.TopicPreference, error) {
+	claims, err := c.signer.Verify(Purpose, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.topics.GetPreferences(ctx, claims.Subject)
+}
+
+// Update stores preferences of the user the token was issued for and returns
+// all of their preferences.
+func (c *Center) Update(ctx context.Context, tok string, preferences []*model.TopicPreference) ([]*model.TopicPreference, error) {
+	claims, err := c.signer.Verify(Purpose, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range preferences {
+		if !ValidFrequency(p.Frequency) {
+			return nil, fmt.Errorf("topic %q: %w", p.Topic, ErrInvalidFrequency)
+		}
+	}
+
+	if err := c.topics.SavePreferences(ctx, claims.Subject, preferences); err != nil {
+		return nil, err
+	}
+
+	return c.topics.GetPreferences(ctx, claims.Subject)
+}
@@ -0,0 +1,120 @@
+package progression
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mailInfo "subscription-mailing-service/internal/mail"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/subscriberlevel"
+	"subscription-mailing-service/internal/templating"
+	"subscription-mailing-service/storage/levelnotification"
+	"subscription-mailing-service/storage/outbox"
+)
+
+// defaultTemplate is sent for levels without a configured template.
+var defaultTemplate = mustParse(&model.Message{
+	Name: "Level change",
+	Subject: "{{if .LevelChange.Promoted}}Congratulations, you reached the {{.LevelChange.To}} level" +
+		"{{else}}Your subscriber level is now {{.LevelChange.To}}{{end}}",
+	HTMLBody: "<p>Hello {{with .User.FirstName}}{{.}}{{else}}{{.User.Login}}{{end}},</p>\n" +
+		"{{if .LevelChange.Promoted}}" +
+		"<p>Thank you for staying with us: you have moved up from {{.LevelChange.From}} to {{.LevelChange.To}}.</p>\n" +
+		"{{else}}" +
+		"<p>Your subscriber level has changed from {{.LevelChange.From}} to {{.LevelChange.To}}.</p>\n" +
+		"{{end}}",
+})
+
+func mustParse(message *model.Message) *templating.Template {
+	tmpl, err := templating.Parse(message)
+	if err != nil {
+		panic(err)
+	}
+
+	return tmpl
+}
+
+// Notifier mails subscribers about changes of their level, using the template
+// configured for the new level.
+type Notifier struct {
+	settings *levelnotification.LevelNotificationStorage
+	renderer *templating.Renderer
+	outbox   *outbox.OutboxStorage
+}
+
+func NewNotifier(
+	settings *levelnotification.LevelNotificationStorage,
+	renderer *templating.Renderer,
+	outbox *outbox.OutboxStorage,
+) *Notifier {
+	return &Notifier{settings: settings, renderer: renderer, outbox: outbox}
+}
+
+// Notify queues the notification for the change. Nothing is sent for the
+// first level a subscription gets, for levels with notifications disabled, or
+// to users without an address.
+func (n *Notifier) Notify(ctx context.Context, change *model.LevelChange) error {
+	if change.From == "" {
+		return nil
+	}
+
+	setting, err := n.settings.Get(ctx, change.To)
+	if err != nil {
+		return err
+	}
+
+	if setting != nil && !setting.Enabled {
+		return nil
+	}
+
+	tmpl := defaultTemplate
+	if setting != nil && setting.TemplateID != nil {
+		tmpl, err = n.renderer.Template(ctx, *setting.TemplateID, nil)
+		if err != nil {
+			return err
+		}
+		if tmpl == nil {
+			return fmt.Errorf("notification template %d of level %q not found", *setting.TemplateID, change.To)
+		}
+	}
+
+	data, err := n.renderer.SubscriberData(ctx, change.SubscriberID)
+	if err != nil {
+		return err
+	}
+
+	if data == nil || data.User.Email == "" {
+		return nil
+	}
+
+	data.LevelChange = &templating.LevelChange{
+		From:     change.From,
+		To:       change.To,
+		Rule:     change.Rule,
+		Promoted: subscriberlevel.Rank(change.To) > subscriberlevel.Rank(change.From),
+	}
+
+	content, err := tmpl.Execute(data)
+	if err != nil {
+		return err
+	}
+
+	mail := &model.Mail{
+		To:          []string{data.User.Email},
+		Subject:     content.Subject,
+		Body:        content.HTML,
+		ContentType: model.ContentTypeHTML,
+		Category:    mailInfo.Subject2,
+	}
+	if content.HTML == "" {
+		mail.Body = content.Text
+		mail.ContentType = model.ContentTypeText
+	}
+
+	if mail.Body == "" {
+		return errors.New("notification template rendered an empty body")
+	}
+
+	_, err = n.outbox.Enqueue(ctx, mail)
+	return err
+}
@@ -0,0 +1,163 @@
+package progression
+
+import (
+	"context"
+	"log/slog"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/subscriberlevel"
+	"subscription-mailing-service/storage/subscriber"
+	"time"
+)
+
+const (
+	defaultEvaluateInterval = time.Hour
+
+	// RuleManual is recorded for levels set by hand.
+	RuleManual = "manual"
+)
+
+// Progression keeps subscriber levels in line with the level rules. Levels
+// set by hand stay until the subscriber earns a higher one.
+type Progression struct {
+	store    *subscriber.SubscriberStorage
+	engine   *subscriberlevel.Engine
+	notifier *Notifier
+	interval time.Duration
+	logger   *slog.Logger
+}
+
+func NewProgression(
+	store *subscriber.SubscriberStorage,
+	engine *subscriberlevel.Engine,
+	notifier *Notifier,
+	cfg *config.Config,
+	logger *slog.Logger,
+) *Progression {
+	p := &Progression{
+		store:    store,
+		engine:   engine,
+		notifier: notifier,
+		interval: cfg.Levels.EvaluateInterval,
+		logger:   logger,
+	}
+
+	if p.interval <= 0 {
+		p.interval = defaultEvaluateInterval
+	}
+
+	return p
+}
+
+// SetLevel changes the level by hand. It returns nil when the subscription
+// already had the level.
+func (p *Progression) SetLevel(ctx context.Context, subscriberID int, level string) (*model.LevelChange, error) {
+	change, err := p.store.ChangeLevel(ctx, subscriberID, level, RuleManual)
+	if err != nil {
+		return nil, err
+	}
+
+	p.notify(ctx, change)
+	return change, nil
+}
+
+// Evaluate applies the level the subscriber has earned, unless the current
+// level was set by hand and is not lower. It returns nil when the level does
+// not change.
+func (p *Progression) Evaluate(ctx context.Context, s *model.Subscriber, now time.Time) (*model.LevelChange, error) {
+	level, rule := p.engine.Evaluate(s, now)
+	if level == s.SubscriptionLevel {
+		return nil, nil
+	}
+
+	if subscriberlevel.Rank(level) <= subscriberlevel.Rank(s.SubscriptionLevel) {
+		last, err := p.store.LastLevelRule(ctx, s.ID)
+		if err != nil {
+			return nil, err
+		}
+		if last == RuleManual {
+			return nil, nil
+		}
+	}
+
+	change, err := p.store.ChangeLevel(ctx, s.ID, level, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	p.notify(ctx, change)
+	return change, nil
+}
+
+// notify sends the level-change mail. A failed notification does not undo the
+// change, so it is only logged.
+func (p *Progression) notify(ctx context.Context, change *model.LevelChange) {
+	if change == nil || p.notifier == nil {
+		return
+	}
+
+	if err := p.notifier.Notify(ctx, change); err != nil {
+		p.logger.Error(
+			"Error sending level change notification",
+			slog.Any("error", err),
+			slog.Int("subscriber_id", change.SubscriberID),
+			slog.String("to", change.To),
+		)
+	}
+}
+
+// EvaluateAll re-evaluates every active or paused subscription and returns
+// the number of level changes.
+func (p *Progression) EvaluateAll(ctx context.Context) (int, error) {
+	subscribers, err := p.store.GetByStatus(ctx, model.SubscriberStatusActive, model.SubscriberStatusPaused)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	changed := 0
+	for _, s := range subscribers {
+		if ctx.Err() != nil {
+			return changed, ctx.Err()
+		}
+
+		change, err := p.Evaluate(ctx, s, now)
+		if err != nil {
+			p.logger.Error("Error evaluating subscriber level", slog.Any("error", err), slog.Int("subscriber_id", s.ID))
+			continue
+		}
+
+		if change != nil {
+			changed++
+			p.logger.Info(
+				"Subscriber level changed",
+				slog.Int("subscriber_id", s.ID),
+				slog.String("from", change.From),
+				slog.String("to", change.To),
+				slog.String("rule", change.Rule),
+			)
+		}
+	}
+
+	return changed, nil
+}
+
+// Run evaluates all subscriptions periodically until ctx is cancelled.
+func (p *Progression) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.logger.Info("Level evaluation started", slog.Duration("interval", p.interval))
+	for {
+		if _, err := p.EvaluateAll(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Error("Error evaluating subscriber levels", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			p.logger.Info("Level evaluation stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package renewal
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"subscription-mailing-service/internal/config"
+	mailInfo "subscription-mailing-service/internal/mail"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/outbox"
+	"subscription-mailing-service/storage/subscriber"
+	"subscription-mailing-service/storage/user"
+	"time"
+)
+
+const (
+	defaultReminderDays  = 7
+	defaultCheckInterval = time.Hour
+
+	reminderSubject = "Your subscription is about to end"
+)
+
+// Renewal runs subscriptions period by period: it renews them on request,
+// expires them when their period ends, and reminds their users shortly
+// before.
+type Renewal struct {
+	subscribers  *subscriber.SubscriberStorage
+	users        *user.UserStorage
+	outbox       *outbox.OutboxStorage
+	reminderDays int
+	interval     time.Duration
+	logger       *slog.Logger
+}
+
+func NewRenewal(
+	subscribers *subscriber.SubscriberStorage,
+	users *user.UserStorage,
+	outbox *outbox.OutboxStorage,
+	cfg *config.Config,
+	logger *slog.Logger,
+) *Renewal {
+	r := &Renewal{
+		subscribers:  subscribers,
+		users:        users,
+		outbox:       outbox,
+		reminderDays: cfg.Renewal.ReminderDays,
+		interval:     cfg.Renewal.CheckInterval,
+		logger:       logger,
+	}
+
+	if r.reminderDays <= 0 {
+		r.reminderDays = defaultReminderDays
+	}
+	if r.interval <= 0 {
+		r.interval = defaultCheckInterval
+	}
+
+	return r
+}
+
+// Renew starts the next period of the subscription.
+func (r *Renewal) Renew(ctx context.Context, subscriberID int) (*model.Subscriber, error) {
+	return r.subscribers.Renew(ctx, subscriberID)
+}
+
+// Run expires lapsed subscriptions and sends expiry reminders until ctx is
+// cancelled.
+func (r *Renewal) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.logger.Info("Subscription renewal started", slog.Int("reminder_days", r.reminderDays))
+	for {
+		r.expire(ctx)
+		r.remind(ctx)
+
+		select {
+		case <-ctx.Done():
+			r.logger.Info("Subscription renewal stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Renewal) expire(ctx context.Context) {
+	expired, err := r.subscribers.ExpireLapsed(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Error("Error expiring lapsed subscriptions", slog.Any("error", err))
+		}
+		return
+	}
+
+	if expired > 0 {
+		r.logger.Info("Expired lapsed subscriptions", slog.Int64("count", expired))
+	}
+}
+
+func (r *Renewal) remind(ctx context.Context) {
+	subscribers, err := r.subscribers.ClaimExpiring(ctx, r.reminderDays)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Error("Error getting expiring subscriptions", slog.Any("error", err))
+		}
+		return
+	}
+
+	// Claimed subscriptions whose reminder is not queued are released, so
+	// that the next pass picks them up again.
+	for i, s := range subscribers {
+		if ctx.Err() != nil {
+			r.release(ctx, subscribers[i:]...)
+			return
+		}
+
+		if err := r.sendReminder(ctx, s); err != nil {
+			r.logger.Error("Error sending expiry reminder", slog.Any("error", err), slog.Int("subscriber_id", s.ID))
+			r.release(ctx, s)
+		}
+	}
+}
+
+func (r *Renewal) release(ctx context.Context, subscribers ...*model.Subscriber) {
+	ctx = context.WithoutCancel(ctx)
+	for _, s := range subscribers {
+		if err := r.subscribers.ReleaseReminder(ctx, s.ID); err != nil {
+			r.logger.Error("Error releasing expiry reminder", slog.Any("error", err), slog.Int("subscriber_id", s.ID))
+		}
+	}
+}
+
+// sendReminder queues the reminder of a claimed subscription. Users without
+// an address get none.
+func (r *Renewal) sendReminder(ctx context.Context, s *model.Subscriber) error {
+	u, err := r.users.Get(ctx, s.UserID)
+	if err != nil {
+		return err
+	}
+
+	if u != nil && u.Email != "" {
+		_, err = r.outbox.Enqueue(ctx, &model.Mail{
+			To:            []string{u.Email},
+			Subject:       reminderSubject,
+			Body:          reminderBody(u, *s.ExpiresAt),
+			ContentType:   model.ContentTypeHTML,
+			Category:      mailInfo.Subject2,
+			Transactional: true,
+		})
+		if err != nil {
+			return fmt.Errorf("queueing expiry reminder: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func reminderBody(u *model.User, expiresAt time.Time) string {
+	name := u.FirstName
+	if name == "" {
+		name = u.Login
+	}
+
+	return fmt.Sprintf(
+		"<p>Hello %s,</p>\n"+
+			"<p>Your subscription ends on %s.</p>\n"+
+			"<p>Renew it before then to keep receiving our mails and to keep your streak.</p>\n",
+		html.EscapeString(name),
+		expiresAt.Format("January 2, 2006"),
+	)
+}
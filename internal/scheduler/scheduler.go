@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/cron"
+	"subscription-mailing-service/internal/dispatcher"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/campaign"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/schedule"
+	"time"
+)
+
+const defaultPollInterval = 15 * time.Second
+
+// Scheduler fires due campaign schedules. Several instances may run against
+// the same database; ScheduleStorage.ClaimDue makes sure every occurrence is
+// fired by exactly one of them.
+type Scheduler struct {
+	store        *schedule.ScheduleStorage
+	campaigns    *campaign.CampaignStorage
+	dispatcher   *dispatcher.Dispatcher
+	pollInterval time.Duration
+	logger       *slog.Logger
+}
+
+func NewScheduler(
+	store *schedule.ScheduleStorage,
+	campaigns *campaign.CampaignStorage,
+	dispatcher *dispatcher.Dispatcher,
+	cfg *config.Config,
+	logger *slog.Logger,
+) *Scheduler {
+	s := &Scheduler{
+		store:        store,
+		campaigns:    campaigns,
+		dispatcher:   dispatcher,
+		pollInterval: cfg.Scheduler.PollInterval,
+		logger:       logger,
+	}
+
+	if s.pollInterval <= 0 {
+		s.pollInterval = defaultPollInterval
+	}
+
+	return s
+}
+
+// NextRun returns the first time the schedule fires strictly after the given
+// time, or nil when it never fires again.
+func NextRun(s *model.Schedule, after time.Time) (*time.Time, error) {
+	if s.CronExpression == "" {
+		if s.SendAt != nil && s.SendAt.After(after) {
+			next := s.SendAt.UTC()
+			return &next, nil
+		}
+		return nil, nil
+	}
+
+	expr, err := cron.Parse(s.CronExpression)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	next := expr.Next(after.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+
+	next = next.UTC()
+	return &next, nil
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	s.logger.Info("Scheduler started", slog.Duration("poll_interval", s.pollInterval))
+	for {
+		s.fireDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) fireDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now().UTC()
+		sch, run, err := s.store.ClaimDue(ctx, now, func(sch *model.Schedule) (*time.Time, error) {
+			return NextRun(sch, now)
+		})
+		if errors.Is(err, storageErrors.ErrScheduleNextRun) {
+			s.logger.Error("Schedule paused", slog.Int("schedule_id", sch.ID), slog.Any("error", err))
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Error("Error claiming due schedule", slog.Any("error", err))
+			}
+			return
+		}
+
+		if sch == nil {
+			return
+		}
+
+		if run == nil {
+			s.logger.Warn("Schedule occurrence already fired", slog.Int("schedule_id", sch.ID))
+			continue
+		}
+
+		s.fire(context.WithoutCancel(ctx), sch, run)
+	}
+}
+
+func (s *Scheduler) fire(ctx context.Context, sch *model.Schedule, run *model.ScheduleRun) {
+	logger := s.logger.With(slog.Int("schedule_id", sch.ID), slog.Int("campaign_id", sch.CampaignID))
+
+	c, err := s.campaigns.Get(ctx, sch.CampaignID)
+	if err == nil && c == nil {
+		err = errors.New("campaign not found")
+	}
+
+	if err == nil {
+		var job *model.OutboxJob
+		job, err = s.dispatcher.Dispatch(ctx, c)
+		if err == nil {
+			run.MailID = &job.MailID
+		}
+	}
+
+	if err != nil {
+		logger.Error("Error firing schedule", slog.Any("error", err))
+		run.Error = err.Error()
+	} else {
+		logger.Info("Schedule fired", slog.Int("mail_id", *run.MailID))
+	}
+
+	if err := s.store.FinishRun(ctx, run); err != nil {
+		logger.Error("Error recording schedule run", slog.Any("error", err))
+	}
+}
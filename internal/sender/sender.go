@@ -0,0 +1,36 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+)
+
+// Message is a fully composed RFC 5322 message together with its SMTP envelope.
+type Message struct {
+	From      string
+	To        []string
+	MessageID string
+	Data      []byte
+}
+
+// Result is the delivery outcome for a single envelope recipient.
+type Result struct {
+	Address string
+	Err     error
+}
+
+type Sender interface {
+	// Send delivers msg to every envelope recipient. The returned error is set
+	// when the whole transaction failed; per-recipient rejections are reported
+	// in the results instead.
+	Send(ctx context.Context, msg *Message) ([]Result, error)
+}
+
+// IsPermanent reports whether err is a permanent SMTP failure (5xx reply) that
+// will not succeed on retry. Everything else, including network errors and
+// 4xx replies, is treated as temporary.
+func IsPermanent(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500 && protoErr.Code < 600
+}
@@ -0,0 +1,228 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"subscription-mailing-service/internal/config"
+	"time"
+)
+
+const (
+	EncryptionNone     = "none"
+	EncryptionSTARTTLS = "starttls"
+	EncryptionTLS      = "tls"
+
+	AuthNone  = "none"
+	AuthPlain = "plain"
+	AuthLogin = "login"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type SMTPSender struct {
+	host       string
+	port       string
+	username   string
+	password   string
+	encryption string
+	auth       string
+	timeout    time.Duration
+}
+
+func NewSMTPSender(cfg *config.Config) (*SMTPSender, error) {
+	s := &SMTPSender{
+		host:       cfg.SMTP.Host,
+		port:       cfg.SMTP.Port,
+		username:   cfg.SMTP.Username,
+		password:   cfg.SMTP.Password,
+		encryption: strings.ToLower(cfg.SMTP.Encryption),
+		auth:       strings.ToLower(cfg.SMTP.Auth),
+		timeout:    cfg.SMTP.Timeout,
+	}
+
+	if s.host == "" || s.port == "" {
+		return nil, errors.New("smtp host and port are required")
+	}
+
+	switch s.encryption {
+	case "":
+		s.encryption = EncryptionNone
+	case EncryptionNone, EncryptionSTARTTLS, EncryptionTLS:
+	default:
+		return nil, fmt.Errorf("unknown smtp encryption %q", cfg.SMTP.Encryption)
+	}
+
+	switch s.auth {
+	case "":
+		s.auth = AuthNone
+	case AuthNone, AuthPlain, AuthLogin:
+	default:
+		return nil, fmt.Errorf("unknown smtp auth %q", cfg.SMTP.Auth)
+	}
+
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+
+	return s, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) ([]Result, error) {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	if err := client.Mail(from.Address); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(msg.To))
+	accepted := 0
+	for _, addr := range msg.To {
+		err := client.Rcpt(addr)
+		if err == nil {
+			accepted++
+		}
+		results = append(results, Result{Address: addr, Err: err})
+	}
+
+	if accepted == 0 {
+		_ = client.Quit()
+		return results, nil
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(msg.Data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+		return results, nil
+	}
+
+	_ = client.Quit()
+
+	return results, nil
+}
+
+func (s *SMTPSender) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.host, s.port)
+	dialer := &net.Dialer{Timeout: s.timeout}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.encryption == EncryptionTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if s.encryption == EncryptionSTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	var auth smtp.Auth
+	switch s.auth {
+	case AuthPlain:
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	case AuthLogin:
+		auth = &loginAuth{username: s.username, password: s.password, host: s.host}
+	}
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// loginAuth implements the non-standard but widely deployed AUTH LOGIN mechanism,
+// which net/smtp does not provide.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,372 @@
+package sender
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"subscription-mailing-service/internal/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a minimal SMTP server that accepts a single client per
+// connection and answers with canned replies.
+type fakeServer struct {
+	listener net.Listener
+
+	username string
+	password string
+
+	// rcptReply and dataReply override the reply to RCPT TO and to the end of
+	// DATA, e.g. "451 4.7.1 Try again later".
+	rcptReply string
+	dataReply string
+	// stall makes the server accept connections without ever greeting.
+	stall bool
+
+	mu    sync.Mutex
+	auths []string
+	data  []string
+	wg    sync.WaitGroup
+	conns []net.Conn
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	s := &fakeServer{
+		listener:  l,
+		username:  "user",
+		password:  "secret",
+		rcptReply: "250 2.1.5 OK",
+		dataReply: "250 2.0.0 Queued",
+	}
+	t.Cleanup(s.close)
+
+	return s
+}
+
+func (s *fakeServer) start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+}
+
+func (s *fakeServer) close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *fakeServer) sender(t *testing.T, auth string, timeout time.Duration) *SMTPSender {
+	t.Helper()
+
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+
+	cfg := &config.Config{}
+	cfg.SMTP.Host = host
+	cfg.SMTP.Port = port
+	cfg.SMTP.Username = s.username
+	cfg.SMTP.Password = s.password
+	cfg.SMTP.Auth = auth
+	cfg.SMTP.Timeout = timeout
+
+	snd, err := NewSMTPSender(cfg)
+	if err != nil {
+		t.Fatalf("NewSMTPSender: %v", err)
+	}
+
+	return snd
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	if s.stall {
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+		return
+	}
+
+	tp := textproto.NewConn(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			_ = tp.PrintfLine("%s", line)
+		}
+	}
+	decode := func(line string) string {
+		b, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+		return string(b)
+	}
+
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(verb, "EHLO"):
+			reply("250-localhost", "250-AUTH PLAIN LOGIN", "250 8BITMIME")
+		case strings.HasPrefix(verb, "AUTH PLAIN "):
+			parts := strings.Split(decode(line[len("AUTH PLAIN "):]), "\x00")
+			s.recordAuth("PLAIN", parts[len(parts)-2], parts[len(parts)-1])
+			s.replyAuth(reply, parts[len(parts)-2], parts[len(parts)-1])
+		case verb == "AUTH LOGIN":
+			reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+			userLine, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+			passLine, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			s.recordAuth("LOGIN", decode(userLine), decode(passLine))
+			s.replyAuth(reply, decode(userLine), decode(passLine))
+		case strings.HasPrefix(verb, "MAIL FROM:"):
+			reply("250 2.1.0 OK")
+		case strings.HasPrefix(verb, "RCPT TO:"):
+			reply(s.rcptReply)
+		case verb == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			body, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = append(s.data, strings.Join(body, "\n"))
+			s.mu.Unlock()
+			reply(s.dataReply)
+		case verb == "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func (s *fakeServer) recordAuth(mechanism, username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auths = append(s.auths, mechanism+" "+username+":"+password)
+}
+
+func (s *fakeServer) replyAuth(reply func(...string), username, password string) {
+	if username == s.username && password == s.password {
+		reply("235 2.7.0 Authentication successful")
+		return
+	}
+	reply("535 5.7.8 Authentication credentials invalid")
+}
+
+func testMessage() *Message {
+	return &Message{
+		From:      "News <news@example.com>",
+		To:        []string{"alice@example.com"},
+		MessageID: "<1@example.com>",
+		Data:      []byte("Subject: Hello\r\n\r\nHello, Alice\r\n"),
+	}
+}
+
+func TestSMTPSenderAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		auth string
+		want []string
+	}{
+		{name: "none", auth: AuthNone, want: nil},
+		{name: "plain", auth: AuthPlain, want: []string{"PLAIN user:secret"}},
+		{name: "login", auth: AuthLogin, want: []string{"LOGIN user:secret"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			srv.start()
+
+			results, err := srv.sender(t, tt.auth, time.Second).Send(context.Background(), testMessage())
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if len(results) != 1 || results[0].Err != nil {
+				t.Fatalf("results = %+v, want one accepted recipient", results)
+			}
+
+			srv.close()
+			if strings.Join(srv.auths, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("auths = %q, want %q", srv.auths, tt.want)
+			}
+			if len(srv.data) != 1 || !strings.Contains(srv.data[0], "Hello, Alice") {
+				t.Errorf("data = %q, want the message body", srv.data)
+			}
+		})
+	}
+}
+
+func TestSMTPSenderAuthRejected(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.start()
+
+	snd := srv.sender(t, AuthLogin, time.Second)
+	snd.password = "wrong"
+
+	_, err := snd.Send(context.Background(), testMessage())
+	if err == nil {
+		t.Fatal("Send succeeded with a wrong password")
+	}
+	if !IsPermanent(err) {
+		t.Errorf("IsPermanent(%v) = false, want true", err)
+	}
+}
+
+func TestSMTPSenderRecipientFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		rcptReply string
+		dataReply string
+		permanent bool
+	}{
+		{name: "temporary rcpt", rcptReply: "451 4.7.1 Try again later", permanent: false},
+		{name: "permanent rcpt", rcptReply: "550 5.1.1 No such user", permanent: true},
+		{name: "temporary data", dataReply: "452 4.3.1 Insufficient storage", permanent: false},
+		{name: "permanent data", dataReply: "554 5.6.0 Message rejected", permanent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			if tt.rcptReply != "" {
+				srv.rcptReply = tt.rcptReply
+			}
+			if tt.dataReply != "" {
+				srv.dataReply = tt.dataReply
+			}
+			srv.start()
+
+			results, err := srv.sender(t, AuthNone, time.Second).Send(context.Background(), testMessage())
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if len(results) != 1 || results[0].Err == nil {
+				t.Fatalf("results = %+v, want one rejected recipient", results)
+			}
+
+			var protoErr *textproto.Error
+			if !errors.As(results[0].Err, &protoErr) {
+				t.Fatalf("error %v is not an SMTP reply", results[0].Err)
+			}
+			if got := IsPermanent(results[0].Err); got != tt.permanent {
+				t.Errorf("IsPermanent(%v) = %v, want %v", results[0].Err, got, tt.permanent)
+			}
+		})
+	}
+}
+
+func TestSMTPSenderTimeout(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.stall = true
+	srv.start()
+
+	start := time.Now()
+	_, err := srv.sender(t, AuthNone, 200*time.Millisecond).Send(context.Background(), testMessage())
+	if err == nil {
+		t.Fatal("Send succeeded against a stalled server")
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("error = %v, want a timeout", err)
+	}
+	if IsPermanent(err) {
+		t.Errorf("IsPermanent(%v) = true, want false", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send took %v, want it to give up after the timeout", elapsed)
+	}
+}
+
+func TestSMTPSenderContextDeadline(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.stall = true
+	srv.start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := srv.sender(t, AuthNone, time.Minute).Send(ctx, testMessage())
+	if err == nil {
+		t.Fatal("Send succeeded against a stalled server")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send took %v, want it to honour the context deadline", elapsed)
+	}
+}
+
+func TestNewSMTPSender(t *testing.T) {
+	tests := []struct {
+		name       string
+		host       string
+		port       string
+		encryption string
+		auth       string
+		wantErr    bool
+	}{
+		{name: "defaults", host: "localhost", port: "25"},
+		{name: "missing host", port: "25", wantErr: true},
+		{name: "missing port", host: "localhost", wantErr: true},
+		{name: "starttls login", host: "localhost", port: "587", encryption: "STARTTLS", auth: "LOGIN"},
+		{name: "unknown encryption", host: "localhost", port: "25", encryption: "ssl", wantErr: true},
+		{name: "unknown auth", host: "localhost", port: "25", auth: "cram-md5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.SMTP.Host = tt.host
+			cfg.SMTP.Port = tt.port
+			cfg.SMTP.Encryption = tt.encryption
+			cfg.SMTP.Auth = tt.auth
+
+			_, err := NewSMTPSender(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSMTPSender() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
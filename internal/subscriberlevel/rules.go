@@ -0,0 +1,108 @@
+package subscriberlevel
+
+import (
+	"fmt"
+	"sort"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"time"
+)
+
+// Rule is what a subscriber needs to reach a level. Every threshold of the
+// rule has to be met.
+type Rule struct {
+	Level               string
+	NumberSubscriptions int
+	SubscriptionsInRow  int
+	Tenure              time.Duration
+}
+
+// DefaultRules are used for levels without configured thresholds.
+var DefaultRules = []Rule{
+	{Level: Apprentice, NumberSubscriptions: 3, SubscriptionsInRow: 2, Tenure: 30 * 24 * time.Hour},
+	{Level: Adept, NumberSubscriptions: 6, SubscriptionsInRow: 4, Tenure: 180 * 24 * time.Hour},
+	{Level: Master, NumberSubscriptions: 12, SubscriptionsInRow: 8, Tenure: 365 * 24 * time.Hour},
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf(
+		"%s: number_subscriptions >= %d, subscriptions_in_row >= %d, tenure >= %s",
+		r.Level,
+		r.NumberSubscriptions,
+		r.SubscriptionsInRow,
+		r.Tenure,
+	)
+}
+
+func (r Rule) matches(s *model.Subscriber, tenure time.Duration) bool {
+	return s.NumberSubscriptions >= r.NumberSubscriptions &&
+		s.SubscriptionsInRow >= r.SubscriptionsInRow &&
+		tenure >= r.Tenure
+}
+
+// Engine computes the level a subscriber has earned.
+type Engine struct {
+	// rules is ordered from the highest level down.
+	rules []Rule
+}
+
+// NewEngine builds the engine from the configured thresholds, falling back to
+// DefaultRules for levels that are not configured. Unknown levels in the
+// configuration are an error.
+func NewEngine(cfg *config.Config) (*Engine, error) {
+	rules := map[string]Rule{}
+	for _, rule := range DefaultRules {
+		rules[rule.Level] = rule
+	}
+
+	for level, t := range cfg.Levels.Rules {
+		if !IsValid(level) || level == Newbie {
+			return nil, fmt.Errorf("no thresholds can be configured for level %q", level)
+		}
+		rules[level] = Rule{
+			Level:               level,
+			NumberSubscriptions: t.NumberSubscriptions,
+			SubscriptionsInRow:  t.SubscriptionsInRow,
+			Tenure:              t.Tenure,
+		}
+	}
+
+	e := &Engine{}
+	for _, rule := range rules {
+		e.rules = append(e.rules, rule)
+	}
+	sort.Slice(e.rules, func(i, j int) bool {
+		return Rank(e.rules[i].Level) > Rank(e.rules[j].Level)
+	})
+
+	return e, nil
+}
+
+// Rules returns the rules from the lowest level up.
+func (e *Engine) Rules() []Rule {
+	rules := make([]Rule, 0, len(e.rules))
+	for i := len(e.rules) - 1; i >= 0; i-- {
+		rules = append(rules, e.rules[i])
+	}
+
+	return rules
+}
+
+// Evaluate returns the highest level whose rule the subscriber meets and a
+// description of that rule. Subscribers meeting no rule are newbies. Tenure
+// counts from the first confirmation of the subscription.
+func (e *Engine) Evaluate(s *model.Subscriber, now time.Time) (string, string) {
+	since := s.CreatedAt
+	if s.ConfirmedAt != nil {
+		since = *s.ConfirmedAt
+	}
+	tenure := now.Sub(since)
+
+	for _, rule := range e.rules {
+		if rule.matches(s, tenure) {
+			return rule.Level, rule.String()
+		}
+	}
+
+	return Newbie, Newbie + ": no other rule met"
+}
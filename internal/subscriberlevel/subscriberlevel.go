@@ -1,8 +1,28 @@
 package subscriberlevel
 
 const (
-	level1 = "newbie"
-	level2 = "apprentice"
-	level3 = "adept"
-	level4 = "master"
+	Newbie     = "newbie"
+	Apprentice = "apprentice"
+	Adept      = "adept"
+	Master     = "master"
 )
+
+// Levels lists the levels from lowest to highest.
+func Levels() []string {
+	return []string{Newbie, Apprentice, Adept, Master}
+}
+
+func IsValid(level string) bool {
+	return Rank(level) >= 0
+}
+
+// Rank is the position of the level in Levels, or -1 for an unknown level.
+func Rank(level string) int {
+	for i, l := range Levels() {
+		if l == level {
+			return i
+		}
+	}
+
+	return -1
+}
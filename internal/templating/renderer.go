@@ -0,0 +1,104 @@
+package templating
+
+import (
+	"context"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/message"
+	"subscription-mailing-service/storage/subscriber"
+	"subscription-mailing-service/storage/user"
+)
+
+// Renderer loads stored templates and the recipient data they are executed
+// against.
+type Renderer struct {
+	messages    *message.MessageStorage
+	users       *user.UserStorage
+	subscribers *subscriber.SubscriberStorage
+}
+
+func NewRenderer(
+	messages *message.MessageStorage,
+	users *user.UserStorage,
+	subscribers *subscriber.SubscriberStorage,
+) *Renderer {
+	return &Renderer{messages: messages, users: users, subscribers: subscribers}
+}
+
+// Template loads and parses a version of a stored message, or its current
+// version when version is nil. It returns nil when the message or version does
+// not exist.
+func (r *Renderer) Template(ctx context.Context, id int, version *int) (*Template, error) {
+	if version == nil {
+		msg, err := r.messages.Get(ctx, id)
+		if err != nil || msg == nil {
+			return nil, err
+		}
+
+		return Parse(msg)
+	}
+
+	v, err := r.messages.GetVersion(ctx, id, *version)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	return Parse(&model.Message{
+		ID:       v.MessageID,
+		Name:     v.Name,
+		Subject:  v.Subject,
+		HTMLBody: v.HTMLBody,
+		TextBody: v.TextBody,
+		Version:  v.Version,
+	})
+}
+
+// Data loads the data for a mail recipient. Recipients that are not known users
+// only get their address.
+func (r *Renderer) Data(ctx context.Context, userID *int, address string) (*Data, error) {
+	if userID == nil {
+		return NewData(&model.User{Email: address}, nil), nil
+	}
+
+	data, err := r.UserData(ctx, *userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return NewData(&model.User{Email: address}, nil), nil
+	}
+
+	return data, nil
+}
+
+// UserData loads the user and their latest subscription. It returns nil when
+// the user does not exist.
+func (r *Renderer) UserData(ctx context.Context, userID int) (*Data, error) {
+	u, err := r.users.Get(ctx, userID)
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	s, err := r.subscribers.GetByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewData(u, s), nil
+}
+
+// SubscriberData loads the subscription and its user. It returns nil when the
+// subscription does not exist.
+func (r *Renderer) SubscriberData(ctx context.Context, subscriberID int) (*Data, error) {
+	s, err := r.subscribers.Get(ctx, subscriberID)
+	if err != nil || s == nil {
+		return nil, err
+	}
+
+	u, err := r.users.Get(ctx, s.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewData(u, s), nil
+}
@@ -0,0 +1,188 @@
+package templating
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"subscription-mailing-service/internal/composer"
+	"subscription-mailing-service/internal/model"
+	texttemplate "text/template"
+)
+
+const (
+	FieldSubject  = "subject"
+	FieldHTMLBody = "html_body"
+	FieldTextBody = "text_body"
+)
+
+// Data is what a message template is executed against for one recipient.
+type Data struct {
+	User       *model.User
+	Subscriber *model.Subscriber
+	// UnsubscribeURL is the recipient's unsubscribe link. It is empty in
+	// previews and test sends.
+	UnsubscribeURL string
+	// PreferencesURL is the recipient's preference center link. It is empty
+	// in previews, test sends and for recipients who are not users.
+	PreferencesURL string
+	// LevelChange is set for level-change notifications.
+	LevelChange *LevelChange
+}
+
+// LevelChange describes a change of the subscriber's level.
+type LevelChange struct {
+	From     string
+	To       string
+	Rule     string
+	Promoted bool
+}
+
+// NewData builds template data for a recipient. Missing records are replaced by
+// empty ones so that templates referencing them render blanks instead of
+// failing, and the password hash never reaches a template.
+func NewData(user *model.User, subscriber *model.Subscriber) *Data {
+	data := &Data{User: &model.User{}, Subscriber: &model.Subscriber{}, LevelChange: &LevelChange{}}
+
+	if user != nil {
+		u := *user
+		u.Password = ""
+		data.User = &u
+	}
+
+	if subscriber != nil {
+		data.Subscriber = subscriber
+	}
+
+	return data
+}
+
+// Content is a rendered message.
+type Content struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+// Email builds the email to compose for the recipients.
+func (c *Content) Email(to []string) *composer.Email {
+	return &composer.Email{To: to, Subject: c.Subject, HTML: c.HTML, Text: c.Text}
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error lists every template field that failed to parse or execute.
+type Error struct {
+	Fields []FieldError
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+
+	return "invalid template: " + strings.Join(messages, "; ")
+}
+
+func (e *Error) add(field string, err error) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: err.Error()})
+}
+
+func (e *Error) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Template is a parsed message.
+type Template struct {
+	subject *texttemplate.Template
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+}
+
+// Parse compiles every part of the message. Parse errors are reported as
+// *Error.
+func Parse(message *model.Message) (*Template, error) {
+	t := &Template{}
+	verr := &Error{}
+	var err error
+
+	t.subject, err = texttemplate.New(FieldSubject).Option("missingkey=error").Parse(message.Subject)
+	if err != nil {
+		verr.add(FieldSubject, err)
+	}
+
+	if message.HTMLBody != "" {
+		t.html, err = htmltemplate.New(FieldHTMLBody).Option("missingkey=error").Parse(message.HTMLBody)
+		if err != nil {
+			verr.add(FieldHTMLBody, err)
+		}
+	}
+
+	if message.TextBody != "" {
+		t.text, err = texttemplate.New(FieldTextBody).Option("missingkey=error").Parse(message.TextBody)
+		if err != nil {
+			verr.add(FieldTextBody, err)
+		}
+	}
+
+	if err := verr.orNil(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Execute renders the message against data, usually a *Data. Execution errors
+// are reported as *Error.
+func (t *Template) Execute(data any) (*Content, error) {
+	content := &Content{}
+	verr := &Error{}
+	var buf bytes.Buffer
+
+	if err := t.subject.Execute(&buf, data); err != nil {
+		verr.add(FieldSubject, err)
+	}
+	// A subject is a single header line.
+	content.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	if t.html != nil {
+		buf.Reset()
+		if err := t.html.Execute(&buf, data); err != nil {
+			verr.add(FieldHTMLBody, err)
+		}
+		content.HTML = buf.String()
+	}
+
+	if t.text != nil {
+		buf.Reset()
+		if err := t.text.Execute(&buf, data); err != nil {
+			verr.add(FieldTextBody, err)
+		}
+		content.Text = buf.String()
+	}
+
+	if err := verr.orNil(); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// Validate parses the message and executes it against empty recipient data,
+// which catches references to fields that do not exist.
+func Validate(message *model.Message) error {
+	t, err := Parse(message)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Execute(NewData(nil, nil))
+	return err
+}
@@ -0,0 +1,111 @@
+package textdiff
+
+import "strings"
+
+const (
+	OpEqual  = "equal"
+	OpInsert = "insert"
+	OpDelete = "delete"
+)
+
+type Line struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines returns the line-by-line edit script turning a into b, built from the
+// longest common subsequence of their lines.
+func Lines(a, b string) []Line {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// Common prefix and suffix are equal lines and need no table.
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, len(x)+len(y))
+	for _, text := range x[:prefix] {
+		lines = append(lines, Line{Op: OpEqual, Text: text})
+	}
+
+	lines = append(lines, middle(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+
+	for _, text := range x[len(x)-suffix:] {
+		lines = append(lines, Line{Op: OpEqual, Text: text})
+	}
+
+	return lines
+}
+
+// Changed reports whether the edit script contains anything but equal lines.
+func Changed(lines []Line) bool {
+	for _, line := range lines {
+		if line.Op != OpEqual {
+			return true
+		}
+	}
+
+	return false
+}
+
+func middle(x, y []string) []Line {
+	n, m := len(x), len(y)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]Line, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, Line{Op: OpEqual, Text: x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Op: OpDelete, Text: x[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: OpInsert, Text: y[j]})
+			j++
+		}
+	}
+
+	for ; i < n; i++ {
+		lines = append(lines, Line{Op: OpDelete, Text: x[i]})
+	}
+
+	for ; j < m; j++ {
+		lines = append(lines, Line{Op: OpInsert, Text: y[j]})
+	}
+
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,111 @@
+package unsubscribe
+
+import (
+	"context"
+	"net/url"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/internal/token"
+	"subscription-mailing-service/storage/outbox"
+	"subscription-mailing-service/storage/subscriber"
+	"time"
+)
+
+const (
+	// Purpose is the token purpose of unsubscribe links.
+	Purpose = "unsubscribe"
+
+	defaultTTL = 365 * 24 * time.Hour
+
+	maxReasonLength = 500
+)
+
+// Unsubscriber issues the per-recipient unsubscribe links of outgoing mails
+// and ends the subscriptions of recipients who follow them. Links identify the
+// mail recipient, so they work without the recipient logging in.
+type Unsubscriber struct {
+	subscribers *subscriber.SubscriberStorage
+	outbox      *outbox.OutboxStorage
+	signer      *token.Signer
+	url         string
+	ttl         time.Duration
+}
+
+func NewUnsubscriber(
+	subscribers *subscriber.SubscriberStorage,
+	outbox *outbox.OutboxStorage,
+	signer *token.Signer,
+	cfg *config.Config,
+) *Unsubscriber {
+	u := &Unsubscriber{
+		subscribers: subscribers,
+		outbox:      outbox,
+		signer:      signer,
+		url:         cfg.Unsubscribe.URL,
+		ttl:         cfg.Unsubscribe.TTL,
+	}
+
+	if u.ttl <= 0 {
+		u.ttl = defaultTTL
+	}
+
+	return u
+}
+
+// URL returns the unsubscribe link for a mail recipient.
+func (u *Unsubscriber) URL(recipientID int) (string, error) {
+	tok, err := u.signer.Sign(Purpose, recipientID, time.Now().Add(u.ttl))
+	if err != nil {
+		return "", err
+	}
+
+	link, err := url.Parse(u.url)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", tok)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
+
+// Recipient returns the mail recipient the token was issued for.
+func (u *Unsubscriber) Recipient(ctx context.Context, tok string) (*model.MailRecipient, error) {
+	claims, err := u.signer.Verify(Purpose, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := u.outbox.GetRecipient(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipient == nil {
+		return nil, token.ErrInvalid
+	}
+
+	return recipient, nil
+}
+
+// Unsubscribe suppresses the address of the recipient the token was issued
+// for and ends the subscriptions of its user, if any. Following a link again
+// is not an error.
+func (u *Unsubscriber) Unsubscribe(ctx context.Context, tok, reason string) (*model.MailRecipient, error) {
+	recipient, err := u.Recipient(ctx, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	if r := []rune(reason); len(r) > maxReasonLength {
+		reason = string(r[:maxReasonLength])
+	}
+
+	if _, err := u.subscribers.Unsubscribe(ctx, recipient.UserID, recipient.Address, reason); err != nil {
+		return nil, err
+	}
+
+	return recipient, nil
+}
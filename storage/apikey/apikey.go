@@ -0,0 +1,140 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/postgres"
+)
+
+type APIKeyStorage struct {
+	db *sql.DB
+}
+
+func (s *APIKeyStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewAPIKeyStorage(cfg *config.Config) (*APIKeyStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APIKeyStorage{db: db}, nil
+}
+
+const apiKeyColumns = `
+	id,
+	name,
+	prefix,
+	scopes,
+	allowed_ips,
+	expires_at,
+	created_by,
+	created_at,
+	last_used_at,
+	revoked_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row scanner) (*model.APIKey, error) {
+	key := &model.APIKey{}
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		pq.Array(&key.AllowedIPs),
+		&key.ExpiresAt,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Create stores the key by the hash of its value.
+func (s *APIKeyStorage) Create(ctx context.Context, key *model.APIKey, hash string) error {
+	const query = `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, allowed_ips, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		key.Name,
+		key.Prefix,
+		hash,
+		pq.Array(key.Scopes),
+		pq.Array(key.AllowedIPs),
+		key.ExpiresAt,
+		key.CreatedBy,
+	).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (s *APIKeyStorage) GetAll(ctx context.Context) ([]*model.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetByHash returns the key with the hash, revoked or not, or nil when there
+// is none.
+func (s *APIKeyStorage) GetByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return key, err
+}
+
+// Revoke ends the key for good. Unknown keys yield sql.ErrNoRows.
+func (s *APIKeyStorage) Revoke(ctx context.Context, id int) (*model.APIKey, error) {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1
+		RETURNING ` + apiKeyColumns
+
+	return scanAPIKey(s.db.QueryRowContext(ctx, query, id))
+}
+
+// Touch records that the key was just used.
+func (s *APIKeyStorage) Touch(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,375 @@
+package campaign
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/postgres"
+	"time"
+)
+
+type CampaignStorage struct {
+	db *sql.DB
+}
+
+func (s *CampaignStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewCampaignStorage(cfg *config.Config) (*CampaignStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CampaignStorage{db: db}, nil
+}
+
+const campaignColumns = `
+	id,
+	name,
+	subject,
+	body,
+	COALESCE(content_type, ''),
+	template_id,
+	template_version,
+	category,
+	COALESCE(topic, ''),
+	segment_levels,
+	segment_statuses,
+	created_at,
+	updated_at,
+	last_sent_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCampaign(row scanner) (*model.Campaign, error) {
+	campaign := &model.Campaign{}
+	err := row.Scan(
+		&campaign.ID,
+		&campaign.Name,
+		&campaign.Subject,
+		&campaign.Body,
+		&campaign.ContentType,
+		&campaign.TemplateID,
+		&campaign.TemplateVersion,
+		&campaign.Category,
+		&campaign.Topic,
+		pq.Array(&campaign.Segment.Levels),
+		pq.Array(&campaign.Segment.Statuses),
+		&campaign.CreatedAt,
+		&campaign.UpdatedAt,
+		&campaign.LastSentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return campaign, nil
+}
+
+func (s *CampaignStorage) Get(ctx context.Context, id int) (*model.Campaign, error) {
+	query := `SELECT ` + campaignColumns + ` FROM campaigns WHERE id = $1`
+	campaign, err := scanCampaign(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return campaign, err
+}
+
+func (s *CampaignStorage) GetAll(ctx context.Context) ([]*model.Campaign, error) {
+	query := `SELECT ` + campaignColumns + ` FROM campaigns ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []*model.Campaign{}
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, campaign)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return campaigns, nil
+}
+
+func (s *CampaignStorage) Create(ctx context.Context, campaign *model.Campaign) (*model.Campaign, error) {
+	const query = `
+		INSERT INTO campaigns (
+		    name,
+		    subject,
+		    body,
+		    content_type,
+		    template_id,
+		    template_version,
+		    category,
+		    topic,
+		    segment_levels,
+		    segment_statuses
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		campaign.Name,
+		campaign.Subject,
+		campaign.Body,
+		campaign.ContentType,
+		campaign.TemplateID,
+		campaign.TemplateVersion,
+		campaign.Category,
+		campaign.Topic,
+		pq.Array(nonNil(campaign.Segment.Levels)),
+		pq.Array(nonNil(campaign.Segment.Statuses)),
+	).Scan(&campaign.ID, &campaign.CreatedAt, &campaign.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return campaign, nil
+}
+
+func (s *CampaignStorage) Update(ctx context.Context, campaign *model.Campaign, id int) error {
+	const query = `
+		UPDATE
+		    campaigns
+		SET
+		    name = $1,
+		    subject = $2,
+		    body = $3,
+		    content_type = $4,
+		    template_id = $5,
+		    template_version = $6,
+		    category = $7,
+		    topic = NULLIF($8, ''),
+		    segment_levels = $9,
+		    segment_statuses = $10,
+		    updated_at = NOW()
+		WHERE
+		    id = $11
+		RETURNING created_at, updated_at, last_sent_at
+	`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		campaign.Name,
+		campaign.Subject,
+		campaign.Body,
+		campaign.ContentType,
+		campaign.TemplateID,
+		campaign.TemplateVersion,
+		campaign.Category,
+		campaign.Topic,
+		pq.Array(nonNil(campaign.Segment.Levels)),
+		pq.Array(nonNil(campaign.Segment.Statuses)),
+		id,
+	).Scan(&campaign.CreatedAt, &campaign.UpdatedAt, &campaign.LastSentAt)
+	if err != nil {
+		return err
+	}
+
+	campaign.ID = id
+
+	return nil
+}
+
+func (s *CampaignStorage) Delete(ctx context.Context, id int) error {
+	const query = `DELETE FROM campaigns WHERE id = $1`
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *CampaignStorage) MarkSent(ctx context.Context, id int, sentAt time.Time) error {
+	const query = `UPDATE campaigns SET last_sent_at = $1 WHERE id = $2`
+	_, err := s.db.ExecContext(ctx, query, sentAt, id)
+
+	return err
+}
+
+// segmentQuery selects the users matching the segment ($1 levels, $2
+// statuses, see segmentStatuses) whose plan allows a campaign on topic $3: campaigns with a topic
+// only reach subscriptions whose plan includes it, and no subscription gets
+// more campaign mails in seven days than its plan allows. Users must also want
+// the preference topic $4: they have to be opted in, and with a daily or weekly
+// frequency must not have had a campaign on it in the last day or week.
+const segmentQuery = `
+	SELECT DISTINCT ON (LOWER(u.email))
+	    u.id,
+	    u.email
+	FROM
+	    subscribers s
+	    JOIN users u ON u.id = s.user_id
+	    LEFT JOIN plans p ON p.id = s.plan_id
+	    LEFT JOIN topics t ON t.key = $4
+	    LEFT JOIN user_topic_preferences tp ON tp.user_id = u.id AND tp.topic = $4
+	WHERE
+	    COALESCE(u.email, '') <> ''
+	    AND (CARDINALITY($1::TEXT[]) = 0 OR s.subscriptions_level = ANY($1))
+	    AND s.status_subscription = ANY($2)
+	    AND ($3::TEXT = '' OR $3::TEXT = ANY(p.topics))
+	    AND (
+	        COALESCE(p.max_mails_per_week, 0) = 0
+	        OR (
+	            SELECT COUNT(*)
+	            FROM
+	                mail_recipients r
+	                JOIN mails m ON m.id = r.mail_id
+	            WHERE
+	                m.campaign_id IS NOT NULL
+	                AND LOWER(r.address) = LOWER(u.email)
+	                AND r.status NOT IN ('suppressed', 'failed')
+	                AND r.created_at > NOW() - INTERVAL '7 days'
+	        ) < p.max_mails_per_week
+	    )
+	    AND COALESCE(tp.opted_in, t.default_opt_in, TRUE)
+	    AND (
+	        COALESCE(tp.frequency, 'immediate') = 'immediate'
+	        OR NOT EXISTS (
+	            SELECT 1
+	            FROM
+	                mail_recipients r
+	                JOIN mails m ON m.id = r.mail_id
+	                JOIN campaigns c ON c.id = m.campaign_id
+	            WHERE
+	                COALESCE(c.topic, c.category) = $4
+	                AND LOWER(r.address) = LOWER(u.email)
+	                AND r.status NOT IN ('suppressed', 'failed')
+	                AND r.created_at > NOW() - CASE tp.frequency WHEN 'daily' THEN INTERVAL '1 day' ELSE INTERVAL '7 days' END
+	        )
+	    )
+	ORDER BY LOWER(u.email), u.id
+`
+
+// segmentStatuses returns the subscription statuses a segment reaches. A
+// segment without statuses only reaches active subscriptions.
+func segmentStatuses(segment model.Segment) []string {
+	if len(segment.Statuses) == 0 {
+		return []string{model.SubscriberStatusActive}
+	}
+
+	return segment.Statuses
+}
+
+// ResolveSegment returns the users matching the segment who may and want to
+// receive a campaign on the topic, one entry per distinct email address.
+// Campaigns without a topic are matched against preferences for their
+// category.
+func (s *CampaignStorage) ResolveSegment(
+	ctx context.Context,
+	segment model.Segment,
+	topic, category string,
+) ([]model.SegmentRecipient, error) {
+	return s.querySegment(ctx, segmentQuery, segment, topic, category)
+}
+
+// PreviewSegment counts the users ResolveSegment would return and returns up to
+// sampleSize of them.
+func (s *CampaignStorage) PreviewSegment(
+	ctx context.Context,
+	segment model.Segment,
+	topic, category string,
+	sampleSize int,
+) (*model.SegmentPreview, error) {
+	preview := &model.SegmentPreview{}
+
+	countQuery := `SELECT COUNT(*) FROM (` + segmentQuery + `) AS segment`
+	err := s.db.QueryRowContext(
+		ctx,
+		countQuery,
+		pq.Array(nonNil(segment.Levels)),
+		pq.Array(segmentStatuses(segment)),
+		topic,
+		preferenceTopic(topic, category),
+	).Scan(&preview.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleQuery := `SELECT * FROM (` + segmentQuery + `) AS segment ORDER BY RANDOM() LIMIT $5`
+	preview.Sample, err = s.querySegment(ctx, sampleQuery, segment, topic, category, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+func (s *CampaignStorage) querySegment(
+	ctx context.Context,
+	query string,
+	segment model.Segment,
+	topic, category string,
+	args ...any,
+) ([]model.SegmentRecipient, error) {
+	args = append([]any{
+		pq.Array(nonNil(segment.Levels)),
+		pq.Array(segmentStatuses(segment)),
+		topic,
+		preferenceTopic(topic, category),
+	}, args...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []model.SegmentRecipient{}
+	for rows.Next() {
+		var recipient model.SegmentRecipient
+		if err := rows.Scan(&recipient.UserID, &recipient.Email); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, rows.Err()
+}
+
+// preferenceTopic is the topic whose preferences decide who gets a campaign.
+func preferenceTopic(topic, category string) string {
+	if topic != "" {
+		return topic
+	}
+
+	return category
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
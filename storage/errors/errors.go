@@ -1 +1,27 @@
 package errors
+
+import "errors"
+
+var (
+	ErrMailNotDead        = errors.New("mail is not dead-lettered")
+	ErrMailNotDraft       = errors.New("mail is not a draft")
+	ErrMailNoRecipients   = errors.New("mail has no recipients")
+	ErrScheduleStatus     = errors.New("schedule cannot change to the requested status")
+	ErrScheduleNextRun    = errors.New("next run of schedule cannot be computed")
+	ErrDuplicateContentID = errors.New("content id is already used by another attachment")
+	ErrMessageInUse       = errors.New("message is referenced by mails or campaigns")
+	ErrVersionCurrent     = errors.New("message version is already current")
+	ErrNotPending         = errors.New("subscription is no longer pending")
+	ErrAlreadySuppressed  = errors.New("address is already suppressed")
+	ErrDuplicatePlan      = errors.New("a plan with this name already exists")
+	ErrPlanInUse          = errors.New("plan is referenced by subscriptions")
+	ErrPlanNotFound       = errors.New("plan does not exist")
+	ErrDuplicateTopic     = errors.New("a topic with this key already exists")
+	ErrTopicInUse         = errors.New("topic is referenced by campaigns or plans")
+	ErrTopicNotFound      = errors.New("topic does not exist")
+	ErrUserNotFound       = errors.New("user does not exist")
+	ErrDuplicateLogin     = errors.New("a user with this login already exists")
+	ErrUserHasSubscribers = errors.New("user still has subscriptions")
+	ErrTokenReused        = errors.New("refresh token was already used")
+	ErrLeaseLost          = errors.New("outbox job is no longer claimed by this worker")
+)
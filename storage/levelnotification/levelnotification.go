@@ -0,0 +1,99 @@
+package levelnotification
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	"subscription-mailing-service/storage/postgres"
+)
+
+type LevelNotificationStorage struct {
+	db *sql.DB
+}
+
+func (s *LevelNotificationStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewLevelNotificationStorage(cfg *config.Config) (*LevelNotificationStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LevelNotificationStorage{db: db}, nil
+}
+
+const levelNotificationColumns = `
+	level,
+	template_id,
+	enabled,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLevelNotification(row scanner) (*model.LevelNotification, error) {
+	n := &model.LevelNotification{}
+	if err := row.Scan(&n.Level, &n.TemplateID, &n.Enabled, &n.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// Get returns the settings of the level, or nil when none are stored.
+func (s *LevelNotificationStorage) Get(ctx context.Context, level string) (*model.LevelNotification, error) {
+	query := `SELECT ` + levelNotificationColumns + ` FROM level_notifications WHERE level = $1`
+
+	n, err := scanLevelNotification(s.db.QueryRowContext(ctx, query, level))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return n, err
+}
+
+func (s *LevelNotificationStorage) GetAll(ctx context.Context) ([]*model.LevelNotification, error) {
+	query := `SELECT ` + levelNotificationColumns + ` FROM level_notifications ORDER BY level`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*model.LevelNotification{}
+	for rows.Next() {
+		n, err := scanLevelNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// Save stores the settings of a level, replacing earlier ones.
+func (s *LevelNotificationStorage) Save(ctx context.Context, n *model.LevelNotification) error {
+	const query = `
+		INSERT INTO level_notifications(level, template_id, enabled)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (level) DO UPDATE SET
+		    template_id = EXCLUDED.template_id,
+		    enabled = EXCLUDED.enabled,
+		    updated_at = NOW()
+		RETURNING updated_at
+	`
+
+	return s.db.QueryRowContext(ctx, query, n.Level, n.TemplateID, n.Enabled).Scan(&n.UpdatedAt)
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"github.com/lib/pq"
 	"subscription-mailing-service/internal/config"
 	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
 	"subscription-mailing-service/storage/postgres"
-	"time"
 )
 
+const uniqueViolation = "23505"
+
 type MailStorage struct {
 	db *sql.DB
 }
@@ -27,16 +30,57 @@ func NewMailStorage(cfg *config.Config) (*MailStorage, error) {
 	return &MailStorage{db: db}, nil
 }
 
-func (s *MailStorage) Get(ctx context.Context, id int) (*model.Mail, error) {
-	const query = `SELECT to_list, subject, body, content_type, sent_at FROM mails WHERE id = $1`
+const mailColumns = `
+	id,
+	to_list,
+	subject,
+	body,
+	content_type,
+	sent_at,
+	status,
+	attempts,
+	COALESCE(last_error, ''),
+	next_attempt_at,
+	campaign_id,
+	template_id,
+	template_version,
+	COALESCE(category, ''),
+	transactional
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMail(row scanner) (*model.Mail, error) {
 	mail := &model.Mail{}
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&mail.To,
+	err := row.Scan(
+		&mail.ID,
+		pq.Array(&mail.To),
 		&mail.Subject,
 		&mail.Body,
 		&mail.ContentType,
 		&mail.SentAt,
+		&mail.Status,
+		&mail.Attempts,
+		&mail.LastError,
+		&mail.NextAttemptAt,
+		&mail.CampaignID,
+		&mail.TemplateID,
+		&mail.TemplateVersion,
+		&mail.Category,
+		&mail.Transactional,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	return mail, nil
+}
+
+func (s *MailStorage) Get(ctx context.Context, id int) (*model.Mail, error) {
+	query := `SELECT ` + mailColumns + ` FROM mails WHERE id = $1`
+	mail, err := scanMail(s.db.QueryRowContext(ctx, query, id))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -45,13 +89,21 @@ func (s *MailStorage) Get(ctx context.Context, id int) (*model.Mail, error) {
 		return nil, err
 	}
 
-	mail.ID = id
 	return mail, nil
 }
 
 func (s *MailStorage) GetAll(ctx context.Context) ([]*model.Mail, error) {
-	const query = `SELECT to_list, subject, body, content_type, sent_at FROM mails`
-	rows, err := s.db.QueryContext(ctx, query)
+	query := `SELECT ` + mailColumns + ` FROM mails ORDER BY id`
+	return s.list(ctx, query)
+}
+
+func (s *MailStorage) GetByStatus(ctx context.Context, status string) ([]*model.Mail, error) {
+	query := `SELECT ` + mailColumns + ` FROM mails WHERE status = $1 ORDER BY id`
+	return s.list(ctx, query, status)
+}
+
+func (s *MailStorage) list(ctx context.Context, query string, args ...any) ([]*model.Mail, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -60,14 +112,8 @@ func (s *MailStorage) GetAll(ctx context.Context) ([]*model.Mail, error) {
 
 	mails := []*model.Mail{}
 	for rows.Next() {
-		mail := &model.Mail{}
-		if err := rows.Scan(
-			&mail.To,
-			&mail.Subject,
-			&mail.Body,
-			&mail.ContentType,
-			&mail.SentAt,
-		); err != nil {
+		mail, err := scanMail(rows)
+		if err != nil {
 			return nil, err
 		}
 		mails = append(mails, mail)
@@ -77,32 +123,37 @@ func (s *MailStorage) GetAll(ctx context.Context) ([]*model.Mail, error) {
 		return nil, err
 	}
 
-	return mails, rows.Err()
+	return mails, nil
 }
 
 func (s *MailStorage) Create(ctx context.Context, mail *model.Mail) (*model.Mail, error) {
 	const query = `
-		INSERT INTO mails(to_list, subject, body, content_type, sent_at)
-		VALUES ($1, $2, $3, $4, $5) 
-		        RETURNING id
+		INSERT INTO mails(to_list, subject, body, content_type, sent_at, template_id, template_version, category)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, (SELECT version FROM messages WHERE id = $6)), NULLIF($8, ''))
+		        RETURNING id, status, template_version
 		`
 
-	var id int
-	err := s.db.QueryRowContext(ctx, query, mail.To, mail.Subject, mail.Body, mail.ContentType, time.Now()).Scan(&id)
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		pq.Array(mail.To),
+		mail.Subject,
+		mail.Body,
+		mail.ContentType,
+		mail.SentAt,
+		mail.TemplateID,
+		mail.TemplateVersion,
+		mail.Category,
+	).Scan(&mail.ID, &mail.Status, &mail.TemplateVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	mail.ID = id
-
 	return mail, nil
 }
 
-// TODO сделать отправку
-//func (s *MailStorage) Send() {
-//
-//}
-
+// Update overwrites a draft. It returns sql.ErrNoRows when the mail does not
+// exist and storageErrors.ErrMailNotDraft when it has already been queued.
 func (s *MailStorage) Update(ctx context.Context, mail *model.Mail, id int) error {
 	const query = `
 		UPDATE
@@ -112,11 +163,47 @@ func (s *MailStorage) Update(ctx context.Context, mail *model.Mail, id int) erro
 		    subject = $2,
 		    body = $3,
 		    content_type = $4, 
-		    sent_at = $5
+		    sent_at = $5,
+		    template_id = $6,
+		    template_version = COALESCE($7, (SELECT version FROM messages WHERE id = $6)),
+		    category = NULLIF($8, '')
 		WHERE 
-		    id =$6`
+		    id =$9 AND status = $10
+		RETURNING template_version`
 
-	result, err := s.db.ExecContext(ctx, query, mail.To, mail.Subject, mail.Body, mail.ContentType, mail.SentAt, id)
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		pq.Array(mail.To),
+		mail.Subject,
+		mail.Body,
+		mail.ContentType,
+		mail.SentAt,
+		mail.TemplateID,
+		mail.TemplateVersion,
+		mail.Category,
+		id,
+		model.MailStatusDraft,
+	).Scan(&mail.TemplateVersion)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	existing, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		return sql.ErrNoRows
+	}
+
+	return storageErrors.ErrMailNotDraft
+}
+
+func (s *MailStorage) Delete(ctx context.Context, id int) error {
+	const query = `DELETE FROM mails WHERE id = $1`
+	result, err := s.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -133,9 +220,104 @@ func (s *MailStorage) Update(ctx context.Context, mail *model.Mail, id int) erro
 	return nil
 }
 
-func (s *MailStorage) Delete(ctx context.Context, id int) error {
-	const query = `DELETE FROM mails WHERE id = $1`
-	result, err := s.db.ExecContext(ctx, query, id)
+// AddAttachment stores a file with the mail. Content IDs are unique per mail;
+// reusing one yields ErrDuplicateContentID.
+func (s *MailStorage) AddAttachment(ctx context.Context, attachment *model.Attachment) (*model.Attachment, error) {
+	const query = `
+		INSERT INTO mail_attachments (mail_id, filename, content_type, content_id, size, data)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		RETURNING id, created_at
+	`
+
+	attachment.Size = int64(len(attachment.Data))
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		attachment.MailID,
+		attachment.Filename,
+		attachment.ContentType,
+		attachment.ContentID,
+		attachment.Size,
+		attachment.Data,
+	).Scan(&attachment.ID, &attachment.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return nil, storageErrors.ErrDuplicateContentID
+		}
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// GetAttachments returns the attachments of the mail including their content.
+func (s *MailStorage) GetAttachments(ctx context.Context, mailID int) ([]*model.Attachment, error) {
+	return s.attachments(ctx, mailID, true)
+}
+
+// ListAttachments returns the attachments of the mail without their content.
+func (s *MailStorage) ListAttachments(ctx context.Context, mailID int) ([]*model.Attachment, error) {
+	return s.attachments(ctx, mailID, false)
+}
+
+func (s *MailStorage) attachments(ctx context.Context, mailID int, withData bool) ([]*model.Attachment, error) {
+	const query = `
+		SELECT
+		    id,
+		    mail_id,
+		    filename,
+		    content_type,
+		    COALESCE(content_id, ''),
+		    size,
+		    CASE WHEN $2 THEN data END,
+		    created_at
+		FROM
+		    mail_attachments
+		WHERE
+		    mail_id = $1
+		ORDER BY id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, mailID, withData)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []*model.Attachment{}
+	for rows.Next() {
+		attachment := &model.Attachment{}
+		if err := rows.Scan(
+			&attachment.ID,
+			&attachment.MailID,
+			&attachment.Filename,
+			&attachment.ContentType,
+			&attachment.ContentID,
+			&attachment.Size,
+			&attachment.Data,
+			&attachment.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+// AttachmentsSize returns the total size of the files attached to the mail.
+func (s *MailStorage) AttachmentsSize(ctx context.Context, mailID int) (int64, error) {
+	const query = `SELECT COALESCE(SUM(size), 0) FROM mail_attachments WHERE mail_id = $1`
+	var size int64
+	err := s.db.QueryRowContext(ctx, query, mailID).Scan(&size)
+
+	return size, err
+}
+
+func (s *MailStorage) DeleteAttachment(ctx context.Context, mailID int, id int) error {
+	const query = `DELETE FROM mail_attachments WHERE mail_id = $1 AND id = $2`
+	result, err := s.db.ExecContext(ctx, query, mailID, id)
 	if err != nil {
 		return err
 	}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"github.com/lib/pq"
 	"subscription-mailing-service/internal/config"
 	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
 	"subscription-mailing-service/storage/postgres"
 )
 
+const foreignKeyViolation = "23503"
+
 type MessageStorage struct {
 	db *sql.DB
 }
@@ -26,89 +30,294 @@ func NewMessageStorage(cfg *config.Config) (*MessageStorage, error) {
 	return &MessageStorage{db: db}, nil
 }
 
-func (s *MessageStorage) Get(ctx context.Context, id int) (*model.Message, error) {
-	const query = `SELECT message FROM messages WHERE id = $1`
+const messageColumns = `
+	id,
+	name,
+	subject,
+	html_body,
+	text_body,
+	version,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row scanner) (*model.Message, error) {
 	message := &model.Message{}
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&message.Message,
+	err := row.Scan(
+		&message.ID,
+		&message.Name,
+		&message.Subject,
+		&message.HTMLBody,
+		&message.TextBody,
+		&message.Version,
+		&message.CreatedAt,
+		&message.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (s *MessageStorage) Get(ctx context.Context, id int) (*model.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE id = $1`
+	message, err := scanMessage(s.db.QueryRowContext(ctx, query, id))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
 
-	message.ID = id
 	return message, err
 }
 
 func (s *MessageStorage) GetAll(ctx context.Context) ([]*model.Message, error) {
-	const query = `SELECT id, message FROM messages`
+	query := `SELECT ` + messageColumns + ` FROM messages ORDER BY id`
 	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []*model.Message
+	messages := []*model.Message{}
 	for rows.Next() {
-		message := &model.Message{}
-		if err := rows.Scan(
-			&message.ID,
-			&message.Message,
-		); err != nil {
+		message, err := scanMessage(rows)
+		if err != nil {
 			return nil, err
 		}
 		messages = append(messages, message)
 	}
+
 	return messages, rows.Err()
 }
 
+// Create stores a new message together with its first version.
 func (s *MessageStorage) Create(ctx context.Context, message *model.Message) (*model.Message, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	const query = `
-       INSERT INTO messages (message)
-       VALUES ($1)
-       RETURNING id
-   `
+		INSERT INTO messages (name, subject, html_body, text_body, version)
+		VALUES ($1, $2, $3, $4, 1)
+		RETURNING id, version, created_at, updated_at
+	`
 
-	var id int
-	err := s.db.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
 		query,
-		message.Message,
-	).Scan(&id)
-
+		message.Name,
+		message.Subject,
+		message.HTMLBody,
+		message.TextBody,
+	).Scan(&message.ID, &message.Version, &message.CreatedAt, &message.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 
-	message.ID = id
+	if err := insertVersion(ctx, tx, message); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return message, nil
 }
 
+// Update stores the message content as its next version. Earlier versions are
+// kept unchanged.
 func (s *MessageStorage) Update(ctx context.Context, message *model.Message, id int) error {
-	const query = `UPDATE messages SET message = $1 WHERE id = $2`
-	result, err := s.db.ExecContext(ctx, query, message.Message, id)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	message.ID = id
+	if err := updateMessage(ctx, tx, message); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Rollback makes the content of an earlier version current again by storing it
+// as a new version, so the history is never rewritten. It returns
+// sql.ErrNoRows when the message or version does not exist and
+// ErrVersionCurrent when the version is the current one.
+func (s *MessageStorage) Rollback(ctx context.Context, id int, version int) (*model.Message, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.QueryRowContext(ctx, `SELECT version FROM messages WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == version {
+		return nil, storageErrors.ErrVersionCurrent
+	}
+
+	query := `SELECT ` + versionColumns + ` FROM message_versions WHERE message_id = $1 AND version = $2`
+	target, err := scanVersion(tx.QueryRowContext(ctx, query, id, version))
+	if err != nil {
+		return nil, err
+	}
+
+	message := &model.Message{
+		ID:       id,
+		Name:     target.Name,
+		Subject:  target.Subject,
+		HTMLBody: target.HTMLBody,
+		TextBody: target.TextBody,
+	}
+	if err := updateMessage(ctx, tx, message); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func updateMessage(ctx context.Context, tx *sql.Tx, message *model.Message) error {
+	const query = `
+		UPDATE
+		    messages
+		SET
+		    name = $1,
+		    subject = $2,
+		    html_body = $3,
+		    text_body = $4,
+		    version = version + 1,
+		    updated_at = NOW()
+		WHERE
+		    id = $5
+		RETURNING version, created_at, updated_at
+	`
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		message.Name,
+		message.Subject,
+		message.HTMLBody,
+		message.TextBody,
+		message.ID,
+	).Scan(&message.Version, &message.CreatedAt, &message.UpdatedAt)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	return insertVersion(ctx, tx, message)
+}
+
+func insertVersion(ctx context.Context, tx *sql.Tx, message *model.Message) error {
+	const query = `
+		INSERT INTO message_versions (message_id, version, name, subject, html_body, text_body, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := tx.ExecContext(
+		ctx,
+		query,
+		message.ID,
+		message.Version,
+		message.Name,
+		message.Subject,
+		message.HTMLBody,
+		message.TextBody,
+		message.UpdatedAt,
+	)
+
+	return err
+}
+
+const versionColumns = `
+	id,
+	message_id,
+	version,
+	name,
+	subject,
+	html_body,
+	text_body,
+	created_at
+`
+
+func scanVersion(row scanner) (*model.MessageVersion, error) {
+	version := &model.MessageVersion{}
+	err := row.Scan(
+		&version.ID,
+		&version.MessageID,
+		&version.Version,
+		&version.Name,
+		&version.Subject,
+		&version.HTMLBody,
+		&version.TextBody,
+		&version.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	message.ID = id
+	return version, nil
+}
 
-	return nil
+// GetVersion returns one version of a message, or nil when it does not exist.
+func (s *MessageStorage) GetVersion(ctx context.Context, id int, version int) (*model.MessageVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM message_versions WHERE message_id = $1 AND version = $2`
+	v, err := scanVersion(s.db.QueryRowContext(ctx, query, id, version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return v, err
+}
+
+// GetVersions returns every version of a message, newest first.
+func (s *MessageStorage) GetVersions(ctx context.Context, id int) ([]*model.MessageVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM message_versions WHERE message_id = $1 ORDER BY version DESC`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []*model.MessageVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
 }
 
+// Delete removes a message. Messages still referenced by mails or campaigns
+// cannot be deleted and yield ErrMessageInUse.
 func (s *MessageStorage) Delete(ctx context.Context, id int) error {
 	const query = `DELETE FROM messages WHERE id = $1`
 	result, err := s.db.ExecContext(ctx, query, id)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+			return storageErrors.ErrMessageInUse
+		}
 		return err
 	}
 
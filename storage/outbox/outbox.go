@@ -0,0 +1,761 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/postgres"
+	"time"
+)
+
+type OutboxStorage struct {
+	db *sql.DB
+}
+
+func (s *OutboxStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewOutboxStorage(cfg *config.Config) (*OutboxStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxStorage{db: db}, nil
+}
+
+// Enqueue stores the mail, one delivery record per address and the outbox job
+// in a single transaction. Recipients are linked to the user owning the address
+// when there is one.
+func (s *OutboxStorage) Enqueue(ctx context.Context, mail *model.Mail) (*model.OutboxJob, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	const insertMail = `
+		INSERT INTO mails(to_list, subject, body, content_type, status, campaign_id, template_id, template_version, category, transactional)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8, (SELECT version FROM messages WHERE id = $7)), NULLIF($9, ''), $10)
+		RETURNING id, template_version
+	`
+
+	if err := tx.QueryRowContext(
+		ctx,
+		insertMail,
+		pq.Array(mail.To),
+		mail.Subject,
+		mail.Body,
+		mail.ContentType,
+		model.MailStatusQueued,
+		mail.CampaignID,
+		mail.TemplateID,
+		mail.TemplateVersion,
+		mail.Category,
+		mail.Transactional,
+	).Scan(&mail.ID, &mail.TemplateVersion); err != nil {
+		return nil, err
+	}
+	mail.SentAt = nil
+	mail.Status = model.MailStatusQueued
+
+	job, err := enqueue(ctx, tx, mail)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// EnqueueDraft queues a stored draft, together with the attachments uploaded
+// for it. It returns sql.ErrNoRows when the mail does not exist and
+// ErrMailNotDraft when it has already been queued.
+func (s *OutboxStorage) EnqueueDraft(ctx context.Context, mailID int) (*model.OutboxJob, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM mails WHERE id = $1 FOR UPDATE`, mailID).Scan(&status)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != model.MailStatusDraft {
+		return nil, storageErrors.ErrMailNotDraft
+	}
+
+	const queueMail = `
+		UPDATE
+		    mails
+		SET
+		    status = $1,
+		    sent_at = NULL,
+		    template_version = COALESCE(template_version, (SELECT version FROM messages WHERE id = template_id))
+		WHERE
+		    id = $2
+		RETURNING
+		    to_list,
+		    subject,
+		    body,
+		    COALESCE(content_type, ''),
+		    campaign_id,
+		    template_id,
+		    template_version,
+		    COALESCE(category, ''),
+		    transactional
+	`
+
+	mail := &model.Mail{ID: mailID, Status: model.MailStatusQueued}
+	err = tx.QueryRowContext(ctx, queueMail, model.MailStatusQueued, mailID).Scan(
+		pq.Array(&mail.To),
+		&mail.Subject,
+		&mail.Body,
+		&mail.ContentType,
+		&mail.CampaignID,
+		&mail.TemplateID,
+		&mail.TemplateVersion,
+		&mail.Category,
+		&mail.Transactional,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mail.To) == 0 {
+		return nil, storageErrors.ErrMailNoRecipients
+	}
+
+	job, err := enqueue(ctx, tx, mail)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// enqueue stores one delivery record per address of the mail and its outbox
+// job.
+func enqueue(ctx context.Context, tx *sql.Tx, mail *model.Mail) (*model.OutboxJob, error) {
+	// Suppressed addresses are recorded as recipients too, so that the mail
+	// shows who was left out and why. Unsubscribing does not hold back
+	// transactional mails.
+	const insertRecipients = `
+		INSERT INTO mail_recipients(mail_id, user_id, address, status, error)
+		SELECT
+		    $1,
+		    (SELECT id FROM users WHERE LOWER(email) = LOWER(addr) ORDER BY id LIMIT 1),
+		    addr,
+		    CASE WHEN s.reason IS NULL THEN $3 ELSE $4 END,
+		    s.reason
+		FROM UNNEST($2::TEXT[]) AS addr
+		LEFT JOIN suppressions s ON s.address = LOWER(TRIM(addr)) AND NOT ($5::BOOLEAN AND s.reason = $6)
+		ON CONFLICT (mail_id, address) DO NOTHING
+		RETURNING address, status, COALESCE(error, '')
+	`
+
+	rows, err := tx.QueryContext(
+		ctx,
+		insertRecipients,
+		mail.ID,
+		pq.Array(mail.To),
+		model.RecipientStatusPending,
+		model.RecipientStatusSuppressed,
+		mail.Transactional,
+		model.SuppressionReasonUnsubscribed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var skipped []model.SkippedRecipient
+	for rows.Next() {
+		var address, status, reason string
+		if err := rows.Scan(&address, &status, &reason); err != nil {
+			return nil, err
+		}
+		if status == model.RecipientStatusSuppressed {
+			skipped = append(skipped, model.SkippedRecipient{Address: address, Reason: reason})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	const insertJob = `
+		INSERT INTO mail_outbox(mail_id, status)
+		VALUES ($1, $2)
+		RETURNING id, mail_id, status, available_at, created_at, updated_at
+	`
+
+	job, err := scanJob(tx.QueryRowContext(ctx, insertJob, mail.ID, model.OutboxStatusQueued))
+	if err != nil {
+		return nil, err
+	}
+	job.Mail = mail
+	job.Skipped = skipped
+
+	return job, nil
+}
+
+// Claim locks the next due job for the calling worker. Jobs left in processing
+// for longer than lockTimeout are considered abandoned and are claimed again.
+// It returns nil when there is nothing to do.
+func (s *OutboxStorage) Claim(ctx context.Context, lockTimeout time.Duration) (*model.OutboxJob, error) {
+	const query = `
+		UPDATE
+		    mail_outbox
+		SET
+		    status = $1,
+		    locked_at = NOW(),
+		    claim_token = gen_random_uuid()::TEXT,
+		    updated_at = NOW()
+		WHERE id = (
+		    SELECT id
+		    FROM mail_outbox
+		    WHERE (status = $2 AND available_at <= NOW())
+		       OR (status = $1 AND locked_at < $3)
+		    ORDER BY available_at, id
+		    LIMIT 1
+		    FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, mail_id, status, available_at, created_at, updated_at, claim_token
+	`
+
+	job := &model.OutboxJob{}
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		model.OutboxStatusProcessing,
+		model.OutboxStatusQueued,
+		time.Now().Add(-lockTimeout),
+	).Scan(
+		&job.ID,
+		&job.MailID,
+		&job.Status,
+		&job.AvailableAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.ClaimToken,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// RenewLease extends the claim on a job that is still being worked on. It
+// returns storageErrors.ErrLeaseLost when the lease has already run out and
+// another worker has claimed the job.
+func (s *OutboxStorage) RenewLease(ctx context.Context, job *model.OutboxJob) error {
+	return renewLease(ctx, s.db, job)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func renewLease(ctx context.Context, db execer, job *model.OutboxJob) error {
+	const query = `
+		UPDATE
+		    mail_outbox
+		SET
+		    locked_at = NOW()
+		WHERE
+		    id = $1 AND claim_token = $2 AND status = $3
+	`
+
+	res, err := db.ExecContext(ctx, query, job.ID, job.ClaimToken, model.OutboxStatusProcessing)
+	if err != nil {
+		return err
+	}
+
+	return leaseHeld(res)
+}
+
+func leaseHeld(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storageErrors.ErrLeaseLost
+	}
+
+	return nil
+}
+
+// Complete records the outcome of a delivery pass for a claimed job together
+// with the resulting state of its mail. A nil mail only releases the job, for
+// when the mail itself could not be loaded. Nothing is written and
+// storageErrors.ErrLeaseLost is returned when the worker no longer holds the
+// job.
+func (s *OutboxStorage) Complete(ctx context.Context, job *model.OutboxJob, mail *model.Mail) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const updateJob = `
+		UPDATE
+		    mail_outbox
+		SET
+		    status = $1,
+		    available_at = $2,
+		    locked_at = NULL,
+		    claim_token = NULL,
+		    updated_at = NOW()
+		WHERE
+		    id = $3 AND claim_token = $4 AND status = $5
+	`
+
+	res, err := tx.ExecContext(
+		ctx,
+		updateJob,
+		job.Status,
+		job.AvailableAt,
+		job.ID,
+		job.ClaimToken,
+		model.OutboxStatusProcessing,
+	)
+	if err != nil {
+		return err
+	}
+	if err := leaseHeld(res); err != nil {
+		return err
+	}
+
+	if mail == nil {
+		return tx.Commit()
+	}
+
+	const updateMail = `
+		UPDATE
+		    mails
+		SET
+		    status = $1,
+		    attempts = $2,
+		    last_error = NULLIF($3, ''),
+		    next_attempt_at = $4,
+		    sent_at = $5
+		WHERE
+		    id = $6
+	`
+
+	if _, err := tx.ExecContext(
+		ctx,
+		updateMail,
+		mail.Status,
+		mail.Attempts,
+		mail.LastError,
+		mail.NextAttemptAt,
+		mail.SentAt,
+		mail.ID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Requeue moves a dead-lettered mail back into the queue. Only recipients that
+// have not received it yet are attempted again.
+func (s *OutboxStorage) Requeue(ctx context.Context, mailID int) (*model.OutboxJob, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM mails WHERE id = $1 FOR UPDATE`, mailID).Scan(&status)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != model.MailStatusDead {
+		return nil, storageErrors.ErrMailNotDead
+	}
+
+	const resetMail = `
+		UPDATE
+		    mails
+		SET
+		    status = $1,
+		    attempts = 0,
+		    last_error = NULL,
+		    next_attempt_at = NULL
+		WHERE
+		    id = $2
+	`
+
+	if _, err := tx.ExecContext(ctx, resetMail, model.MailStatusQueued, mailID); err != nil {
+		return nil, err
+	}
+
+	const resetRecipients = `
+		UPDATE
+		    mail_recipients
+		SET
+		    status = $1,
+		    error = NULL,
+		    updated_at = NOW()
+		WHERE
+		    mail_id = $2 AND status IN ($3, $4)
+	`
+
+	if _, err := tx.ExecContext(
+		ctx,
+		resetRecipients,
+		model.RecipientStatusPending,
+		mailID,
+		model.RecipientStatusFailed,
+		model.RecipientStatusDeferred,
+	); err != nil {
+		return nil, err
+	}
+
+	const resetJob = `
+		UPDATE
+		    mail_outbox
+		SET
+		    status = $1,
+		    available_at = NOW(),
+		    locked_at = NULL,
+		    updated_at = NOW()
+		WHERE id = (
+		    SELECT id FROM mail_outbox WHERE mail_id = $2 ORDER BY id DESC LIMIT 1
+		)
+		RETURNING id, mail_id, status, available_at, created_at, updated_at
+	`
+
+	job, err := scanJob(tx.QueryRowContext(ctx, resetJob, model.OutboxStatusQueued, mailID))
+	if errors.Is(err, sql.ErrNoRows) {
+		const insertJob = `
+			INSERT INTO mail_outbox(mail_id, status)
+			VALUES ($1, $2)
+			RETURNING id, mail_id, status, available_at, created_at, updated_at
+		`
+		job, err = scanJob(tx.QueryRowContext(ctx, insertJob, mailID, model.OutboxStatusQueued))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (s *OutboxStorage) GetByMail(ctx context.Context, mailID int) (*model.OutboxJob, error) {
+	const query = `
+		SELECT
+		    id,
+		    mail_id,
+		    status,
+		    available_at,
+		    created_at,
+		    updated_at
+		FROM
+		    mail_outbox
+		WHERE
+		    mail_id = $1
+		ORDER BY id DESC
+		LIMIT 1
+	`
+
+	job, err := scanJob(s.db.QueryRowContext(ctx, query, mailID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return job, err
+}
+
+const recipientColumns = `
+	id,
+	mail_id,
+	user_id,
+	address,
+	status,
+	COALESCE(message_id, ''),
+	COALESCE(error, ''),
+	attempts,
+	created_at,
+	updated_at,
+	sent_at
+`
+
+// GetRecipients lists the delivery records of a mail, optionally restricted to
+// the given statuses.
+func (s *OutboxStorage) GetRecipients(ctx context.Context, mailID int, statuses ...string) ([]*model.MailRecipient, error) {
+	query := `
+		SELECT ` + recipientColumns + `
+		FROM
+		    mail_recipients
+		WHERE
+		    mail_id = $1 AND (CARDINALITY($2::TEXT[]) = 0 OR status = ANY($2))
+		ORDER BY id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, mailID, pq.Array(statuses))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []*model.MailRecipient{}
+	for rows.Next() {
+		recipient, err := scanRecipient(rows)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return recipients, nil
+}
+
+// SuppressRecipients marks the recipients of a mail still waiting for delivery
+// whose address has been suppressed since the mail was queued, and returns
+// them. As when queueing, unsubscribes do not apply to transactional mails.
+func (s *OutboxStorage) SuppressRecipients(ctx context.Context, mailID int) ([]model.SkippedRecipient, error) {
+	const query = `
+		UPDATE
+		    mail_recipients r
+		SET
+		    status = $1,
+		    error = s.reason,
+		    updated_at = NOW()
+		FROM
+		    suppressions s,
+		    mails m
+		WHERE
+		    r.mail_id = $2
+		    AND r.status = ANY($3)
+		    AND s.address = LOWER(TRIM(r.address))
+		    AND m.id = r.mail_id
+		    AND NOT (m.transactional AND s.reason = $4)
+		RETURNING r.address, s.reason
+	`
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		query,
+		model.RecipientStatusSuppressed,
+		mailID,
+		pq.Array([]string{model.RecipientStatusPending, model.RecipientStatusDeferred}),
+		model.SuppressionReasonUnsubscribed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var skipped []model.SkippedRecipient
+	for rows.Next() {
+		var recipient model.SkippedRecipient
+		if err := rows.Scan(&recipient.Address, &recipient.Reason); err != nil {
+			return nil, err
+		}
+		skipped = append(skipped, recipient)
+	}
+
+	return skipped, rows.Err()
+}
+
+// GetRecipient returns a single delivery record, or nil when it does not exist.
+func (s *OutboxStorage) GetRecipient(ctx context.Context, id int) (*model.MailRecipient, error) {
+	query := `SELECT ` + recipientColumns + ` FROM mail_recipients WHERE id = $1`
+
+	recipient, err := scanRecipient(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return recipient, err
+}
+
+// MarkSending renews the lease on job and moves a pending or deferred
+// recipient to sending right before the message goes out, so that a worker
+// reclaiming the job later does not send it a second time. It reports false
+// when the recipient is no longer waiting for delivery, and returns
+// storageErrors.ErrLeaseLost when the job has been claimed by someone else.
+func (s *OutboxStorage) MarkSending(ctx context.Context, job *model.OutboxJob, recipient *model.MailRecipient) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := renewLease(ctx, tx, job); err != nil {
+		return false, err
+	}
+
+	const query = `
+		UPDATE
+		    mail_recipients
+		SET
+		    status = $1,
+		    attempts = attempts + 1,
+		    updated_at = NOW()
+		WHERE
+		    id = $2 AND mail_id = $3 AND status = ANY($4)
+		RETURNING status, attempts, updated_at
+	`
+
+	err = tx.QueryRowContext(
+		ctx,
+		query,
+		model.RecipientStatusSending,
+		recipient.ID,
+		job.MailID,
+		pq.Array([]string{model.RecipientStatusPending, model.RecipientStatusDeferred}),
+	).Scan(&recipient.Status, &recipient.Attempts, &recipient.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// UpdateRecipient persists the outcome of a single delivery attempt made under
+// the claim on job. It returns storageErrors.ErrLeaseLost, and writes nothing,
+// when the job has been claimed by someone else in the meantime.
+func (s *OutboxStorage) UpdateRecipient(ctx context.Context, job *model.OutboxJob, recipient *model.MailRecipient) error {
+	const query = `
+		UPDATE
+		    mail_recipients r
+		SET
+		    status = $1,
+		    message_id = NULLIF($2, ''),
+		    error = NULLIF($3, ''),
+		    attempts = $4,
+		    sent_at = $5,
+		    updated_at = NOW()
+		FROM
+		    mail_outbox o
+		WHERE
+		    r.id = $6
+		    AND o.id = $7
+		    AND o.mail_id = r.mail_id
+		    AND o.claim_token = $8
+		    AND o.status = $9
+		RETURNING r.updated_at
+	`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		recipient.Status,
+		recipient.MessageID,
+		recipient.Error,
+		recipient.Attempts,
+		recipient.SentAt,
+		recipient.ID,
+		job.ID,
+		job.ClaimToken,
+		model.OutboxStatusProcessing,
+	).Scan(&recipient.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storageErrors.ErrLeaseLost
+	}
+
+	return err
+}
+
+// CountRecipients returns the number of delivery records of a mail per status.
+func (s *OutboxStorage) CountRecipients(ctx context.Context, mailID int) (map[string]int, error) {
+	const query = `SELECT status, COUNT(*) FROM mail_recipients WHERE mail_id = $1 GROUP BY status`
+	rows, err := s.db.QueryContext(ctx, query, mailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var (
+			status string
+			count  int
+		)
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row scanner) (*model.OutboxJob, error) {
+	job := &model.OutboxJob{}
+	if err := row.Scan(
+		&job.ID,
+		&job.MailID,
+		&job.Status,
+		&job.AvailableAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func scanRecipient(row scanner) (*model.MailRecipient, error) {
+	recipient := &model.MailRecipient{}
+	if err := row.Scan(
+		&recipient.ID,
+		&recipient.MailID,
+		&recipient.UserID,
+		&recipient.Address,
+		&recipient.Status,
+		&recipient.MessageID,
+		&recipient.Error,
+		&recipient.Attempts,
+		&recipient.CreatedAt,
+		&recipient.UpdatedAt,
+		&recipient.SentAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return recipient, nil
+}
@@ -0,0 +1,111 @@
+package passwordreset
+
+import (
+	"context"
+	"database/sql"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/storage/postgres"
+	"time"
+)
+
+// PasswordResetStorage keeps reset tokens by the hash of their value. Rows
+// stay after use so they can be counted for rate limiting.
+type PasswordResetStorage struct {
+	db *sql.DB
+}
+
+func (s *PasswordResetStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewPasswordResetStorage(cfg *config.Config) (*PasswordResetStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasswordResetStorage{db: db}, nil
+}
+
+// Create stores a reset token of the user, valid for ttl, requested for the
+// address, unless the address has had limit tokens within the window already.
+// It reports whether the token was stored. Requests for the same address are
+// serialized, so concurrent ones cannot exceed the limit together.
+func (s *PasswordResetStorage) Create(
+	ctx context.Context,
+	userID int,
+	email, hash string,
+	ttl, window time.Duration,
+	limit int,
+) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('password_reset:' || LOWER($1)))`, email); err != nil {
+		return false, err
+	}
+
+	const query = `
+		INSERT INTO password_resets (user_id, email, token_hash, expires_at)
+		SELECT $1, LOWER($2), $3, NOW() + make_interval(secs => $4)
+		WHERE (
+		    SELECT COUNT(*)
+		    FROM password_resets
+		    WHERE
+		        email = LOWER($2)
+		        AND created_at > NOW() - make_interval(secs => $5)
+		) < $6
+	`
+
+	res, err := tx.ExecContext(ctx, query, userID, email, hash, ttl.Seconds(), window.Seconds(), limit)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, tx.Commit()
+}
+
+// Consume uses up the token with hash and every other outstanding token of
+// its user, and returns the user. Unknown, used and expired tokens yield
+// sql.ErrNoRows.
+func (s *PasswordResetStorage) Consume(ctx context.Context, hash string) (int, error) {
+	const consumeQuery = `
+		UPDATE password_resets
+		SET used_at = NOW()
+		WHERE
+		    token_hash = $1
+		    AND used_at IS NULL
+		    AND expires_at > NOW()
+		RETURNING user_id
+	`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	if err := tx.QueryRowContext(ctx, consumeQuery, hash).Scan(&userID); err != nil {
+		return 0, err
+	}
+
+	const othersQuery = `UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`
+	if _, err := tx.ExecContext(ctx, othersQuery, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,203 @@
+package plan
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/postgres"
+)
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+type PlanStorage struct {
+	db *sql.DB
+}
+
+func (s *PlanStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewPlanStorage(cfg *config.Config) (*PlanStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PlanStorage{db: db}, nil
+}
+
+const planColumns = `
+	id,
+	name,
+	period_days,
+	price_cents,
+	topics,
+	max_mails_per_week,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPlan(row scanner) (*model.Plan, error) {
+	plan := &model.Plan{}
+	err := row.Scan(
+		&plan.ID,
+		&plan.Name,
+		&plan.PeriodDays,
+		&plan.PriceCents,
+		pq.Array(&plan.Topics),
+		&plan.MaxMailsPerWeek,
+		&plan.CreatedAt,
+		&plan.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func (s *PlanStorage) Get(ctx context.Context, id int) (*model.Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans WHERE id = $1`
+	plan, err := scanPlan(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return plan, err
+}
+
+func (s *PlanStorage) GetAll(ctx context.Context) ([]*model.Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans ORDER BY price_cents, id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []*model.Plan{}
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return plans, nil
+}
+
+// Create stores a new plan. Plan names are unique; a taken name yields
+// ErrDuplicatePlan.
+func (s *PlanStorage) Create(ctx context.Context, plan *model.Plan) error {
+	const query = `
+		INSERT INTO plans (name, period_days, price_cents, topics, max_mails_per_week)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		plan.Name,
+		plan.PeriodDays,
+		plan.PriceCents,
+		pq.Array(nonNil(plan.Topics)),
+		plan.MaxMailsPerWeek,
+	).Scan(&plan.ID, &plan.CreatedAt, &plan.UpdatedAt)
+
+	return planError(err)
+}
+
+// Update replaces the plan. Subscriptions on the plan keep their current
+// period; the new one applies from their next renewal.
+func (s *PlanStorage) Update(ctx context.Context, plan *model.Plan, id int) error {
+	const query = `
+		UPDATE
+		    plans
+		SET
+		    name = $1,
+		    period_days = $2,
+		    price_cents = $3,
+		    topics = $4,
+		    max_mails_per_week = $5,
+		    updated_at = NOW()
+		WHERE
+		    id = $6
+		RETURNING created_at, updated_at
+	`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		plan.Name,
+		plan.PeriodDays,
+		plan.PriceCents,
+		pq.Array(nonNil(plan.Topics)),
+		plan.MaxMailsPerWeek,
+		id,
+	).Scan(&plan.CreatedAt, &plan.UpdatedAt)
+	if err != nil {
+		return planError(err)
+	}
+
+	plan.ID = id
+
+	return nil
+}
+
+// Delete removes a plan. Plans subscriptions still refer to cannot be deleted
+// and yield ErrPlanInUse.
+func (s *PlanStorage) Delete(ctx context.Context, id int) error {
+	const query = `DELETE FROM plans WHERE id = $1`
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+			return storageErrors.ErrPlanInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func planError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return storageErrors.ErrDuplicatePlan
+	}
+
+	return err
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
@@ -0,0 +1,165 @@
+package refreshtoken
+
+import (
+	"context"
+	"database/sql"
+	"subscription-mailing-service/internal/config"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/postgres"
+	"time"
+)
+
+// RefreshTokenStorage keeps refresh tokens by the hash of their value, so a
+// leaked table does not hand out sessions.
+type RefreshTokenStorage struct {
+	db *sql.DB
+}
+
+func (s *RefreshTokenStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewRefreshTokenStorage(cfg *config.Config) (*RefreshTokenStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefreshTokenStorage{db: db}, nil
+}
+
+// Create stores a new refresh token of the user, valid for ttl, and drops the user's tokens
+// that can no longer be used.
+func (s *RefreshTokenStorage) Create(ctx context.Context, userID int, hash string, ttl time.Duration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const pruneQuery = `DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at <= NOW()`
+	if _, err := tx.ExecContext(ctx, pruneQuery, userID); err != nil {
+		return err
+	}
+
+	if _, err := insert(ctx, tx, userID, hash, ttl); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Rotate replaces the token with hash by a new one valid for ttl and returns the user it
+// belongs to. Unknown, expired and revoked tokens yield sql.ErrNoRows. A
+// token that was already rotated is a sign of theft: every token of its user
+// is revoked and ErrTokenReused returned.
+func (s *RefreshTokenStorage) Rotate(ctx context.Context, hash, newHash string, ttl time.Duration) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const lockQuery = `
+		SELECT id, user_id, expires_at <= NOW(), revoked_at IS NOT NULL, replaced_by IS NOT NULL
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`
+
+	var id, userID int
+	var expired, revoked, replaced bool
+	err = tx.QueryRowContext(ctx, lockQuery, hash).Scan(&id, &userID, &expired, &revoked, &replaced)
+	if err != nil {
+		return 0, err
+	}
+
+	if replaced {
+		if err := revokeAll(ctx, tx, userID); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+		return 0, storageErrors.ErrTokenReused
+	}
+
+	if expired || revoked {
+		return 0, sql.ErrNoRows
+	}
+
+	newID, err := insert(ctx, tx, userID, newHash, ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	const revokeQuery = `UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $2 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, revokeQuery, id, newID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Revoke ends the token with hash. Unknown tokens yield sql.ErrNoRows.
+func (s *RefreshTokenStorage) Revoke(ctx context.Context, hash string) error {
+	const query = `
+		UPDATE refresh_tokens
+		SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE token_hash = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeAll ends every token of the user.
+func (s *RefreshTokenStorage) RevokeAll(ctx context.Context, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeAll(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insert(ctx context.Context, tx *sql.Tx, userID int, hash string, ttl time.Duration) (int, error) {
+	const query = `
+		INSERT INTO refresh_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		RETURNING id
+	`
+
+	var id int
+	err := tx.QueryRowContext(ctx, query, userID, hash, ttl.Seconds()).Scan(&id)
+
+	return id, err
+}
+
+func revokeAll(ctx context.Context, tx *sql.Tx, userID int) error {
+	const query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
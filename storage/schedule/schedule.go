@@ -0,0 +1,328 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/postgres"
+	"time"
+)
+
+type ScheduleStorage struct {
+	db *sql.DB
+}
+
+func (s *ScheduleStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewScheduleStorage(cfg *config.Config) (*ScheduleStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScheduleStorage{db: db}, nil
+}
+
+const scheduleColumns = `
+	id,
+	campaign_id,
+	send_at,
+	COALESCE(cron_expression, ''),
+	timezone,
+	status,
+	next_run_at,
+	last_run_at,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSchedule(row scanner) (*model.Schedule, error) {
+	schedule := &model.Schedule{}
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.CampaignID,
+		&schedule.SendAt,
+		&schedule.CronExpression,
+		&schedule.Timezone,
+		&schedule.Status,
+		&schedule.NextRunAt,
+		&schedule.LastRunAt,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+func (s *ScheduleStorage) Get(ctx context.Context, id int) (*model.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules WHERE id = $1`
+	schedule, err := scanSchedule(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return schedule, err
+}
+
+func (s *ScheduleStorage) GetAll(ctx context.Context) ([]*model.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM schedules ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*model.Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func (s *ScheduleStorage) Create(ctx context.Context, schedule *model.Schedule) (*model.Schedule, error) {
+	query := `
+		INSERT INTO schedules (campaign_id, send_at, cron_expression, timezone, status, next_run_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6)
+		RETURNING ` + scheduleColumns
+
+	return scanSchedule(s.db.QueryRowContext(
+		ctx,
+		query,
+		schedule.CampaignID,
+		schedule.SendAt,
+		schedule.CronExpression,
+		schedule.Timezone,
+		schedule.Status,
+		schedule.NextRunAt,
+	))
+}
+
+// UpdateStatus moves a schedule to the given status, provided its current
+// status is one of from.
+func (s *ScheduleStorage) UpdateStatus(
+	ctx context.Context,
+	id int,
+	from []string,
+	to string,
+	nextRunAt *time.Time,
+) (*model.Schedule, error) {
+	query := `
+		UPDATE
+		    schedules
+		SET
+		    status = $1,
+		    next_run_at = $2,
+		    updated_at = NOW()
+		WHERE
+		    id = $3 AND status = ANY($4)
+		RETURNING ` + scheduleColumns
+
+	schedule, err := scanSchedule(s.db.QueryRowContext(ctx, query, to, nextRunAt, id, pq.Array(from)))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return schedule, err
+	}
+
+	existing, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return nil, storageErrors.ErrScheduleStatus
+}
+
+// ClaimDue locks the next active schedule due at now, records a run for its
+// current fire time and advances it to the time returned by next (nil completes
+// the schedule), all in one transaction. Concurrent instances skip schedules
+// locked by others, and the unique (schedule_id, scheduled_for) run guards
+// against firing the same occurrence twice. A nil run means the occurrence had
+// already been fired; a nil schedule means nothing is due.
+//
+// When next fails the schedule is paused instead, so that it does not hold up
+// the schedules due after it, and the error is recorded on the run. The paused
+// schedule is returned with an error wrapping storageErrors.ErrScheduleNextRun.
+func (s *ScheduleStorage) ClaimDue(
+	ctx context.Context,
+	now time.Time,
+	next func(*model.Schedule) (*time.Time, error),
+) (*model.Schedule, *model.ScheduleRun, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM schedules
+		WHERE status = $1 AND next_run_at <= $2
+		ORDER BY next_run_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	schedule, err := scanSchedule(tx.QueryRowContext(ctx, query, model.ScheduleStatusActive, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	const insertRun = `
+		INSERT INTO schedule_runs (schedule_id, scheduled_for)
+		VALUES ($1, $2)
+		ON CONFLICT (schedule_id, scheduled_for) DO NOTHING
+		RETURNING id, schedule_id, scheduled_for, created_at
+	`
+
+	run := &model.ScheduleRun{}
+	err = tx.QueryRowContext(ctx, insertRun, schedule.ID, *schedule.NextRunAt).Scan(
+		&run.ID,
+		&run.ScheduleID,
+		&run.ScheduledFor,
+		&run.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		run = nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	nextRunAt, err := next(schedule)
+	if err != nil {
+		return pause(ctx, tx, schedule, run, err)
+	}
+
+	schedule.Status = model.ScheduleStatusActive
+	if nextRunAt == nil {
+		schedule.Status = model.ScheduleStatusCompleted
+	}
+	schedule.NextRunAt = nextRunAt
+	schedule.LastRunAt = &now
+
+	const advance = `
+		UPDATE
+		    schedules
+		SET
+		    status = $1,
+		    next_run_at = $2,
+		    last_run_at = $3,
+		    updated_at = NOW()
+		WHERE
+		    id = $4
+	`
+
+	if _, err := tx.ExecContext(ctx, advance, schedule.Status, schedule.NextRunAt, now, schedule.ID); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return schedule, run, nil
+}
+
+func pause(
+	ctx context.Context,
+	tx *sql.Tx,
+	schedule *model.Schedule,
+	run *model.ScheduleRun,
+	cause error,
+) (*model.Schedule, *model.ScheduleRun, error) {
+	const pauseSchedule = `UPDATE schedules SET status = $1, updated_at = NOW() WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, pauseSchedule, model.ScheduleStatusPaused, schedule.ID); err != nil {
+		return nil, nil, err
+	}
+
+	if run != nil {
+		const failRun = `UPDATE schedule_runs SET error = $1 WHERE id = $2`
+		if _, err := tx.ExecContext(ctx, failRun, cause.Error(), run.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	schedule.Status = model.ScheduleStatusPaused
+
+	return schedule, nil, fmt.Errorf("%w: %w", storageErrors.ErrScheduleNextRun, cause)
+}
+
+func (s *ScheduleStorage) FinishRun(ctx context.Context, run *model.ScheduleRun) error {
+	const query = `UPDATE schedule_runs SET mail_id = $1, error = NULLIF($2, '') WHERE id = $3`
+	_, err := s.db.ExecContext(ctx, query, run.MailID, run.Error, run.ID)
+
+	return err
+}
+
+func (s *ScheduleStorage) GetRuns(ctx context.Context, scheduleID int) ([]*model.ScheduleRun, error) {
+	const query = `
+		SELECT
+		    id,
+		    schedule_id,
+		    scheduled_for,
+		    mail_id,
+		    COALESCE(error, ''),
+		    created_at
+		FROM
+		    schedule_runs
+		WHERE
+		    schedule_id = $1
+		ORDER BY scheduled_for DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*model.ScheduleRun{}
+	for rows.Next() {
+		run := &model.ScheduleRun{}
+		if err := rows.Scan(
+			&run.ID,
+			&run.ScheduleID,
+			&run.ScheduledFor,
+			&run.MailID,
+			&run.Error,
+			&run.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
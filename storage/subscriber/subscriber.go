@@ -4,13 +4,33 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"github.com/lib/pq"
+	"slices"
 	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/lifecycle"
 	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
 	"subscription-mailing-service/storage/postgres"
+	"time"
+)
+
+const (
+	// defaultPeriodDays is the subscription period when none is configured.
+	defaultPeriodDays = 30
+
+	foreignKeyViolation = "23503"
+	planConstraint      = "subscribers_plan_id_fkey"
+	userConstraint      = "subscribers_user_id_fkey"
 )
 
 type SubscriberStorage struct {
 	db *sql.DB
+	// periodDays is the period of subscriptions that get none from themselves
+	// or their plan.
+	periodDays int
+	// graceDays is how long after expiry a new period still continues the
+	// streak.
+	graceDays int
 }
 
 func (s *SubscriberStorage) Close() error {
@@ -23,57 +43,77 @@ func NewSubscriberStorage(cfg *config.Config) (*SubscriberStorage, error) {
 		return nil, err
 	}
 
-	return &SubscriberStorage{db: db}, nil
+	s := &SubscriberStorage{
+		db:         db,
+		periodDays: cfg.Renewal.PeriodDays,
+		graceDays:  cfg.Renewal.GraceDays,
+	}
+
+	if s.periodDays <= 0 {
+		s.periodDays = defaultPeriodDays
+	}
+	if s.graceDays < 0 {
+		s.graceDays = 0
+	}
+
+	return s, nil
 }
 
-func (s *SubscriberStorage) Get(ctx context.Context, id int) (*model.Subscriber, error) {
-	const query = `
-		SELECT
-		    user_id,
-		    status_subscription,
-		    number_subscriptions,
-		    subscription_time,
-		    subscriptions_in_row,
-		    subscriptions_level
-		    
-		FROM
-		    subscribers
-		WHERE
-		    id = $1
-		    `
+const subscriberColumns = `
+	id,
+	user_id,
+	COALESCE(status_subscription, ''),
+	COALESCE(number_subscriptions, 0),
+	COALESCE(subscription_time, 'epoch'),
+	COALESCE(subscriptions_in_row, 0),
+	COALESCE(subscriptions_level, ''),
+	unsubscribed_at,
+	COALESCE(unsubscribe_reason, ''),
+	confirmed_at,
+	plan_id,
+	COALESCE(period_days, 0),
+	expires_at,
+	created_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
 
+func scanSubscriber(row scanner) (*model.Subscriber, error) {
 	subscriber := &model.Subscriber{}
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
+	if err := row.Scan(
+		&subscriber.ID,
 		&subscriber.UserID,
 		&subscriber.StatusSubscription,
 		&subscriber.NumberSubscriptions,
 		&subscriber.SubscriptionTime,
 		&subscriber.SubscriptionsInRow,
 		&subscriber.SubscriptionLevel,
-	)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
+		&subscriber.UnsubscribedAt,
+		&subscriber.UnsubscribeReason,
+		&subscriber.ConfirmedAt,
+		&subscriber.PlanID,
+		&subscriber.PeriodDays,
+		&subscriber.ExpiresAt,
+		&subscriber.CreatedAt,
+	); err != nil {
+		return nil, err
 	}
 
-	subscriber.ID = id
-	return subscriber, err
+	return subscriber, nil
 }
 
-func (s *SubscriberStorage) GetAll(ctx context.Context) ([]*model.Subscriber, error) {
-	const query = `
-		SELECT
-		    id,
-		    user_id,
-		    status_subscription,
-		    number_subscriptions,
-		    subscription_time,
-		    subscriptions_in_row,
-		    subscriptions_level
-		FROM
-		    subscribers
-	`
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
 
-	rows, err := s.db.QueryContext(ctx, query)
+func (s *SubscriberStorage) list(ctx context.Context, query string, args ...any) ([]*model.Subscriber, error) {
+	return listWith(ctx, s.db, query, args...)
+}
+
+func listWith(ctx context.Context, q querier, query string, args ...any) ([]*model.Subscriber, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -81,16 +121,8 @@ func (s *SubscriberStorage) GetAll(ctx context.Context) ([]*model.Subscriber, er
 
 	subscribers := []*model.Subscriber{}
 	for rows.Next() {
-		subscriber := &model.Subscriber{}
-		if err := rows.Scan(
-			&subscriber.ID,
-			&subscriber.UserID,
-			&subscriber.StatusSubscription,
-			&subscriber.NumberSubscriptions,
-			&subscriber.SubscriptionTime,
-			&subscriber.SubscriptionsInRow,
-			&subscriber.SubscriptionLevel,
-		); err != nil {
+		subscriber, err := scanSubscriber(rows)
+		if err != nil {
 			return nil, err
 		}
 
@@ -103,6 +135,61 @@ func (s *SubscriberStorage) GetAll(ctx context.Context) ([]*model.Subscriber, er
 	return subscribers, nil
 }
 
+func (s *SubscriberStorage) Get(ctx context.Context, id int) (*model.Subscriber, error) {
+	query := `
+		SELECT ` + subscriberColumns + `
+		FROM
+		    subscribers
+		WHERE
+		    id = $1
+	`
+
+	subscriber, err := scanSubscriber(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return subscriber, err
+}
+
+// GetByUser returns the most recent subscription of the user, or nil when the
+// user has none.
+func (s *SubscriberStorage) GetByUser(ctx context.Context, userID int) (*model.Subscriber, error) {
+	query := `
+		SELECT ` + subscriberColumns + `
+		FROM
+		    subscribers
+		WHERE
+		    user_id = $1
+		ORDER BY subscription_time DESC NULLS LAST, id DESC
+		LIMIT 1
+	`
+
+	subscriber, err := scanSubscriber(s.db.QueryRowContext(ctx, query, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return subscriber, nil
+}
+
+func (s *SubscriberStorage) GetAll(ctx context.Context) ([]*model.Subscriber, error) {
+	query := `
+		SELECT ` + subscriberColumns + `
+		FROM
+		    subscribers
+		ORDER BY id
+	`
+
+	return s.list(ctx, query)
+}
+
+// Create stores a new subscription and records its initial status as the
+// first entry of its history.
 func (s *SubscriberStorage) Create(ctx context.Context, subscriber *model.Subscriber) error {
 	const query = `
        INSERT INTO
@@ -113,14 +200,22 @@ func (s *SubscriberStorage) Create(ctx context.Context, subscriber *model.Subscr
             number_subscriptions,
             subscription_time,
             subscriptions_in_row,
-            subscriptions_level
+            subscriptions_level,
+            plan_id,
+            period_days
             )
-       VALUES ($1, $2, $3, $4, $5, $6)
+       VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0))
        RETURNING id
    `
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	var id int
-	err := s.db.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		subscriber.UserID,
@@ -129,9 +224,19 @@ func (s *SubscriberStorage) Create(ctx context.Context, subscriber *model.Subscr
 		subscriber.SubscriptionTime,
 		subscriber.SubscriptionsInRow,
 		subscriber.SubscriptionLevel,
+		subscriber.PlanID,
+		subscriber.PeriodDays,
 	).Scan(&id)
 
 	if err != nil {
+		return referenceError(err)
+	}
+
+	if err := recordTransition(ctx, tx, id, "", subscriber.StatusSubscription, "created"); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 
@@ -140,32 +245,68 @@ func (s *SubscriberStorage) Create(ctx context.Context, subscriber *model.Subscr
 	return nil
 }
 
+// Update writes the counters, the plan and the period of the subscription; a
+// zero period falls back to the one of the plan. A status differing from the current one is
+// applied as a lifecycle transition; an empty status leaves it unchanged.
+// Moving back to pending is refused with a *lifecycle.TransitionError, as it
+// needs a confirmation mail that only the opt-in flow sends.
 func (s *SubscriberStorage) Update(ctx context.Context, subscriber *model.Subscriber, id int) error {
 	const query = `
 		UPDATE
 		    subscribers
 		SET
-		    status_subscription = $1,
-		    number_subscriptions = $2,
-		    subscription_time = $3,
-		    subscriptions_in_row = $4
+		    number_subscriptions = $1,
+		    subscription_time = $2,
+		    subscriptions_in_row = $3,
+		    plan_id = $4,
+		    period_days = NULLIF($5, 0)
 		WHERE
-		    id = $5
+		    id = $6
+		RETURNING status_subscription
 	`
 
-	_, err := s.db.ExecContext(
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	from, _, err := lockStatus(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if subscriber.StatusSubscription == model.SubscriberStatusPending && from != model.SubscriberStatusPending {
+		return &lifecycle.TransitionError{From: from, To: model.SubscriberStatusPending, Reason: "resubscribe through the status route"}
+	}
+
+	if subscriber.StatusSubscription != "" && subscriber.StatusSubscription != from {
+		if err := s.transition(ctx, tx, id, subscriber.StatusSubscription, "updated"); err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(
 		ctx,
 		query,
-		subscriber.StatusSubscription,
 		subscriber.NumberSubscriptions,
 		subscriber.SubscriptionTime,
 		subscriber.SubscriptionsInRow,
+		subscriber.PlanID,
+		subscriber.PeriodDays,
 		id,
-	)
+	).Scan(&subscriber.StatusSubscription)
+	if err != nil {
+		return referenceError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
 	subscriber.ID = id
 
-	return err
+	return nil
 }
 
 func (s *SubscriberStorage) Delete(ctx context.Context, id int) error {
@@ -177,51 +318,689 @@ func (s *SubscriberStorage) Delete(ctx context.Context, id int) error {
 	return err
 }
 
-func (s *SubscriberStorage) LevelUp(ctx context.Context, subscriber *model.Subscriber, id int) error {
-	const query = `UPDATE subscribers SET subscriptions_level = $1 WHERE id = $2`
-	_, err := s.db.ExecContext(ctx, query, subscriber.SubscriptionLevel, id)
+// Transition moves the subscription to another status if the lifecycle allows
+// it, and records the change. A change the lifecycle rejects yields a
+// *lifecycle.TransitionError.
+func (s *SubscriberStorage) Transition(ctx context.Context, id int, to, reason string) (*model.Subscriber, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := s.transition(ctx, tx, id, to, reason); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.Get(ctx, id)
+}
+
+// TransitionFrom is Transition restricted to subscriptions currently in one of
+// the from statuses. Any other status yields a *lifecycle.TransitionError.
+func (s *SubscriberStorage) TransitionFrom(ctx context.Context, id int, from []string, to, reason string) (*model.Subscriber, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current, _, err := lockStatus(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(from, current) {
+		return nil, &lifecycle.TransitionError{From: current, To: to}
+	}
+
+	if err := s.transition(ctx, tx, id, to, reason); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.Get(ctx, id)
+}
+
+// Confirm activates a pending subscription. Confirming an active subscription
+// again is a no-op; any other status yields ErrNotPending.
+func (s *SubscriberStorage) Confirm(ctx context.Context, id int) (*model.Subscriber, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	from, _, err := lockStatus(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch from {
+	case model.SubscriberStatusActive:
+	case model.SubscriberStatusPending:
+		if err := s.transition(ctx, tx, id, model.SubscriberStatusActive, "confirmed"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, storageErrors.ErrNotPending
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.Get(ctx, id)
+}
+
+// ExpirePending marks subscriptions pending since before the given time as
+// expired and returns how many there were.
+func (s *SubscriberStorage) ExpirePending(ctx context.Context, before time.Time) (int64, error) {
+	const query = `
+		WITH expired AS (
+		    UPDATE
+		        subscribers
+		    SET
+		        status_subscription = $1,
+		        status_changed_at = NOW()
+		    WHERE
+		        status_subscription = $2
+		        AND status_changed_at < $3
+		    RETURNING id
+		)
+		INSERT INTO subscriber_transitions(subscriber_id, from_status, to_status, reason)
+		SELECT id, $2, $1, $4 FROM expired
+	`
+
+	res, err := s.db.ExecContext(
+		ctx,
+		query,
+		model.SubscriberStatusExpired,
+		model.SubscriberStatusPending,
+		before,
+		"not confirmed in time",
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Unsubscribe suppresses the address and, for a known user, ends every
+// subscription of theirs that is not unsubscribed yet, recording why. Both
+// happen in one transaction. It returns how many subscriptions were ended.
+func (s *SubscriberStorage) Unsubscribe(ctx context.Context, userID *int, address, reason string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const suppress = `
+		INSERT INTO suppressions(address, reason, source)
+		VALUES (LOWER(TRIM($1)), $2, $3)
+		ON CONFLICT (address) DO NOTHING
+	`
+
+	if _, err := tx.ExecContext(ctx, suppress, address, model.SuppressionReasonUnsubscribed, "unsubscribe link"); err != nil {
+		return 0, err
+	}
+
+	if userID == nil {
+		return 0, tx.Commit()
+	}
+
+	const query = `
+		WITH matched AS (
+		    SELECT id, status_subscription
+		    FROM subscribers
+		    WHERE
+		        user_id = $3
+		        AND (status_subscription = ANY($4) OR NOT COALESCE(status_subscription = ANY($5), FALSE))
+		    FOR UPDATE
+		),
+		changed AS (
+		    UPDATE
+		        subscribers s
+		    SET
+		        status_subscription = $1,
+		        status_changed_at = NOW(),
+		        unsubscribed_at = NOW(),
+		        unsubscribe_reason = $2
+		    FROM
+		        matched
+		    WHERE
+		        s.id = matched.id
+		    RETURNING s.id, matched.status_subscription AS from_status
+		)
+		INSERT INTO subscriber_transitions(subscriber_id, from_status, to_status, reason)
+		SELECT id, from_status, $1, $2 FROM changed
+	`
+
+	res, err := tx.ExecContext(
+		ctx,
+		query,
+		model.SubscriberStatusUnsubscribed,
+		reason,
+		*userID,
+		pq.Array(lifecycle.Sources(model.SubscriberStatusUnsubscribed)),
+		pq.Array(lifecycle.Statuses()),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// GetTransitions returns the status history of the subscription, oldest first.
+func (s *SubscriberStorage) GetTransitions(ctx context.Context, id int) ([]*model.SubscriberTransition, error) {
+	const query = `
+		SELECT
+		    id,
+		    subscriber_id,
+		    COALESCE(from_status, ''),
+		    to_status,
+		    COALESCE(reason, ''),
+		    created_at
+		FROM
+		    subscriber_transitions
+		WHERE
+		    subscriber_id = $1
+		ORDER BY id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []*model.SubscriberTransition{}
+	for rows.Next() {
+		t := &model.SubscriberTransition{}
+		if err := rows.Scan(&t.ID, &t.SubscriberID, &t.From, &t.To, &t.Reason, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, t)
+	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transitions, nil
+}
+
+// lockStatus locks the subscription for the rest of tx and returns its status
+// and whether it was ever confirmed.
+func lockStatus(ctx context.Context, tx *sql.Tx, id int) (string, bool, error) {
+	const query = `
+		SELECT COALESCE(status_subscription, ''), confirmed_at IS NOT NULL
+		FROM subscribers
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	var status string
+	var confirmed bool
+	err := tx.QueryRowContext(ctx, query, id).Scan(&status, &confirmed)
+
+	return status, confirmed, err
+}
+
+// transition applies a status change inside tx. Becoming active marks the
+// subscription confirmed and, unless it is resuming from a pause, starts a new
+// subscription period if none is running; unsubscribing records the reason.
+func (s *SubscriberStorage) transition(ctx context.Context, tx *sql.Tx, id int, to, reason string) error {
+	from, err := setStatus(ctx, tx, id, to, reason)
+	if err != nil {
+		return err
+	}
+
+	if to == model.SubscriberStatusActive && from != model.SubscriberStatusPaused {
+		return s.startPeriod(ctx, tx, id, false)
+	}
+
+	return nil
+}
+
+// setStatus changes the status inside tx if the lifecycle allows it, records
+// the change, and returns the previous status.
+func setStatus(ctx context.Context, tx *sql.Tx, id int, to, reason string) (string, error) {
+	from, confirmed, err := lockStatus(ctx, tx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if err := lifecycle.Check(from, to, confirmed); err != nil {
+		return "", err
+	}
+
+	const query = `
+		UPDATE
+		    subscribers
+		SET
+		    status_subscription = $1::TEXT,
+		    status_changed_at = NOW(),
+		    confirmed_at = CASE WHEN $1::TEXT = $2::TEXT THEN COALESCE(confirmed_at, NOW()) ELSE confirmed_at END,
+		    subscription_time = CASE
+		        WHEN $1::TEXT = $2::TEXT AND $3::TEXT <> $4::TEXT THEN NOW()
+		        ELSE subscription_time
+		    END,
+		    unsubscribed_at = CASE WHEN $1::TEXT = $5::TEXT THEN NOW() ELSE unsubscribed_at END,
+		    unsubscribe_reason = CASE WHEN $1::TEXT = $5::TEXT THEN $6::TEXT ELSE unsubscribe_reason END
+		WHERE
+		    id = $7
+	`
+
+	_, err = tx.ExecContext(
+		ctx,
+		query,
+		to,
+		model.SubscriberStatusActive,
+		from,
+		model.SubscriberStatusPaused,
+		model.SubscriberStatusUnsubscribed,
+		reason,
+		id,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return from, recordTransition(ctx, tx, id, from, to, reason)
+}
+
+// startPeriod starts a new subscription period inside tx and counts it. A
+// period started while the previous one runs extends it; one started within
+// the grace days after the previous one ended continues the streak, any later
+// one starts a new streak. Unless always is set, nothing happens while a
+// period is still running.
+func (s *SubscriberStorage) startPeriod(ctx context.Context, tx *sql.Tx, id int, always bool) error {
+	const query = `
+		UPDATE
+		    subscribers
+		SET
+		    number_subscriptions = COALESCE(number_subscriptions, 0) + 1,
+		    subscriptions_in_row = CASE
+		        WHEN expires_at + make_interval(days => $3) >= NOW() THEN COALESCE(subscriptions_in_row, 0) + 1
+		        ELSE 1
+		    END,
+		    expires_at = GREATEST(expires_at, NOW()) + make_interval(days => COALESCE(
+		        period_days,
+		        (SELECT p.period_days FROM plans p WHERE p.id = subscribers.plan_id),
+		        $2
+		    )),
+		    reminded_at = NULL
+		WHERE
+		    id = $1
+		    AND ($4 OR expires_at IS NULL OR expires_at <= NOW())
+	`
+
+	_, err := tx.ExecContext(ctx, query, id, s.periodDays, s.graceDays, always)
 	return err
 }
 
-func (s *SubscriberStorage) GetByLevel(ctx context.Context, level string) ([]*model.Subscriber, error) {
+// Renew starts the next period of the subscription. A running subscription is
+// extended; an ended one is reactivated, which the lifecycle only allows for
+// subscriptions that were confirmed before. Pending subscriptions have to be
+// confirmed instead.
+func (s *SubscriberStorage) Renew(ctx context.Context, id int) (*model.Subscriber, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	from, _, err := lockStatus(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch from {
+	case model.SubscriberStatusActive, model.SubscriberStatusPaused:
+	case model.SubscriberStatusPending:
+		return nil, &lifecycle.TransitionError{
+			From:   from,
+			To:     model.SubscriberStatusActive,
+			Reason: "subscription has to be confirmed first",
+		}
+	default:
+		if _, err := setStatus(ctx, tx, id, model.SubscriberStatusActive, "renewed"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.startPeriod(ctx, tx, id, true); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.Get(ctx, id)
+}
+
+// ExpireLapsed marks active and paused subscriptions whose period has ended as
+// expired and returns how many there were.
+func (s *SubscriberStorage) ExpireLapsed(ctx context.Context) (int64, error) {
+	const query = `
+		WITH matched AS (
+		    SELECT id, status_subscription
+		    FROM subscribers
+		    WHERE
+		        status_subscription = ANY($3)
+		        AND expires_at <= NOW()
+		    FOR UPDATE SKIP LOCKED
+		),
+		changed AS (
+		    UPDATE
+		        subscribers s
+		    SET
+		        status_subscription = $1,
+		        status_changed_at = NOW()
+		    FROM
+		        matched
+		    WHERE
+		        s.id = matched.id
+		    RETURNING s.id, matched.status_subscription AS from_status
+		)
+		INSERT INTO subscriber_transitions(subscriber_id, from_status, to_status, reason)
+		SELECT id, from_status, $1, $2 FROM changed
+	`
+
+	res, err := s.db.ExecContext(
+		ctx,
+		query,
+		model.SubscriberStatusExpired,
+		"subscription period ended",
+		pq.Array([]string{model.SubscriberStatusActive, model.SubscriberStatusPaused}),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// ClaimExpiring marks active subscriptions ending within the given number of
+// days whose current period was not reminded of yet as reminded, and returns
+// them. Each subscription is claimed by a single caller, so the reminder goes
+// out once even with several instances running.
+func (s *SubscriberStorage) ClaimExpiring(ctx context.Context, days int) ([]*model.Subscriber, error) {
+	query := `
+		UPDATE
+		    subscribers
+		SET
+		    reminded_at = NOW()
+		WHERE id IN (
+		    SELECT id
+		    FROM subscribers
+		    WHERE
+		        status_subscription = $1
+		        AND reminded_at IS NULL
+		        AND expires_at > NOW()
+		        AND expires_at <= NOW() + make_interval(days => $2)
+		    FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + subscriberColumns
+
+	return s.list(ctx, query, model.SubscriberStatusActive, days)
+}
+
+// ReleaseReminder undoes ClaimExpiring for a subscription whose reminder could
+// not be queued, so that it is claimed again.
+func (s *SubscriberStorage) ReleaseReminder(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE subscribers SET reminded_at = NULL WHERE id = $1`, id)
+	return err
+}
+
+// referenceError maps references to a missing plan or user to ErrPlanNotFound
+// and ErrUserNotFound.
+func referenceError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != foreignKeyViolation {
+		return err
+	}
+
+	switch pqErr.Constraint {
+	case planConstraint:
+		return storageErrors.ErrPlanNotFound
+	case userConstraint:
+		return storageErrors.ErrUserNotFound
+	}
+
+	return err
+}
+
+func recordTransition(ctx context.Context, tx *sql.Tx, id int, from, to, reason string) error {
+	const query = `
+		INSERT INTO subscriber_transitions(subscriber_id, from_status, to_status, reason)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''))
+	`
+
+	_, err := tx.ExecContext(ctx, query, id, from, to, reason)
+	return err
+}
+
+// LevelUp sets the level by hand. The change is recorded like any other.
+func (s *SubscriberStorage) LevelUp(ctx context.Context, subscriber *model.Subscriber, id int) error {
+	_, err := s.ChangeLevel(ctx, id, subscriber.SubscriptionLevel, "manual")
+	return err
+}
+
+// ChangeLevel sets the level of the subscription and records the change with
+// the rule that caused it. It returns the change, or nil when the subscription
+// already had the level.
+func (s *SubscriberStorage) ChangeLevel(ctx context.Context, id int, level, rule string) (*model.LevelChange, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	change := &model.LevelChange{SubscriberID: id, To: level, Rule: rule}
+	const lock = `SELECT COALESCE(subscriptions_level, '') FROM subscribers WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, lock, id).Scan(&change.From); err != nil {
+		return nil, err
+	}
+
+	if change.From == level {
+		return nil, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE subscribers SET subscriptions_level = $1 WHERE id = $2`, level, id); err != nil {
+		return nil, err
+	}
+
+	const record = `
+		INSERT INTO subscriber_level_changes(subscriber_id, from_level, to_level, rule)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+		RETURNING id, created_at
+	`
+	if err := tx.QueryRowContext(ctx, record, id, change.From, level, rule).Scan(&change.ID, &change.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// LastLevelRule returns the rule of the latest level change of the
+// subscription, or "" when its level never changed.
+func (s *SubscriberStorage) LastLevelRule(ctx context.Context, id int) (string, error) {
+	const query = `SELECT rule FROM subscriber_level_changes WHERE subscriber_id = $1 ORDER BY id DESC LIMIT 1`
+
+	var rule string
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&rule)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return rule, err
+}
+
+// GetLevelChanges returns the level history of the subscription, oldest
+// first.
+func (s *SubscriberStorage) GetLevelChanges(ctx context.Context, id int) ([]*model.LevelChange, error) {
 	const query = `
 		SELECT
 		    id,
-		    user_id,
-		    status_subscription,
-		    number_subscriptions,
-		    subscription_time,
-		    subscriptions_in_row,
-		    subscriptions_level
-       FROM
-           subscribers
-       WHERE
-           subscriptions_level = $1
-           `
+		    subscriber_id,
+		    COALESCE(from_level, ''),
+		    to_level,
+		    rule,
+		    created_at
+		FROM
+		    subscriber_level_changes
+		WHERE
+		    subscriber_id = $1
+		ORDER BY id
+	`
 
-	rows, err := s.db.QueryContext(ctx, query, level)
+	rows, err := s.db.QueryContext(ctx, query, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var subscribers []*model.Subscriber
+	changes := []*model.LevelChange{}
 	for rows.Next() {
-		subscriber := &model.Subscriber{}
+		change := &model.LevelChange{}
 		if err := rows.Scan(
-			&subscriber.ID,
-			&subscriber.UserID,
-			&subscriber.StatusSubscription,
-			&subscriber.NumberSubscriptions,
-			&subscriber.SubscriptionTime,
-			&subscriber.SubscriptionsInRow,
-			&subscriber.SubscriptionLevel,
+			&change.ID,
+			&change.SubscriberID,
+			&change.From,
+			&change.To,
+			&change.Rule,
+			&change.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
-		subscribers = append(subscribers, subscriber)
+		changes = append(changes, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// GetByStatus lists the subscriptions in any of the given statuses.
+func (s *SubscriberStorage) GetByStatus(ctx context.Context, statuses ...string) ([]*model.Subscriber, error) {
+	query := `
+		SELECT ` + subscriberColumns + `
+		FROM
+		    subscribers
+		WHERE
+		    status_subscription = ANY($1)
+		ORDER BY id
+	`
+
+	return s.list(ctx, query, pq.Array(statuses))
+}
+
+func (s *SubscriberStorage) GetByLevel(ctx context.Context, level string) ([]*model.Subscriber, error) {
+	query := `
+		SELECT ` + subscriberColumns + `
+		FROM
+		    subscribers
+		WHERE
+		    subscriptions_level = $1
+		ORDER BY id
+	`
+
+	return s.list(ctx, query, level)
+}
+
+const orphansQuery = `
+	SELECT ` + subscriberColumns + `
+	FROM
+	    subscribers s
+	WHERE
+	    NOT EXISTS (SELECT 1 FROM users u WHERE u.id = s.user_id)
+	ORDER BY id
+`
+
+// GetOrphans reports the subscriptions that reference a user that does not
+// exist.
+func (s *SubscriberStorage) GetOrphans(ctx context.Context) (*model.OrphanReport, error) {
+	subscribers, err := s.list(ctx, orphansQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	const query = `SELECT convalidated FROM pg_constraint WHERE conname = $1`
+
+	report := &model.OrphanReport{Count: len(subscribers), Subscribers: subscribers}
+	err = s.db.QueryRowContext(ctx, query, userConstraint).Scan(&report.Enforced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// RepairOrphans deletes the subscriptions that reference a user that does not
+// exist, together with their history, and validates the user reference so it
+// holds for every row from then on.
+func (s *SubscriberStorage) RepairOrphans(ctx context.Context) (*model.OrphanReport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	subscribers, err := listWith(ctx, tx, orphansQuery+` FOR UPDATE`)
+	if err != nil {
+		return nil, err
+	}
+
+	const deleteQuery = `
+		DELETE FROM subscribers s
+		WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = s.user_id)
+	`
+
+	res, err := tx.ExecContext(ctx, deleteQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	repaired, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE subscribers VALIDATE CONSTRAINT `+userConstraint); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 
-	return subscribers, rows.Err()
+	return &model.OrphanReport{
+		Count:       len(subscribers),
+		Subscribers: subscribers,
+		Enforced:    true,
+		Repaired:    repaired,
+	}, nil
 }
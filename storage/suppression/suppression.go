@@ -0,0 +1,219 @@
+package suppression
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"strings"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/postgres"
+)
+
+const uniqueViolation = "23505"
+
+type SuppressionStorage struct {
+	db *sql.DB
+}
+
+func (s *SuppressionStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewSuppressionStorage(cfg *config.Config) (*SuppressionStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SuppressionStorage{db: db}, nil
+}
+
+// NormalizeAddress is the form addresses are stored and matched in.
+func NormalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+const suppressionColumns = `
+	id,
+	address,
+	reason,
+	COALESCE(source, ''),
+	created_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSuppression(row scanner) (*model.Suppression, error) {
+	suppression := &model.Suppression{}
+	if err := row.Scan(
+		&suppression.ID,
+		&suppression.Address,
+		&suppression.Reason,
+		&suppression.Source,
+		&suppression.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return suppression, nil
+}
+
+func (s *SuppressionStorage) Get(ctx context.Context, id int) (*model.Suppression, error) {
+	query := `SELECT ` + suppressionColumns + ` FROM suppressions WHERE id = $1`
+
+	suppression, err := scanSuppression(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return suppression, err
+}
+
+// GetByAddress returns the entry suppressing the address, or nil when it is not
+// suppressed.
+func (s *SuppressionStorage) GetByAddress(ctx context.Context, address string) (*model.Suppression, error) {
+	query := `SELECT ` + suppressionColumns + ` FROM suppressions WHERE address = $1`
+
+	suppression, err := scanSuppression(s.db.QueryRowContext(ctx, query, NormalizeAddress(address)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return suppression, err
+}
+
+func (s *SuppressionStorage) GetAll(ctx context.Context) ([]*model.Suppression, error) {
+	query := `SELECT ` + suppressionColumns + ` FROM suppressions ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppressions := []*model.Suppression{}
+	for rows.Next() {
+		suppression, err := scanSuppression(rows)
+		if err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, suppression)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suppressions, nil
+}
+
+// Create adds the address to the list. An address that is already suppressed
+// yields ErrAlreadySuppressed.
+func (s *SuppressionStorage) Create(ctx context.Context, suppression *model.Suppression) error {
+	const query = `
+		INSERT INTO suppressions(address, reason, source)
+		VALUES ($1, $2, NULLIF($3, ''))
+		RETURNING id, address, created_at
+	`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		NormalizeAddress(suppression.Address),
+		suppression.Reason,
+		suppression.Source,
+	).Scan(&suppression.ID, &suppression.Address, &suppression.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return storageErrors.ErrAlreadySuppressed
+	}
+
+	return err
+}
+
+// Import adds the entries in one transaction, leaving out addresses that are
+// already suppressed. It returns the entries that were added.
+func (s *SuppressionStorage) Import(ctx context.Context, suppressions []*model.Suppression) ([]*model.Suppression, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO suppressions(address, reason, source)
+		VALUES ($1, $2, NULLIF($3, ''))
+		ON CONFLICT (address) DO NOTHING
+		RETURNING ` + suppressionColumns
+
+	imported := []*model.Suppression{}
+	for _, suppression := range suppressions {
+		added, err := scanSuppression(tx.QueryRowContext(
+			ctx,
+			query,
+			NormalizeAddress(suppression.Address),
+			suppression.Reason,
+			suppression.Source,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		imported = append(imported, added)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return imported, nil
+}
+
+// Update changes the reason and source of an entry. The address itself is
+// fixed; suppressing another address is a new entry.
+func (s *SuppressionStorage) Update(ctx context.Context, suppression *model.Suppression, id int) error {
+	query := `
+		UPDATE
+		    suppressions
+		SET
+		    reason = $1,
+		    source = NULLIF($2, '')
+		WHERE
+		    id = $3
+		RETURNING ` + suppressionColumns
+
+	updated, err := scanSuppression(s.db.QueryRowContext(ctx, query, suppression.Reason, suppression.Source, id))
+	if err != nil {
+		return err
+	}
+
+	*suppression = *updated
+	return nil
+}
+
+func (s *SuppressionStorage) Delete(ctx context.Context, id int) error {
+	const query = `DELETE FROM suppressions WHERE id = $1`
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package topic
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
+	"subscription-mailing-service/storage/postgres"
+)
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+type TopicStorage struct {
+	db *sql.DB
+}
+
+func (s *TopicStorage) Close() error {
+	return postgres.CloseConnection(s.db)
+}
+
+func NewTopicStorage(cfg *config.Config) (*TopicStorage, error) {
+	db, err := postgres.OpenConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TopicStorage{db: db}, nil
+}
+
+const topicColumns = `
+	key,
+	name,
+	description,
+	default_opt_in,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTopic(row scanner) (*model.Topic, error) {
+	topic := &model.Topic{}
+	err := row.Scan(
+		&topic.Key,
+		&topic.Name,
+		&topic.Description,
+		&topic.DefaultOptIn,
+		&topic.CreatedAt,
+		&topic.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return topic, nil
+}
+
+func (s *TopicStorage) Get(ctx context.Context, key string) (*model.Topic, error) {
+	query := `SELECT ` + topicColumns + ` FROM topics WHERE key = $1`
+	topic, err := scanTopic(s.db.QueryRowContext(ctx, query, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return topic, err
+}
+
+func (s *TopicStorage) GetAll(ctx context.Context) ([]*model.Topic, error) {
+	query := `SELECT ` + topicColumns + ` FROM topics ORDER BY key`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topics := []*model.Topic{}
+	for rows.Next() {
+		topic, err := scanTopic(rows)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return topics, nil
+}
+
+// Missing returns the keys that are not topics, in the order given.
+func (s *TopicStorage) Missing(ctx context.Context, keys []string) ([]string, error) {
+	const query = `
+		SELECT k
+		FROM UNNEST($1::TEXT[]) WITH ORDINALITY AS given(k, n)
+		WHERE NOT EXISTS (SELECT 1 FROM topics t WHERE t.key = given.k)
+		ORDER BY n
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	missing := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		missing = append(missing, key)
+	}
+
+	return missing, rows.Err()
+}
+
+// Create stores a new topic. A taken key yields ErrDuplicateTopic.
+func (s *TopicStorage) Create(ctx context.Context, topic *model.Topic) error {
+	const query = `
+		INSERT INTO topics (key, name, description, default_opt_in)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at, updated_at
+	`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		topic.Key,
+		topic.Name,
+		topic.Description,
+		topic.DefaultOptIn,
+	).Scan(&topic.CreatedAt, &topic.UpdatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return storageErrors.ErrDuplicateTopic
+	}
+
+	return err
+}
+
+// Update changes the name, description and default of the topic. Keys cannot
+// change.
+func (s *TopicStorage) Update(ctx context.Context, topic *model.Topic, key string) error {
+	const query = `
+		UPDATE
+		    topics
+		SET
+		    name = $1,
+		    description = $2,
+		    default_opt_in = $3,
+		    updated_at = NOW()
+		WHERE
+		    key = $4
+		RETURNING created_at, updated_at
+	`
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		topic.Name,
+		topic.Description,
+		topic.DefaultOptIn,
+		key,
+	).Scan(&topic.CreatedAt, &topic.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	topic.Key = key
+
+	return nil
+}
+
+// Delete removes a topic and the preferences for it. Topics of campaigns or
+// plans cannot be deleted and yield ErrTopicInUse.
+func (s *TopicStorage) Delete(ctx context.Context, key string) error {
+	const query = `
+		DELETE FROM topics
+		WHERE
+		    key = $1
+		    AND NOT EXISTS (SELECT 1 FROM plans WHERE $1 = ANY(topics))
+		    AND NOT EXISTS (SELECT 1 FROM campaigns WHERE category = $1)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, key)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+			return storageErrors.ErrTopicInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	topic, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if topic != nil {
+		return storageErrors.ErrTopicInUse
+	}
+
+	return sql.ErrNoRows
+}
+
+// GetPreferences returns the preference of the user for every topic, falling
+// back to the topic's defaults where the user has not chosen.
+func (s *TopicStorage) GetPreferences(ctx context.Context, userID int) ([]*model.TopicPreference, error) {
+	const query = `
+		SELECT
+		    t.key,
+		    t.name,
+		    COALESCE(p.opted_in, t.default_opt_in),
+		    COALESCE(p.frequency, $2),
+		    p.updated_at
+		FROM
+		    topics t
+		    LEFT JOIN user_topic_preferences p ON p.topic = t.key AND p.user_id = $1
+		ORDER BY t.key
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, model.FrequencyImmediate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	preferences := []*model.TopicPreference{}
+	for rows.Next() {
+		p := &model.TopicPreference{}
+		if err := rows.Scan(&p.Topic, &p.Name, &p.OptedIn, &p.Frequency, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		preferences = append(preferences, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return preferences, nil
+}
+
+// SavePreferences stores the given preferences of the user in one go. A
+// preference for a topic that does not exist yields ErrTopicNotFound, a user
+// that does not exist sql.ErrNoRows.
+func (s *TopicStorage) SavePreferences(ctx context.Context, userID int, preferences []*model.TopicPreference) error {
+	const query = `
+		INSERT INTO user_topic_preferences (user_id, topic, opted_in, frequency)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, topic) DO UPDATE SET
+		    opted_in = EXCLUDED.opted_in,
+		    frequency = EXCLUDED.frequency,
+		    updated_at = NOW()
+	`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range preferences {
+		if _, err := tx.ExecContext(ctx, query, userID, p.Topic, p.OptedIn, p.Frequency); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+				if pqErr.Constraint == "user_topic_preferences_topic_fkey" {
+					return storageErrors.ErrTopicNotFound
+				}
+				return sql.ErrNoRows
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
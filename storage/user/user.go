@@ -4,14 +4,28 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 	"subscription-mailing-service/internal/config"
+	"subscription-mailing-service/internal/lifecycle"
 	"subscription-mailing-service/internal/model"
+	storageErrors "subscription-mailing-service/storage/errors"
 	"subscription-mailing-service/storage/postgres"
 )
 
+const uniqueViolation = "23505"
+
+// Policies for the subscriptions of a deleted user.
+const (
+	DeletePolicyBlock     = "block"
+	DeletePolicyCascade   = "cascade"
+	DeletePolicyAnonymize = "anonymize"
+)
+
 type UserStorage struct {
-	db *sql.DB
+	db           *sql.DB
+	deletePolicy string
 }
 
 func (s *UserStorage) Close() error {
@@ -19,24 +33,47 @@ func (s *UserStorage) Close() error {
 }
 
 func NewUserStorage(cfg *config.Config) (*UserStorage, error) {
+	policy := cfg.Users.DeletePolicy
+	switch policy {
+	case "":
+		policy = DeletePolicyBlock
+	case DeletePolicyBlock, DeletePolicyCascade, DeletePolicyAnonymize:
+	default:
+		return nil, fmt.Errorf("unknown user delete policy %q", policy)
+	}
+
 	db, err := postgres.OpenConnection(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &UserStorage{db: db}, nil
+	return &UserStorage{db: db, deletePolicy: policy}, nil
 }
 
-func (s *UserStorage) Create(ctx context.Context, user *model.User) (*model.User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+// Create stores a new user with a hashed password. Users without a role
+// become subscribers. A taken login yields ErrDuplicateLogin.
+func (s *UserStorage) Create(ctx context.Context, req *model.UserRequest) (*model.User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
 	}
-	user.Password = string(hashedPassword)
+
+	user := &model.User{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Login:     req.Login,
+		Email:     req.Email,
+		Password:  string(hashedPassword),
+		Role:      req.Role,
+	}
+
+	if user.Role == "" {
+		user.Role = model.RoleSubscriber
+	}
 
 	const query = `
-        INSERT INTO users (first_name, last_name, login, email, password)  
-        VALUES ($1, $2, $3, $4, $5)  
+        INSERT INTO users (first_name, last_name, login, email, password, role)  
+        VALUES ($1, $2, $3, $4, $5, $6)  
         RETURNING id
     `
 
@@ -49,18 +86,55 @@ func (s *UserStorage) Create(ctx context.Context, user *model.User) (*model.User
 		user.Login,
 		user.Email,
 		user.Password,
+		user.Role,
 	).Scan(&id)
 
 	if err != nil {
-		return nil, err
+		return nil, loginError(err)
 	}
 
 	user.ID = id
 	return user, nil
 }
 
+// EnsureAdmin creates an admin with the login and password unless there is an
+// admin already, so a fresh installation can be logged into. It reports
+// whether the admin was created, and returns ErrDuplicateLogin when the login
+// belongs to a user who is not an admin.
+func (s *UserStorage) EnsureAdmin(ctx context.Context, login, email, password string) (bool, error) {
+	const query = `SELECT EXISTS (SELECT 1 FROM users WHERE role = $1)`
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, query, model.RoleAdmin).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	_, err := s.Create(ctx, &model.UserRequest{Login: login, Email: email, Password: password, Role: model.RoleAdmin})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (s *UserStorage) Get(ctx context.Context, id int) (*model.User, error) {
-	const query = `SELECT first_name, last_name, login, email, password FROM users WHERE id = $1`
+	const query = `
+		SELECT
+		    COALESCE(first_name, ''),
+		    COALESCE(last_name, ''),
+		    login,
+		    COALESCE(email, ''),
+		    COALESCE(password, ''),
+		    role
+		FROM
+		    users
+		WHERE
+		    id = $1
+	`
 	user := &model.User{}
 	err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&user.FirstName,
@@ -68,6 +142,7 @@ func (s *UserStorage) Get(ctx context.Context, id int) (*model.User, error) {
 		&user.Login,
 		&user.Email,
 		&user.Password,
+		&user.Role,
 	)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
@@ -77,9 +152,84 @@ func (s *UserStorage) Get(ctx context.Context, id int) (*model.User, error) {
 	return user, err
 }
 
+// GetByLogin returns the user with the login, password hash included, or nil
+// when there is none.
+func (s *UserStorage) GetByLogin(ctx context.Context, login string) (*model.User, error) {
+	const query = `
+		SELECT
+		    id,
+		    COALESCE(first_name, ''),
+		    COALESCE(last_name, ''),
+		    login,
+		    COALESCE(email, ''),
+		    COALESCE(password, ''),
+		    role
+		FROM
+		    users
+		WHERE
+		    login = $1
+	`
+	user := &model.User{}
+	err := s.db.QueryRowContext(ctx, query, login).Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Login,
+		&user.Email,
+		&user.Password,
+		&user.Role,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetByEmail returns the users with the address, compared case-insensitively.
+func (s *UserStorage) GetByEmail(ctx context.Context, email string) ([]*model.User, error) {
+	const query = `
+		SELECT
+		    id,
+		    COALESCE(first_name, ''),
+		    COALESCE(last_name, ''),
+		    login,
+		    COALESCE(email, ''),
+		    COALESCE(password, ''),
+		    role
+		FROM
+		    users
+		WHERE
+		    LOWER(email) = LOWER($1)
+		ORDER BY id
+	`
+
+	return s.list(ctx, query, email)
+}
+
 func (s *UserStorage) GetAll(ctx context.Context) ([]*model.User, error) {
-	const query = `SELECT id, first_name, last_name, login, email, password FROM users`
-	rows, err := s.db.QueryContext(ctx, query)
+	const query = `
+		SELECT
+		    id,
+		    COALESCE(first_name, ''),
+		    COALESCE(last_name, ''),
+		    login,
+		    COALESCE(email, ''),
+		    COALESCE(password, ''),
+		    role
+		FROM
+		    users
+		ORDER BY id
+	`
+
+	return s.list(ctx, query)
+}
+
+func (s *UserStorage) list(ctx context.Context, query string, args ...any) ([]*model.User, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +245,7 @@ func (s *UserStorage) GetAll(ctx context.Context) ([]*model.User, error) {
 			&user.Login,
 			&user.Email,
 			&user.Password,
+			&user.Role,
 		); err != nil {
 			return nil, err
 		}
@@ -103,16 +254,50 @@ func (s *UserStorage) GetAll(ctx context.Context) ([]*model.User, error) {
 	return users, rows.Err()
 }
 
-func (s *UserStorage) Update(ctx context.Context, user *model.User, id int) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+// Update changes the profile of the user and returns the user as stored. A
+// taken login yields ErrDuplicateLogin.
+func (s *UserStorage) Update(ctx context.Context, update *model.UserUpdate, id int) (*model.User, error) {
+	const query = `
+		UPDATE users
+		SET first_name = $1, last_name = $2, login = $3, email = $4
+		WHERE id = $5
+		RETURNING COALESCE(password, ''), role
+	`
+
+	user := &model.User{
+		ID:        id,
+		FirstName: update.FirstName,
+		LastName:  update.LastName,
+		Login:     update.Login,
+		Email:     update.Email,
+	}
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		update.FirstName,
+		update.LastName,
+		update.Login,
+		update.Email,
+		id,
+	).Scan(&user.Password, &user.Role)
 	if err != nil {
-		return err
+		return nil, loginError(err)
 	}
 
-	user.Password = string(hashedPassword)
+	return user, nil
+}
 
-	const query = `UPDATE users SET first_name = $1, last_name = $2, login = $3, email = $4, password = $5 WHERE id = $6`
-	result, err := s.db.ExecContext(ctx, query, user.FirstName, user.LastName, user.Login, user.Email, user.Password, id)
+// SetPassword hashes the password and stores it as the user's password.
+// Anonymized users, whose address is gone, are treated as missing.
+func (s *UserStorage) SetPassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	const query = `UPDATE users SET password = $1 WHERE id = $2 AND email IS NOT NULL`
+	result, err := s.db.ExecContext(ctx, query, string(hashedPassword), id)
 	if err != nil {
 		return err
 	}
@@ -126,14 +311,12 @@ func (s *UserStorage) Update(ctx context.Context, user *model.User, id int) erro
 		return sql.ErrNoRows
 	}
 
-	user.ID = id
-
 	return nil
 }
 
-func (s *UserStorage) Delete(ctx context.Context, id int) error {
-	const query = `DELETE FROM users WHERE id = $1`
-	result, err := s.db.ExecContext(ctx, query, id)
+// SetRole changes the role of the user.
+func (s *UserStorage) SetRole(ctx context.Context, id int, role string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE users SET role = $1 WHERE id = $2`, role, id)
 	if err != nil {
 		return err
 	}
@@ -149,3 +332,171 @@ func (s *UserStorage) Delete(ctx context.Context, id int) error {
 
 	return nil
 }
+
+// Delete removes the user according to the delete policy. A user without
+// subscriptions is always removed. Otherwise "block" refuses with
+// ErrUserHasSubscribers, "cascade" removes the subscriptions too and
+// "anonymize" ends them and keeps the user without personal data, in which
+// case anonymized is true.
+func (s *UserStorage) Delete(ctx context.Context, id int) (anonymized bool, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Locking the user keeps new subscriptions from referencing it meanwhile.
+	const lockQuery = `
+		SELECT (SELECT COUNT(*) FROM subscribers WHERE user_id = u.id)
+		FROM users u
+		WHERE u.id = $1
+		FOR UPDATE
+	`
+
+	var subscriptions int
+	if err := tx.QueryRowContext(ctx, lockQuery, id).Scan(&subscriptions); err != nil {
+		return false, err
+	}
+
+	switch {
+	case subscriptions == 0:
+	case s.deletePolicy == DeletePolicyBlock:
+		return false, storageErrors.ErrUserHasSubscribers
+	case s.deletePolicy == DeletePolicyAnonymize:
+		if err := anonymize(ctx, tx, id); err != nil {
+			return false, err
+		}
+		return true, tx.Commit()
+	default:
+		if _, err := tx.ExecContext(ctx, `DELETE FROM subscribers WHERE user_id = $1`, id); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id); err != nil {
+		return false, err
+	}
+
+	return false, tx.Commit()
+}
+
+// anonymize ends the subscriptions of the user and strips the user of
+// everything personal. The login is replaced by a random one nobody can have
+// taken, and without a password, sessions or address nobody can log in or be
+// mailed. The address is also removed from the mails sent to the user, unless
+// another user still has it.
+func anonymize(ctx context.Context, tx *sql.Tx, id int) error {
+	const unsubscribeQuery = `
+		WITH changed AS (
+		    UPDATE
+		        subscribers s
+		    SET
+		        status_subscription = $1,
+		        status_changed_at = NOW(),
+		        unsubscribed_at = NOW(),
+		        unsubscribe_reason = $2
+		    FROM
+		        (SELECT id, status_subscription FROM subscribers WHERE user_id = $3 FOR UPDATE) matched
+		    WHERE
+		        s.id = matched.id
+		        AND (matched.status_subscription = ANY($4) OR NOT COALESCE(matched.status_subscription = ANY($5), FALSE))
+		    RETURNING s.id, matched.status_subscription AS from_status
+		)
+		INSERT INTO subscriber_transitions(subscriber_id, from_status, to_status, reason)
+		SELECT id, from_status, $1, $2 FROM changed
+	`
+
+	_, err := tx.ExecContext(
+		ctx,
+		unsubscribeQuery,
+		model.SubscriberStatusUnsubscribed,
+		"user deleted",
+		id,
+		pq.Array(lifecycle.Sources(model.SubscriberStatusUnsubscribed)),
+		pq.Array(lifecycle.Statuses()),
+	)
+	if err != nil {
+		return err
+	}
+
+	const userQuery = `
+		UPDATE
+		    users u
+		SET
+		    first_name = NULL,
+		    last_name = NULL,
+		    login = 'deleted-' || gen_random_uuid(),
+		    email = NULL,
+		    password = NULL,
+		    role = 'subscriber'
+		FROM
+		    (SELECT LOWER(TRIM(COALESCE(email, ''))) AS email FROM users WHERE id = $1) old
+		WHERE
+		    u.id = $1
+		RETURNING
+		    CASE
+		        WHEN EXISTS (SELECT 1 FROM users o WHERE LOWER(TRIM(o.email)) = old.email AND o.id <> $1) THEN ''
+		        ELSE old.email
+		    END
+	`
+
+	// email is empty when there was none or another user shares it.
+	var email string
+	if err := tx.QueryRowContext(ctx, userQuery, id).Scan(&email); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+
+	const recipientsQuery = `
+		UPDATE
+		    mail_recipients
+		SET
+		    address = 'deleted-' || id,
+		    updated_at = NOW()
+		WHERE
+		    user_id = $1 OR ($2 <> '' AND LOWER(TRIM(address)) = $2)
+	`
+
+	if _, err := tx.ExecContext(ctx, recipientsQuery, id, email); err != nil {
+		return err
+	}
+
+	if email != "" {
+		const mailsQuery = `
+			UPDATE
+			    mails
+			SET
+			    to_list = ARRAY(
+			        SELECT CASE WHEN LOWER(TRIM(t.addr)) = $1 THEN 'deleted' ELSE t.addr END
+			        FROM UNNEST(to_list) WITH ORDINALITY AS t(addr, n)
+			        ORDER BY t.n
+			    )
+			WHERE
+			    EXISTS (SELECT 1 FROM UNNEST(to_list) AS addr WHERE LOWER(TRIM(addr)) = $1)
+		`
+
+		if _, err := tx.ExecContext(ctx, mailsQuery, email); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_topic_preferences WHERE user_id = $1`, id)
+	return err
+}
+
+// loginError maps a taken login to ErrDuplicateLogin.
+func loginError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return storageErrors.ErrDuplicateLogin
+	}
+
+	return err
+}